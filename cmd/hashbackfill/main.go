@@ -0,0 +1,50 @@
+// Command hashbackfill stores the sha256 of images that were uploaded before it was computed
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/eirka/eirka-libs/db"
+
+	local "github.com/eirka/eirka-post/config"
+	u "github.com/eirka/eirka-post/utils"
+)
+
+func main() {
+	dir := flag.String("dir", "", "directory with the image files, empty reads from the configured storage")
+	batch := flag.Int("batch", 500, "images hashed per query")
+	flag.Parse()
+
+	// Database connection settings
+	dbase := db.Database{
+		User:           local.Settings.Database.User,
+		Password:       local.Settings.Database.Password,
+		Proto:          local.Settings.Database.Protocol,
+		Host:           local.Settings.Database.Host,
+		Database:       local.Settings.Database.Database,
+		MaxIdle:        local.Settings.Post.DatabaseMaxIdle,
+		MaxConnections: local.Settings.Post.DatabaseMaxConnections,
+	}
+
+	// Set up DB connection
+	dbase.NewDb()
+
+	var store u.Storage = &u.LocalStorage{Dir: *dir}
+
+	if *dir == "" {
+		var err error
+
+		store, err = u.NewStorage(u.AreaImages)
+		if err != nil {
+			log.Fatalf("problem opening storage: %v", err)
+		}
+	}
+
+	stats, err := u.BackfillSHA256(store, *batch)
+	if err != nil {
+		log.Fatalf("backfill failed after %d images: %v", stats.Updated, err)
+	}
+
+	log.Printf("updated %d images, %d missing, %d did not match their md5, %d banned files", stats.Updated, stats.Missing, stats.Mismatched, stats.Bans)
+}
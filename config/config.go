@@ -19,6 +19,7 @@ func init() {
 				ImageDir:     "/tmp/eirka/src/",
 				ThumbnailDir: "/tmp/eirka/thumb/",
 				AvatarDir:    "/tmp/eirka/avatars/",
+				UploadDir:    "/tmp/eirka/uploads/",
 			},
 		}
 		return
@@ -45,6 +46,9 @@ var Settings *Config
 type Config struct {
 	Post        Post
 	Directories Directories
+	Storage     Storage
+	Uploads     Uploads
+	Posting     Posting
 	CORS        CORS
 	Database    Database
 	Redis       Redis
@@ -58,6 +62,9 @@ type Post struct {
 	DatabaseMaxConnections int
 	RedisMaxIdle           int
 	RedisMaxConnections    int
+	// ImageProcessor is the thumbnail backend, imagemagick or native
+	// empty uses imagemagick when convert is installed and native otherwise
+	ImageProcessor string
 }
 
 // Database holds the connection settings for MySQL
@@ -80,6 +87,87 @@ type Directories struct {
 	ImageDir     string
 	ThumbnailDir string
 	AvatarDir    string
+	// UploadDir holds unfinished resumable uploads
+	UploadDir string
+}
+
+// Storage sets where uploaded files are kept after processing
+type Storage struct {
+	// Backend is local or s3
+	Backend string
+	// Endpoint overrides the amazon endpoint for s3 compatible servers
+	Endpoint string
+}
+
+// Uploads sets options for processing uploaded files
+type Uploads struct {
+	// PHashDistance is the max hamming distance between perceptual hashes
+	// for a file to count as banned or a duplicate, unset uses 4,
+	// 0 only matches identical hashes and anything above 4 uses 4
+	PHashDistance *int
+	// KeepOriginal lists boards that store uploads without stripping metadata
+	KeepOriginal []uint
+	// VideoConvert is empty to only strip the metadata from mp4 and mov uploads
+	// (KeepOriginal boards keep them as they are), remux to rewrite them as a clean mp4, or webm to transcode them
+	VideoConvert string
+	// Workers is how many media jobs run at once, 0 uses the cpu count
+	Workers int
+	// QueueSize is how many media jobs can wait for a worker, 0 is four per worker
+	QueueSize int
+	// MaxMegapixels is the most pixels an image can decode to, 0 uses 50
+	MaxMegapixels int
+	// GIFMaxFrames is the most frames an animated gif can have, 0 uses 1000
+	GIFMaxFrames int
+	// GIFMaxDuration is the longest an animated gif can play in seconds, 0 uses 300
+	GIFMaxDuration int
+	// PNGMaxChunk is the largest ancillary png chunk in bytes, 0 uses 1MB
+	PNGMaxChunk int
+	// Thumbnails are extra thumbnail sizes made for every post image
+	Thumbnails []ThumbnailProfile
+	// AnimatedThumbnails makes a looping preview for animated gifs and videos that are not spoilered
+	AnimatedThumbnails bool
+	// AnimatedFormat is webp or webm, empty uses webp
+	AnimatedFormat string
+	// AnimatedMaxDuration is the longest preview in seconds, 0 uses 3
+	AnimatedMaxDuration int
+	// AnimatedMaxSize is the largest preview in bytes, 0 uses 1MB
+	AnimatedMaxSize int
+	// AudioMaxDuration is the longest audio upload in seconds, 0 uses 600
+	AudioMaxDuration int
+	// AudioMaxBitrate is the highest audio bitrate in bits per second, 0 uses 1600000
+	AudioMaxBitrate int
+	// AudioBoards lists boards without an upload policy that accept audio uploads
+	AudioBoards []uint
+	// MaxFiles is how many files a post can have when the board has no policy, 0 uses 1
+	MaxFiles int
+	// ResumableExpiry is how many minutes an unfinished resumable upload is kept, 0 uses 1440
+	ResumableExpiry int
+}
+
+// Posting sets options for posts after they are made
+type Posting struct {
+	// EditWindow is how many minutes the author can edit a post, 0 uses 10
+	EditWindow int
+	// Secret keys the poster ids and tripcodes, both are off without it
+	Secret string
+	// PosterIDs lists boards that give posters an id in each thread
+	PosterIDs []uint
+	// Tripcodes lists boards that accept a name with a tripcode
+	Tripcodes []uint
+	// BumpLimit is how many posts a thread can have before replies stop bumping it,
+	// 0 or anything above the post limit uses the post limit
+	BumpLimit uint
+}
+
+// ThumbnailProfile is a named thumbnail size
+type ThumbnailProfile struct {
+	// Name is recorded with the thumbnail and added to its filename
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+	// Format is jpg or png
+	Format  string
+	Quality int
 }
 
 // CORS is a list of allowed remote addresses
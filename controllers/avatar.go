@@ -35,7 +35,7 @@ func AvatarController(c *gin.Context) {
 	// Save the image to a file
 	err = image.SaveAvatar()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+		c.JSON(uploadStatus(c, err), gin.H{"error_message": err.Error()})
 		c.Error(err).SetMeta("AvatarController.SaveAvatar")
 		return
 	}
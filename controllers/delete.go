@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/eirka/eirka-libs/audit"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+	"github.com/eirka/eirka-libs/user"
+
+	"github.com/eirka/eirka-post/models"
+	u "github.com/eirka/eirka-post/utils"
+)
+
+// auditDeleteImage is the audit action for an author removing the image from their post
+const auditDeleteImage = "Image Deleted"
+
+// deleteForm contains the post to delete
+type deleteForm struct {
+	Thread    uint `json:"thread" binding:"required"`
+	Post      uint `json:"post" binding:"required"`
+	ImageOnly bool `json:"image_only"`
+}
+
+// DeleteController lets the author delete their post or only its image
+func DeleteController(c *gin.Context) {
+	var err error
+	var df deleteForm
+
+	// get userdata from session middleware
+	userdata := c.MustGet("userdata").(user.User)
+
+	err = c.Bind(&df)
+	if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInvalidParam))
+		c.Error(err).SetMeta("DeleteController.Bind")
+		return
+	}
+
+	// Set parameters to DeleteModel
+	m := models.DeleteModel{
+		UID:       userdata.ID,
+		Thread:    df.Thread,
+		Num:       df.Post,
+		ImageOnly: df.ImageOnly,
+	}
+
+	// Validate input parameters
+	err = m.ValidateInput()
+	if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInvalidParam))
+		c.Error(err).SetMeta("DeleteController.ValidateInput")
+		return
+	}
+
+	// Check the post owner
+	err = m.Status()
+	if err == e.ErrNotFound {
+		c.JSON(e.ErrorMessage(e.ErrNotFound))
+		c.Error(err).SetMeta("DeleteController.Status")
+		return
+	} else if err == e.ErrForbidden {
+		c.JSON(e.ErrorMessage(e.ErrForbidden))
+		c.Error(err).SetMeta("DeleteController.Status")
+		return
+	} else if err == models.ErrEmptyPost {
+		c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+		c.Error(err).SetMeta("DeleteController.Status")
+		return
+	} else if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInternalError))
+		c.Error(err).SetMeta("DeleteController.Status")
+		return
+	}
+
+	// Post data
+	err = m.Post()
+	if err == models.ErrNoImage {
+		c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+		c.Error(err).SetMeta("DeleteController.Post")
+		return
+	} else if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInternalError))
+		c.Error(err).SetMeta("DeleteController.Post")
+		return
+	}
+
+	// the post is committed so the files can go
+	for _, image := range m.Images {
+		u.DeletePostedImage(image.Filename, image.Thumbnail, image.Thumbnails)
+	}
+
+	// Delete redis stuff
+	redisErr := redis.NewKey("index").SetKey(fmt.Sprintf("%d", m.Ib), "0").Delete()
+	if redisErr != nil {
+		c.Error(redisErr).SetMeta("DeleteController.redis.Index.Delete")
+	}
+
+	directoryKey := fmt.Sprintf("%s:%d", "directory", m.Ib)
+	threadKey := fmt.Sprintf("%s:%d:%d", "thread", m.Ib, m.Thread)
+	imageKey := fmt.Sprintf("%s:%d", "image", m.Ib)
+
+	// Continue even if redis fails since the post was already deleted
+	redisErr = redis.Cache.Delete(directoryKey, threadKey, imageKey)
+	if redisErr != nil {
+		c.Error(redisErr).SetMeta("DeleteController.redis.Cache.Delete")
+	}
+
+	action := audit.AuditDeletePost
+	info := fmt.Sprintf("%d/%d", m.Thread, m.Num)
+
+	if m.ImageOnly {
+		action = auditDeleteImage
+	} else if m.IsThread() {
+		action = audit.AuditDeleteThread
+		info = fmt.Sprintf("%d", m.Thread)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success_message": action})
+
+	audit := audit.Audit{
+		User:   userdata.ID,
+		Ib:     m.Ib,
+		Type:   audit.BoardLog,
+		IP:     c.ClientIP(),
+		Action: action,
+		Info:   info,
+	}
+
+	// submit audit
+	err = audit.Submit()
+	if err != nil {
+		c.Error(err).SetMeta("DeleteController.audit.Submit")
+	}
+
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/audit"
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+	"github.com/eirka/eirka-libs/user"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+func deleteRouter(userdata user.User) *gin.Engine {
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.TrustedPlatform = "X-Real-IP"
+
+	router.Use(func(c *gin.Context) {
+		c.Set("userdata", userdata)
+		c.Next()
+	})
+
+	router.POST("/post/delete", DeleteController)
+
+	return router
+}
+
+func TestDeleteController(t *testing.T) {
+
+	var err error
+
+	router := deleteRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	redis.NewRedisMock()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	statusRows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "empty"}).AddRow(5, 2, 1, 0)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 2).
+		WillReturnRows(statusRows)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE posts SET post_deleted = 1`).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectExec(`INSERT INTO audit`).
+		WithArgs(2, 1, audit.BoardLog, "127.0.0.1", audit.AuditDeletePost, "1/2").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	redis.Cache.Mock.Command("DEL", "directory:1", "thread:1:1", "image:1")
+
+	first := performJSONRequest(router, "POST", "/post/delete", []byte(`{"thread": 1, "post": 2}`))
+
+	assert.Equal(t, 200, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, successMessage(audit.AuditDeletePost), first.Body.String(), "HTTP response should match")
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestDeleteControllerThread(t *testing.T) {
+
+	var err error
+
+	router := deleteRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	redis.NewRedisMock()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	statusRows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "empty"}).AddRow(4, 2, 1, 0)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 1).
+		WillReturnRows(statusRows)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE threads SET thread_deleted = 1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectExec(`INSERT INTO audit`).
+		WithArgs(2, 1, audit.BoardLog, "127.0.0.1", audit.AuditDeleteThread, "1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	redis.Cache.Mock.Command("DEL", "directory:1", "thread:1:1", "image:1")
+
+	first := performJSONRequest(router, "POST", "/post/delete", []byte(`{"thread": 1, "post": 1}`))
+
+	assert.Equal(t, 200, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, successMessage(audit.AuditDeleteThread), first.Body.String(), "HTTP response should match")
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestDeleteControllerImageOnly(t *testing.T) {
+
+	var err error
+
+	originalDirs := local.Settings.Directories
+	defer func() {
+		local.Settings.Directories = originalDirs
+	}()
+
+	local.Settings.Directories.ImageDir = t.TempDir()
+	local.Settings.Directories.ThumbnailDir = t.TempDir()
+
+	image := filepath.Join(local.Settings.Directories.ImageDir, "1.jpg")
+	thumb := filepath.Join(local.Settings.Directories.ThumbnailDir, "1s.jpg")
+
+	assert.NoError(t, os.WriteFile(image, []byte("image"), 0644))
+	assert.NoError(t, os.WriteFile(thumb, []byte("thumb"), 0644))
+
+	router := deleteRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	redis.NewRedisMock()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	statusRows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "empty"}).AddRow(5, 2, 1, 0)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 2).
+		WillReturnRows(statusRows)
+
+	mock.ExpectBegin()
+	imageRows := sqlmock.NewRows([]string{"image_id", "image_file", "image_thumbnail"}).AddRow(7, "1.jpg", "1s.jpg")
+	mock.ExpectQuery(`SELECT image_id, image_file, image_thumbnail FROM images`).
+		WithArgs(5).
+		WillReturnRows(imageRows)
+	mock.ExpectQuery(`SELECT thumbnail_file FROM image_thumbnails`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"thumbnail_file"}))
+	mock.ExpectExec(`UPDATE images SET image_deleted = 1`).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectExec(`INSERT INTO audit`).
+		WithArgs(2, 1, audit.BoardLog, "127.0.0.1", auditDeleteImage, "1/2").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	redis.Cache.Mock.Command("DEL", "directory:1", "thread:1:1", "image:1")
+
+	first := performJSONRequest(router, "POST", "/post/delete", []byte(`{"thread": 1, "post": 2, "image_only": true}`))
+
+	assert.Equal(t, 200, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, successMessage(auditDeleteImage), first.Body.String(), "HTTP response should match")
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+	_, err = os.Stat(image)
+	assert.True(t, os.IsNotExist(err), "Image should be removed")
+
+	_, err = os.Stat(thumb)
+	assert.True(t, os.IsNotExist(err), "Thumbnail should be removed")
+
+}
+
+func TestDeleteControllerErrors(t *testing.T) {
+
+	var err error
+
+	router := deleteRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 2).
+		WillReturnError(sql.ErrNoRows)
+
+	otherRows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "empty"}).AddRow(5, 3, 1, 0)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 2).
+		WillReturnRows(otherRows)
+
+	// the post has no comment
+	emptyRows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "empty"}).AddRow(5, 2, 1, 1)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 2).
+		WillReturnRows(emptyRows)
+
+	first := performJSONRequest(router, "POST", "/post/delete", []byte(`{"thread": 1, "post": 2}`))
+
+	assert.Equal(t, 404, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(e.ErrNotFound), first.Body.String(), "HTTP response should match")
+
+	second := performJSONRequest(router, "POST", "/post/delete", []byte(`{"thread": 1, "post": 2}`))
+
+	assert.Equal(t, 403, second.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(e.ErrForbidden), second.Body.String(), "HTTP response should match")
+
+	third := performJSONRequest(router, "POST", "/post/delete", []byte(`{"thread": 1}`))
+
+	assert.Equal(t, 400, third.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(e.ErrInvalidParam), third.Body.String(), "HTTP response should match")
+
+	fourth := performJSONRequest(router, "POST", "/post/delete", []byte(`{"thread": 1, "post": 2, "image_only": true}`))
+
+	assert.Equal(t, 400, fourth.Code, "HTTP request code should match")
+	assert.JSONEq(t, `{"error_message":"post would be empty, delete the post instead"}`, fourth.Body.String(), "HTTP response should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
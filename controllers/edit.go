@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/eirka/eirka-libs/audit"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+	"github.com/eirka/eirka-libs/user"
+
+	"github.com/eirka/eirka-post/models"
+	u "github.com/eirka/eirka-post/utils"
+)
+
+// auditEditPost is the audit action for an author editing their post
+const auditEditPost = "Post Edited"
+
+// editForm contains the new comment for a post
+// it is a form post so the spam filter can read the comment
+type editForm struct {
+	Thread  uint   `form:"thread" binding:"required"`
+	Post    uint   `form:"post" binding:"required"`
+	Comment string `form:"comment"`
+}
+
+// EditController lets the author change the comment of their post for a while after posting
+func EditController(c *gin.Context) {
+	var err error
+	var ef editForm
+	req := c.Request
+
+	// get userdata from session middleware
+	userdata := c.MustGet("userdata").(user.User)
+
+	err = c.Bind(&ef)
+	if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInvalidParam))
+		c.Error(err).SetMeta("EditController.Bind")
+		return
+	}
+
+	// Set parameters to EditModel
+	m := models.EditModel{
+		UID:     userdata.ID,
+		Thread:  ef.Thread,
+		Num:     ef.Post,
+		IP:      c.ClientIP(),
+		Comment: ef.Comment,
+	}
+
+	// Validate input parameters
+	err = m.ValidateInput()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+		c.Error(err).SetMeta("EditController.ValidateInput")
+		return
+	}
+
+	// Check the post owner and edit window
+	err = m.Status()
+	if err == e.ErrNotFound {
+		c.JSON(e.ErrorMessage(e.ErrNotFound))
+		c.Error(err).SetMeta("EditController.Status")
+		return
+	} else if err == e.ErrForbidden {
+		c.JSON(e.ErrorMessage(e.ErrForbidden))
+		c.Error(err).SetMeta("EditController.Status")
+		return
+	} else if err == e.ErrThreadClosed || err == models.ErrEditWindow {
+		c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+		c.Error(err).SetMeta("EditController.Status")
+		return
+	} else if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInternalError))
+		c.Error(err).SetMeta("EditController.Status")
+		return
+	}
+
+	// Check comment in SFS and Akismet
+	akismet := u.Akismet{
+		IP:      m.IP,
+		Ua:      req.UserAgent(),
+		Referer: req.Referer(),
+		Comment: m.Comment,
+	}
+
+	err = akismet.Check()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+		c.Error(err).SetMeta("EditController.CheckAkismet")
+		return
+	}
+
+	// Post data
+	err = m.Post()
+	if err != nil {
+		if quoteError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+			c.Error(err).SetMeta("EditController.Post")
+			return
+		}
+		c.JSON(e.ErrorMessage(e.ErrInternalError))
+		c.Error(err).SetMeta("EditController.Post")
+		return
+	}
+
+	// Delete redis stuff
+	redisErr := redis.NewKey("index").SetKey(fmt.Sprintf("%d", m.Ib), "0").Delete()
+	if redisErr != nil {
+		c.Error(redisErr).SetMeta("EditController.redis.Index.Delete")
+	}
+
+	directoryKey := fmt.Sprintf("%s:%d", "directory", m.Ib)
+	threadKey := fmt.Sprintf("%s:%d:%d", "thread", m.Ib, m.Thread)
+
+	// Continue even if redis fails since the post was already edited
+	redisErr = redis.Cache.Delete(append([]interface{}{directoryKey, threadKey}, quotedKeys(m.Quoted)...)...)
+	if redisErr != nil {
+		c.Error(redisErr).SetMeta("EditController.redis.Cache.Delete")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success_message": auditEditPost})
+
+	audit := audit.Audit{
+		User:   userdata.ID,
+		Ib:     m.Ib,
+		Type:   audit.BoardLog,
+		IP:     m.IP,
+		Action: auditEditPost,
+		Info:   fmt.Sprintf("%d/%d", m.Thread, m.Num),
+	}
+
+	// submit audit
+	err = audit.Submit()
+	if err != nil {
+		c.Error(err).SetMeta("EditController.audit.Submit")
+	}
+
+}
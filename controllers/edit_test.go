@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"database/sql"
+	"testing"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/audit"
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+	"github.com/eirka/eirka-libs/user"
+
+	"github.com/eirka/eirka-post/models"
+)
+
+func editRouter(userdata user.User) *gin.Engine {
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.TrustedPlatform = "X-Real-IP"
+
+	router.Use(func(c *gin.Context) {
+		c.Set("userdata", userdata)
+		c.Next()
+	})
+
+	router.POST("/post/edit", EditController)
+
+	return router
+}
+
+func TestEditController(t *testing.T) {
+
+	var err error
+
+	router := editRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	redis.NewRedisMock()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	statusRows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "thread_closed", "editable"}).AddRow(5, 2, 1, 0, 1)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed`).
+		WithArgs(10, 1, 2).
+		WillReturnRows(statusRows)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO post_revisions`).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE posts SET post_text`).
+		WithArgs("fixed comment", 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT DISTINCT threads.ib_id, threads.thread_id FROM reply_map`).
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"ib_id", "thread_id"}).AddRow(2, 45))
+	mock.ExpectExec(`DELETE FROM reply_map`).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectExec(`INSERT INTO audit`).
+		WithArgs(2, 1, audit.BoardLog, "127.0.0.1", auditEditPost, "1/2").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// the thread that lost the backlink is refreshed
+	redis.Cache.Mock.Command("DEL", "directory:1", "thread:1:1", "thread:2:45")
+
+	params := map[string]string{
+		"thread":  "1",
+		"post":    "2",
+		"comment": "fixed comment",
+	}
+
+	first := performRequestWithFileAndParams(router, "POST", "/post/edit", "file", "", nil, params)
+
+	assert.Equal(t, 200, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, successMessage(auditEditPost), first.Body.String(), "HTTP response should match")
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestEditControllerForbidden(t *testing.T) {
+
+	var err error
+
+	router := editRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	statusRows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "thread_closed", "editable"}).AddRow(5, 3, 1, 0, 1)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed`).
+		WithArgs(10, 1, 2).
+		WillReturnRows(statusRows)
+
+	params := map[string]string{
+		"thread":  "1",
+		"post":    "2",
+		"comment": "not my post",
+	}
+
+	first := performRequestWithFileAndParams(router, "POST", "/post/edit", "file", "", nil, params)
+
+	assert.Equal(t, 403, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(e.ErrForbidden), first.Body.String(), "HTTP response should match")
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestEditControllerStatusErrors(t *testing.T) {
+
+	var err error
+
+	router := editRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed`).
+		WithArgs(10, 1, 2).
+		WillReturnError(sql.ErrNoRows)
+
+	oldRows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "thread_closed", "editable"}).AddRow(5, 2, 1, 0, 0)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed`).
+		WithArgs(10, 1, 2).
+		WillReturnRows(oldRows)
+
+	params := map[string]string{
+		"thread":  "1",
+		"post":    "2",
+		"comment": "fixed comment",
+	}
+
+	first := performRequestWithFileAndParams(router, "POST", "/post/edit", "file", "", nil, params)
+
+	assert.Equal(t, 404, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(e.ErrNotFound), first.Body.String(), "HTTP response should match")
+
+	second := performRequestWithFileAndParams(router, "POST", "/post/edit", "file", "", nil, params)
+
+	assert.Equal(t, 400, second.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(models.ErrEditWindow), second.Body.String(), "HTTP response should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestEditControllerBadInput(t *testing.T) {
+
+	router := editRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	first := performRequestWithFileAndParams(router, "POST", "/post/edit", "file", "", nil, map[string]string{"thread": "1"})
+
+	assert.Equal(t, 400, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(e.ErrInvalidParam), first.Body.String(), "HTTP response should match")
+
+	second := performRequestWithFileAndParams(router, "POST", "/post/edit", "file", "", nil, map[string]string{"thread": "1", "post": "2", "comment": ""})
+
+	assert.Equal(t, 400, second.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(e.ErrNoComment), second.Body.String(), "HTTP response should match")
+
+}
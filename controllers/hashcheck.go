@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	e "github.com/eirka/eirka-libs/errors"
+
+	u "github.com/eirka/eirka-post/utils"
+)
+
+// hashCheckForm is the hash of a file the client wants to upload
+type hashCheckForm struct {
+	Ib     uint   `json:"ib" binding:"required"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
+	SHA    string `json:"sha"`
+}
+
+// HashCheckController tells a client if a file is banned or already posted before it is uploaded
+func HashCheckController(c *gin.Context) {
+	var err error
+	var hf hashCheckForm
+
+	err = c.Bind(&hf)
+	if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInvalidParam))
+		c.Error(err).SetMeta("HashCheckController.Bind")
+		return
+	}
+
+	// limit the checks before anything is looked up
+	err = u.HashCheckCounter(c.ClientIP())
+	if errors.Is(err, u.ErrMaxHashChecks) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error_message": err.Error()})
+		c.Error(err).SetMeta("HashCheckController.HashCheckCounter")
+		return
+	} else if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInternalError))
+		c.Error(err).SetMeta("HashCheckController.HashCheckCounter")
+		return
+	}
+
+	status, err := u.CheckHash(hf.Ib, hf.SHA256, hf.MD5, hf.SHA)
+	if errors.Is(err, e.ErrInvalidParam) || errors.Is(err, e.ErrNoIb) {
+		c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+		c.Error(err).SetMeta("HashCheckController.CheckHash")
+		return
+	} else if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInternalError))
+		c.Error(err).SetMeta("HashCheckController.CheckHash")
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rafaeljusto/redigomock"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+
+	u "github.com/eirka/eirka-post/utils"
+)
+
+func hashCheckRouter() *gin.Engine {
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.TrustedPlatform = "X-Real-IP"
+
+	router.POST("/upload/check", HashCheckController)
+
+	return router
+}
+
+func TestHashCheckController(t *testing.T) {
+
+	router := hashCheckRouter()
+
+	redis.NewRedisMock()
+	redis.Cache.Mock.Command("INCR", "hashcheck:127.0.0.1").Expect([]byte("1"))
+	redis.Cache.Mock.Command("EXPIRE", "hashcheck:127.0.0.1", redigomock.NewAnyData())
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	banned := sqlmock.NewRows([]string{"count"}).AddRow(0)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files`).WillReturnRows(banned)
+
+	match := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(1, 10, 2)
+	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).
+		WithArgs("d41d8cd98f00b204e9800998ecf8427e", 1).
+		WillReturnRows(match)
+
+	first := performJSONRequest(router, "POST", "/upload/check", []byte(`{"ib": 1, "md5": "d41d8cd98f00b204e9800998ecf8427e"}`))
+
+	assert.Equal(t, http.StatusOK, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, `{"banned":false,"duplicate":true,"thread":2,"post":10}`, first.Body.String(), "HTTP response should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestHashCheckControllerLimited(t *testing.T) {
+
+	router := hashCheckRouter()
+
+	redis.NewRedisMock()
+	redis.Cache.Mock.Command("INCR", "hashcheck:127.0.0.1").Expect([]byte("100"))
+	redis.Cache.Mock.Command("EXPIRE", "hashcheck:127.0.0.1", redigomock.NewAnyData())
+
+	first := performJSONRequest(router, "POST", "/upload/check", []byte(`{"ib": 1, "md5": "d41d8cd98f00b204e9800998ecf8427e"}`))
+
+	assert.Equal(t, http.StatusTooManyRequests, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(u.ErrMaxHashChecks), first.Body.String(), "HTTP response should match")
+}
+
+func TestHashCheckControllerInvalid(t *testing.T) {
+
+	router := hashCheckRouter()
+
+	first := performJSONRequest(router, "POST", "/upload/check", []byte(`{"md5": "d41d8cd98f00b204e9800998ecf8427e"}`))
+
+	assert.Equal(t, http.StatusBadRequest, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(e.ErrInvalidParam), first.Body.String(), "HTTP response should match")
+
+	redis.NewRedisMock()
+	redis.Cache.Mock.Command("INCR", "hashcheck:127.0.0.1").Expect([]byte("1"))
+	redis.Cache.Mock.Command("EXPIRE", "hashcheck:127.0.0.1", redigomock.NewAnyData())
+
+	second := performJSONRequest(router, "POST", "/upload/check", []byte(`{"ib": 1, "md5": "test"}`))
+
+	assert.Equal(t, http.StatusBadRequest, second.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(e.ErrInvalidParam), second.Body.String(), "HTTP response should match")
+}
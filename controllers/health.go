@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	u "github.com/eirka/eirka-post/utils"
+)
+
+// checkHealth is a var so tests can replace it
+var checkHealth = u.Health
+
+// HealthController reports if the external media tools were available at the last check
+func HealthController(c *gin.Context) {
+
+	err := checkHealth()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error_message": err.Error()})
+		c.Error(err).SetMeta("HealthController.Health")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+
+}
+
+// MediaStatsController reports the media processing queue depth and timings
+func MediaStatsController(c *gin.Context) {
+
+	c.JSON(http.StatusOK, u.MediaStats())
+
+}
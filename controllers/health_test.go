@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthController(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.GET("/health", HealthController)
+
+	// Store the original check and restore it after test
+	originalCheck := checkHealth
+	defer func() { checkHealth = originalCheck }()
+
+	checkHealth = func() error { return nil }
+
+	req, err := http.NewRequest("GET", "/health", nil)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, "HTTP response code should match")
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String(), "HTTP response should match")
+
+	checkHealth = func() error { return errors.New("ffmpeg not found") }
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "HTTP response code should match")
+	assert.JSONEq(t, `{"status":"unavailable","error_message":"ffmpeg not found"}`, w.Body.String(), "HTTP response should match")
+}
+
+func TestMediaStatsController(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.GET("/health/media", MediaStatsController)
+
+	req, err := http.NewRequest("GET", "/health/media", nil)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, "HTTP response code should match")
+	assert.Contains(t, w.Body.String(), `"queue_size"`, "HTTP response should have the queue size")
+	assert.Contains(t, w.Body.String(), `"avg_run_ms"`, "HTTP response should have the timings")
+}
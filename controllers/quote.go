@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eirka/eirka-post/models"
+)
+
+// quoteError is true when a post failed because of its quotes
+func quoteError(err error) bool {
+	return errors.Is(err, models.ErrInvalidQuote) || errors.Is(err, models.ErrTooManyQuotes)
+}
+
+// quotedKeys are the caches of the quoted threads that show the new backlinks
+func quotedKeys(quoted []models.QuotedThread) (keys []interface{}) {
+	for _, thread := range quoted {
+		keys = append(keys, fmt.Sprintf("%s:%d:%d", "thread", thread.Ib, thread.Thread))
+	}
+
+	return
+}
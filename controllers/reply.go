@@ -19,6 +19,14 @@ import (
 type replyForm struct {
 	Comment string `form:"comment"`
 	Thread  uint   `form:"thread" binding:"required"`
+	// Name is shown on boards with tripcodes, name#password makes one
+	Name    string `form:"name"`
+	Spoiler bool   `form:"spoiler"`
+	// Sage replies do not bump the thread
+	Sage    bool   `form:"sage"`
+	FileURL string `form:"file_url"`
+	// Uploads are finished resumable upload ids
+	Uploads []string `form:"upload_id"`
 }
 
 // ReplyController handles the creation of new threads
@@ -44,13 +52,13 @@ func ReplyController(c *gin.Context) {
 		Comment: rf.Comment,
 		Thread:  rf.Thread,
 		Image:   true,
+		Poster:  models.Poster{Name: rf.Name},
+		Sage:    rf.Sage,
 	}
 
-	image := u.ImageType{}
-
-	// Check if theres a file
-	image.File, image.Header, err = req.FormFile("file")
-	if err == http.ErrMissingFile {
+	// Check if theres a file, a resumable upload, or a url to fetch one from
+	files := formFiles(c)
+	if len(files) == 0 && len(rf.Uploads) == 0 && rf.FileURL == "" {
 		m.Image = false
 	}
 
@@ -85,6 +93,7 @@ func ReplyController(c *gin.Context) {
 		// Check comment in SFS and Akismet
 		akismet := u.Akismet{
 			IP:      m.IP,
+			Name:    m.Poster.Name,
 			Ua:      req.UserAgent(),
 			Referer: req.Referer(),
 			Comment: m.Comment,
@@ -99,38 +108,40 @@ func ReplyController(c *gin.Context) {
 
 	}
 
-	if m.Image {
+	var saved attachments
 
-		// set the ib for duplicate checking
-		image.Ib = m.Ib
+	if m.Image {
 
-		// Save the image to a file
-		err = image.SaveImage()
+		// Save the files, the ib is used for duplicate checking
+		saved, err = saveAttachments(c.Request.Context(), files, rf.Uploads, rf.FileURL, m.Ib, rf.Spoiler)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+			c.JSON(uploadStatus(c, err), gin.H{"error_message": err.Error()})
 			c.Error(err).SetMeta("ReplyController.SaveImage")
 			return
 		}
 
-		m.MD5 = image.MD5
-		m.SHA = image.SHA
-		m.OrigWidth = image.OrigWidth
-		m.OrigHeight = image.OrigHeight
-		m.ThumbWidth = image.ThumbWidth
-		m.ThumbHeight = image.ThumbHeight
-		m.Filename = image.Filename
-		m.Thumbnail = image.Thumbnail
+		m.Images = saved.images()
 
 	}
 
 	// Post data
 	err = m.Post()
 	if err != nil {
+		// the files are useless without the post
+		saved.remove()
+		if quoteError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+			c.Error(err).SetMeta("ReplyController.Post")
+			return
+		}
 		c.JSON(e.ErrorMessage(e.ErrInternalError))
 		c.Error(err).SetMeta("ReplyController.Post")
 		return
 	}
 
+	// the post has the files now
+	saved.removeUploads()
+
 	// needs a fake hash index
 	// Continue even if redis fails since reply was already added successfully
 	redisErr := redis.NewKey("index").SetKey(fmt.Sprintf("%d", m.Ib), "0").Delete()
@@ -143,7 +154,7 @@ func ReplyController(c *gin.Context) {
 	imageKey := fmt.Sprintf("%s:%d", "image", m.Ib)
 
 	// Continue even if redis fails since reply was already added successfully
-	redisErr = redis.Cache.Delete(directoryKey, threadKey, imageKey)
+	redisErr = redis.Cache.Delete(append([]interface{}{directoryKey, threadKey, imageKey}, quotedKeys(m.Quoted)...)...)
 	if redisErr != nil {
 		c.Error(redisErr).SetMeta("ReplyController.redis.Cache.Delete")
 	}
@@ -21,6 +21,9 @@ import (
 	e "github.com/eirka/eirka-libs/errors"
 	"github.com/eirka/eirka-libs/redis"
 	"github.com/eirka/eirka-libs/user"
+
+	local "github.com/eirka/eirka-post/config"
+	"github.com/eirka/eirka-post/models"
 )
 
 // performRequestWithFileAndParams creates a test request with both file and form parameters
@@ -85,13 +88,20 @@ func TestReplyController(t *testing.T) {
 	mock.ExpectBegin()
 	// Expect post number query
 	postRows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 	mock.ExpectQuery(`SELECT COALESCE\(MAX\(post_num\), 0\) \+ 1.*FOR UPDATE`).
 		WithArgs(1).
 		WillReturnRows(postRows)
 	// Now insert with explicit post_num
 	mock.ExpectExec(`INSERT INTO posts`).
-		WithArgs(1, 1, 2, "127.0.0.1", "test comment").
+		WithArgs(1, 1, 2, "127.0.0.1", "test comment", "", "", "").
 		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
 	mock.ExpectCommit()
 
 	// Audit log
@@ -287,13 +297,20 @@ func TestReplyControllerRedisError(t *testing.T) {
 	mock.ExpectBegin()
 	// Expect post number query
 	postRows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 	mock.ExpectQuery(`SELECT COALESCE\(MAX\(post_num\), 0\) \+ 1.*FOR UPDATE`).
 		WithArgs(1).
 		WillReturnRows(postRows)
 	// Now insert with explicit post_num
 	mock.ExpectExec(`INSERT INTO posts`).
-		WithArgs(1, 1, 2, "127.0.0.1", "test comment").
+		WithArgs(1, 1, 2, "127.0.0.1", "test comment", "", "", "").
 		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
 	mock.ExpectCommit()
 
 	// Audit log
@@ -392,3 +409,250 @@ func TestReplyControllerNonExistentThread(t *testing.T) {
 	assert.JSONEq(t, errorMessage(e.ErrNotFound), resp.Body.String(), "Error message should match")
 	assert.NoError(t, mock.ExpectationsWereMet(), "All database expectations should be met")
 }
+
+func TestReplyControllerQuotes(t *testing.T) {
+	var err error
+
+	config.Settings.Session.NewSecret = "secret"
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.TrustedPlatform = "X-Real-IP"
+
+	router.Use(user.Auth(false))
+	router.POST("/reply", ReplyController)
+
+	redis.NewRedisMock()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+	threadRows := sqlmock.NewRows([]string{"ib_id", "thread_closed", "count"}).AddRow(1, 0, 5)
+	mock.ExpectQuery(`SELECT ib_id, thread_closed, count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(threadRows)
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	postRows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(post_num\), 0\) \+ 1.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(postRows)
+	crossRows := sqlmock.NewRows([]string{"post_id", "ib_id"}).AddRow(20, 2)
+	mock.ExpectQuery(`SELECT posts.post_id,threads.ib_id FROM posts`).
+		WithArgs("pics", 45).
+		WillReturnRows(crossRows)
+	mock.ExpectExec(`INSERT INTO posts`).
+		WithArgs(1, 1, 2, "127.0.0.1", "look >>>/pics/45", "", "", "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(`INSERT INTO reply_map`).
+		WithArgs(2, 20).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectCommit()
+
+	mock.ExpectExec(`INSERT INTO audit`).
+		WithArgs(1, 1, audit.BoardLog, "127.0.0.1", audit.AuditReply, "1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// the quoted thread shows the backlink
+	redis.Cache.Mock.Command("DEL", "directory:1", "thread:1:1", "image:1", "thread:2:45")
+
+	params := map[string]string{
+		"thread":  "1",
+		"comment": "look >>>/pics/45",
+	}
+
+	first := performRequestWithFileAndParams(router, "POST", "/reply", "file", "", nil, params)
+
+	assert.Equal(t, 303, first.Code, "HTTP redirect code should match")
+	assert.NoError(t, mock.ExpectationsWereMet(), "All database expectations should be met")
+}
+
+func TestReplyControllerInvalidQuote(t *testing.T) {
+	var err error
+
+	config.Settings.Session.NewSecret = "secret"
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.TrustedPlatform = "X-Real-IP"
+
+	router.Use(user.Auth(false))
+	router.POST("/reply", ReplyController)
+
+	redis.NewRedisMock()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+	threadRows := sqlmock.NewRows([]string{"ib_id", "thread_closed", "count"}).AddRow(1, 0, 5)
+	mock.ExpectQuery(`SELECT ib_id, thread_closed, count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(threadRows)
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	postRows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(post_num\), 0\) \+ 1.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(postRows)
+	mock.ExpectQuery(`SELECT post_id FROM posts`).
+		WithArgs(1, 9).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	params := map[string]string{
+		"thread":  "1",
+		"comment": ">>9 who",
+	}
+
+	first := performRequestWithFileAndParams(router, "POST", "/reply", "file", "", nil, params)
+
+	assert.Equal(t, 400, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, errorMessage(models.ErrInvalidQuote), first.Body.String(), "HTTP response should match")
+	assert.NoError(t, mock.ExpectationsWereMet(), "All database expectations should be met")
+}
+
+func TestReplyControllerName(t *testing.T) {
+	var err error
+
+	config.Settings.Session.NewSecret = "secret"
+
+	original := local.Settings.Posting
+	defer func() {
+		local.Settings.Posting = original
+	}()
+
+	local.Settings.Posting.Secret = "secret"
+	local.Settings.Posting.Tripcodes = []uint{1}
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.TrustedPlatform = "X-Real-IP"
+
+	router.Use(user.Auth(false))
+	router.POST("/reply", ReplyController)
+
+	redis.NewRedisMock()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+	threadRows := sqlmock.NewRows([]string{"ib_id", "thread_closed", "count"}).AddRow(1, 0, 5)
+	mock.ExpectQuery(`SELECT ib_id, thread_closed, count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(threadRows)
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	postRows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(post_num\), 0\) \+ 1.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(postRows)
+	// the board has tripcodes but no poster ids
+	mock.ExpectExec(`INSERT INTO posts`).
+		WithArgs(1, 1, 2, "127.0.0.1", "test comment", "anon", sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectCommit()
+
+	mock.ExpectExec(`INSERT INTO audit`).
+		WithArgs(1, 1, audit.BoardLog, "127.0.0.1", audit.AuditReply, "1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	redis.Cache.Mock.Command("DEL", "directory:1", "thread:1:1", "image:1")
+
+	params := map[string]string{
+		"thread":  "1",
+		"comment": "test comment",
+		"name":    "anon#hunter2",
+	}
+
+	first := performRequestWithFileAndParams(router, "POST", "/reply", "file", "", nil, params)
+
+	assert.Equal(t, 303, first.Code, "HTTP redirect code should match")
+	assert.NoError(t, mock.ExpectationsWereMet(), "All database expectations should be met")
+}
+
+func TestReplyControllerSage(t *testing.T) {
+	var err error
+
+	config.Settings.Session.NewSecret = "secret"
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.TrustedPlatform = "X-Real-IP"
+
+	router.Use(user.Auth(false))
+	router.POST("/reply", ReplyController)
+
+	redis.NewRedisMock()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+	threadRows := sqlmock.NewRows([]string{"ib_id", "thread_closed", "count"}).AddRow(1, 0, 5)
+	mock.ExpectQuery(`SELECT ib_id, thread_closed, count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(threadRows)
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	postRows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(post_num\), 0\) \+ 1.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(postRows)
+	mock.ExpectExec(`INSERT INTO posts`).
+		WithArgs(1, 1, 2, "127.0.0.1", "test comment", "", "", "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	// sage does not bump the thread
+	mock.ExpectCommit()
+
+	mock.ExpectExec(`INSERT INTO audit`).
+		WithArgs(1, 1, audit.BoardLog, "127.0.0.1", audit.AuditReply, "1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	redis.Cache.Mock.Command("DEL", "directory:1", "thread:1:1", "image:1")
+
+	params := map[string]string{
+		"thread":  "1",
+		"comment": "test comment",
+		"sage":    "true",
+	}
+
+	first := performRequestWithFileAndParams(router, "POST", "/reply", "file", "", nil, params)
+
+	assert.Equal(t, 303, first.Code, "HTTP redirect code should match")
+	assert.NoError(t, mock.ExpectationsWereMet(), "All database expectations should be met")
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	e "github.com/eirka/eirka-libs/errors"
+
+	u "github.com/eirka/eirka-post/utils"
+)
+
+// tus content type for upload chunks
+const tusChunkType = "application/offset+octet-stream"
+
+// tusError sends a status with the tus header so clients know the server speaks the protocol
+func tusError(c *gin.Context, status int, err error, meta string) {
+	c.Header("Tus-Resumable", u.TusVersion)
+	c.JSON(status, gin.H{"error_message": err.Error()})
+	c.Error(err).SetMeta(meta)
+}
+
+// checkTusVersion makes sure the client speaks the same protocol version
+func checkTusVersion(c *gin.Context, meta string) bool {
+	if c.GetHeader("Tus-Resumable") != u.TusVersion {
+		c.Header("Tus-Version", u.TusVersion)
+		tusError(c, http.StatusPreconditionFailed, errors.New("unsupported tus version"), meta)
+		return false
+	}
+
+	return true
+}
+
+// parseTusMetadata decodes the comma separated key and base64 value pairs
+func parseTusMetadata(header string) (metadata map[string]string) {
+	metadata = make(map[string]string)
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var value []byte
+		if len(fields) > 1 {
+			value, _ = base64.StdEncoding.DecodeString(fields[1])
+		}
+
+		metadata[fields[0]] = string(value)
+	}
+
+	return
+}
+
+// setUploadHeaders describes the upload state to the client
+func setUploadHeaders(c *gin.Context, upload *u.Upload) {
+	c.Header("Tus-Resumable", u.TusVersion)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.Expires.Format(http.TimeFormat))
+}
+
+// uploadErrorStatus maps the upload errors to their tus status codes
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, u.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, u.ErrUploadOffset):
+		return http.StatusConflict
+	case errors.Is(err, u.ErrUploadLocked):
+		return http.StatusLocked
+	case errors.Is(err, u.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ResumableCreateController starts a resumable upload and returns its location
+// the board is required in the ib metadata, its size limit is used and the upload can only be posted there
+func ResumableCreateController(c *gin.Context) {
+
+	if !checkTusVersion(c, "ResumableCreateController.Version") {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		tusError(c, http.StatusBadRequest, e.ErrInvalidParam, "ResumableCreateController.Length")
+		return
+	}
+
+	metadata := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+
+	// an upload can only be posted to the board it was started for
+	ib, err := strconv.ParseUint(metadata["ib"], 10, 32)
+	if err != nil || ib == 0 {
+		tusError(c, http.StatusBadRequest, e.ErrNoIb, "ResumableCreateController.Ib")
+		return
+	}
+
+	err = u.CheckUploadBoard(uint(ib))
+	if errors.Is(err, u.ErrUploadNoBoard) {
+		tusError(c, http.StatusBadRequest, err, "ResumableCreateController.CheckUploadBoard")
+		return
+	} else if err != nil {
+		tusError(c, http.StatusInternalServerError, err, "ResumableCreateController.CheckUploadBoard")
+		return
+	}
+
+	// limit the uploads before anything is written
+	err = u.ResumableCounter(c.ClientIP(), length)
+	if errors.Is(err, u.ErrMaxUploads) {
+		tusError(c, http.StatusTooManyRequests, err, "ResumableCreateController.ResumableCounter")
+		return
+	} else if err != nil {
+		tusError(c, http.StatusInternalServerError, err, "ResumableCreateController.ResumableCounter")
+		return
+	}
+
+	// the filename is only used for its extension
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+
+	upload, err := u.NewUpload(uint(ib), length, filename)
+	if err != nil {
+		tusError(c, uploadErrorStatus(err), err, "ResumableCreateController.NewUpload")
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+upload.ID)
+	c.Status(http.StatusCreated)
+
+}
+
+// ResumableHeadController reports how much of an upload has been received
+func ResumableHeadController(c *gin.Context) {
+
+	if !checkTusVersion(c, "ResumableHeadController.Version") {
+		return
+	}
+
+	upload, err := u.GetUpload(c.Param("id"))
+	if err != nil {
+		tusError(c, uploadErrorStatus(err), err, "ResumableHeadController.GetUpload")
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+}
+
+// ResumablePatchController appends a chunk to an upload
+func ResumablePatchController(c *gin.Context) {
+
+	if !checkTusVersion(c, "ResumablePatchController.Version") {
+		return
+	}
+
+	if c.ContentType() != tusChunkType {
+		tusError(c, http.StatusUnsupportedMediaType, e.ErrInvalidParam, "ResumablePatchController.ContentType")
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		tusError(c, http.StatusBadRequest, e.ErrInvalidParam, "ResumablePatchController.Offset")
+		return
+	}
+
+	upload, err := u.GetUpload(c.Param("id"))
+	if err != nil {
+		tusError(c, uploadErrorStatus(err), err, "ResumablePatchController.GetUpload")
+		return
+	}
+
+	err = upload.Append(offset, c.Request.Body)
+	if err != nil {
+		tusError(c, uploadErrorStatus(err), err, "ResumablePatchController.Append")
+		return
+	}
+
+	setUploadHeaders(c, upload)
+	c.Status(http.StatusNoContent)
+
+}
+
+// ResumableDeleteController lets the client cancel an upload
+func ResumableDeleteController(c *gin.Context) {
+
+	if !checkTusVersion(c, "ResumableDeleteController.Version") {
+		return
+	}
+
+	upload, err := u.GetUpload(c.Param("id"))
+	if err != nil {
+		tusError(c, uploadErrorStatus(err), err, "ResumableDeleteController.GetUpload")
+		return
+	}
+
+	err = upload.Remove()
+	if err != nil {
+		tusError(c, http.StatusInternalServerError, err, "ResumableDeleteController.Remove")
+		return
+	}
+
+	c.Header("Tus-Resumable", u.TusVersion)
+	c.Status(http.StatusNoContent)
+
+}
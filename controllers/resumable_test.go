@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rafaeljusto/redigomock"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/config"
+	"github.com/eirka/eirka-libs/db"
+	"github.com/eirka/eirka-libs/redis"
+
+	local "github.com/eirka/eirka-post/config"
+	u "github.com/eirka/eirka-post/utils"
+)
+
+func resumableRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	originalDir := local.Settings.Directories.UploadDir
+	originalSize := config.Settings.Limits.ImageMaxSize
+
+	local.Settings.Directories.UploadDir = t.TempDir()
+	config.Settings.Limits.ImageMaxSize = 1024
+
+	t.Cleanup(func() {
+		local.Settings.Directories.UploadDir = originalDir
+		config.Settings.Limits.ImageMaxSize = originalSize
+	})
+
+	// every upload is under the ip limits
+	redis.NewRedisMock()
+	redis.Cache.Mock.Command("INCR", redigomock.NewAnyData()).Expect([]byte("1"))
+	redis.Cache.Mock.Command("INCRBY", redigomock.NewAnyData(), redigomock.NewAnyData()).Expect(int64(1))
+	redis.Cache.Mock.Command("EXPIRE", redigomock.NewAnyData(), redigomock.NewAnyData())
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.TrustedPlatform = "X-Real-IP"
+
+	router.POST("/upload", ResumableCreateController)
+	router.HEAD("/upload/:id", ResumableHeadController)
+	router.PATCH("/upload/:id", ResumablePatchController)
+	router.DELETE("/upload/:id", ResumableDeleteController)
+
+	return router
+}
+
+// expectUploadBoard mocks the board check and the policy lookup for a new upload
+func expectUploadBoard(mock sqlmock.Sqlmock, ib uint) {
+	board := sqlmock.NewRows([]string{"count"}).AddRow(1)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM imageboards`).WithArgs(ib).WillReturnRows(board)
+
+	nopolicy := sqlmock.NewRows([]string{"formats", "size", "width", "height", "length", "audio", "files"})
+	mock.ExpectQuery(`SELECT policy_formats`).WithArgs(ib).WillReturnRows(nopolicy)
+}
+
+// tusBoard is the ib metadata for board 1
+var tusBoard = "ib " + base64.StdEncoding.EncodeToString([]byte("1"))
+
+func performTusRequest(r http.Handler, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", u.TusVersion)
+	req.Header.Set("X-Real-IP", "127.0.0.1")
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestResumableUploadFlow(t *testing.T) {
+	router := resumableRouter(t)
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	expectUploadBoard(mock, 1)
+
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("test.webm")) + "," + tusBoard
+
+	create := performTusRequest(router, "POST", "/upload", "", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": metadata,
+	})
+
+	assert.Equal(t, http.StatusCreated, create.Code, "HTTP request code should match")
+	assert.Equal(t, u.TusVersion, create.Header().Get("Tus-Resumable"), "Tus header should be set")
+	assert.NotEmpty(t, create.Header().Get("Upload-Expires"), "Expiry should be set")
+
+	location := create.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(location, "/upload/"), "Location should point at the upload")
+
+	first := performTusRequest(router, "PATCH", location, "01234", map[string]string{
+		"Content-Type":  tusChunkType,
+		"Upload-Offset": "0",
+	})
+
+	assert.Equal(t, http.StatusNoContent, first.Code, "HTTP request code should match")
+	assert.Equal(t, "5", first.Header().Get("Upload-Offset"), "Offset should match")
+
+	head := performTusRequest(router, "HEAD", location, "", nil)
+
+	assert.Equal(t, http.StatusOK, head.Code, "HTTP request code should match")
+	assert.Equal(t, "5", head.Header().Get("Upload-Offset"), "Offset should match")
+	assert.Equal(t, "10", head.Header().Get("Upload-Length"), "Length should match")
+	assert.Equal(t, "no-store", head.Header().Get("Cache-Control"), "Cache header should match")
+
+	conflict := performTusRequest(router, "PATCH", location, "56789", map[string]string{
+		"Content-Type":  tusChunkType,
+		"Upload-Offset": "0",
+	})
+
+	assert.Equal(t, http.StatusConflict, conflict.Code, "HTTP request code should match")
+
+	second := performTusRequest(router, "PATCH", location, "56789", map[string]string{
+		"Content-Type":  tusChunkType,
+		"Upload-Offset": "5",
+	})
+
+	assert.Equal(t, http.StatusNoContent, second.Code, "HTTP request code should match")
+	assert.Equal(t, "10", second.Header().Get("Upload-Offset"), "Offset should match")
+
+	upload, err := u.GetUpload(strings.TrimPrefix(location, "/upload/"))
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.True(t, upload.Complete(), "Upload should be complete")
+		assert.Equal(t, "test.webm", upload.Filename, "Filename should match")
+		assert.Equal(t, uint(1), upload.Ib, "Ib should match")
+	}
+
+	remove := performTusRequest(router, "DELETE", location, "", nil)
+
+	assert.Equal(t, http.StatusNoContent, remove.Code, "HTTP request code should match")
+
+	gone := performTusRequest(router, "HEAD", location, "", nil)
+
+	assert.Equal(t, http.StatusNotFound, gone.Code, "HTTP request code should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestResumableCreateInvalid(t *testing.T) {
+	router := resumableRouter(t)
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	noVersion := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/upload", nil)
+	req.Header.Set("Upload-Length", "10")
+	router.ServeHTTP(noVersion, req)
+
+	assert.Equal(t, http.StatusPreconditionFailed, noVersion.Code, "HTTP request code should match")
+	assert.Equal(t, u.TusVersion, noVersion.Header().Get("Tus-Version"), "Supported version should be sent")
+
+	noLength := performTusRequest(router, "POST", "/upload", "", nil)
+
+	assert.Equal(t, http.StatusBadRequest, noLength.Code, "HTTP request code should match")
+
+	expectUploadBoard(mock, 1)
+
+	tooLarge := performTusRequest(router, "POST", "/upload", "", map[string]string{
+		"Upload-Length":   "4096",
+		"Upload-Metadata": tusBoard,
+	})
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, tooLarge.Code, "HTTP request code should match")
+
+	badIb := performTusRequest(router, "POST", "/upload", "", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "ib " + base64.StdEncoding.EncodeToString([]byte("x")),
+	})
+
+	assert.Equal(t, http.StatusBadRequest, badIb.Code, "HTTP request code should match")
+
+	noIb := performTusRequest(router, "POST", "/upload", "", map[string]string{
+		"Upload-Length": "10",
+	})
+
+	assert.Equal(t, http.StatusBadRequest, noIb.Code, "HTTP request code should match")
+
+	zeroIb := performTusRequest(router, "POST", "/upload", "", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "ib " + base64.StdEncoding.EncodeToString([]byte("0")),
+	})
+
+	assert.Equal(t, http.StatusBadRequest, zeroIb.Code, "HTTP request code should match")
+
+	noboard := sqlmock.NewRows([]string{"count"}).AddRow(0)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM imageboards`).WithArgs(2).WillReturnRows(noboard)
+
+	missingBoard := performTusRequest(router, "POST", "/upload", "", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "ib " + base64.StdEncoding.EncodeToString([]byte("2")),
+	})
+
+	assert.Equal(t, http.StatusBadRequest, missingBoard.Code, "HTTP request code should match")
+	assert.JSONEq(t, `{"error_message":"upload board does not exist"}`, missingBoard.Body.String(), "HTTP response should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestResumableCreateLimit(t *testing.T) {
+	router := resumableRouter(t)
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	board := sqlmock.NewRows([]string{"count"}).AddRow(1)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM imageboards`).WithArgs(1).WillReturnRows(board)
+
+	redis.Cache.Mock.Command("INCR", redigomock.NewAnyData()).Expect([]byte("21"))
+
+	limited := performTusRequest(router, "POST", "/upload", "", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tusBoard,
+	})
+
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code, "HTTP request code should match")
+	assert.JSONEq(t, `{"error_message":"upload limit exceeded"}`, limited.Body.String(), "HTTP response should match")
+}
+
+func TestResumablePatchInvalid(t *testing.T) {
+	router := resumableRouter(t)
+
+	upload, err := u.NewUpload(0, 10, "test.jpg")
+	assert.NoError(t, err, "An error was not expected")
+
+	wrongType := performTusRequest(router, "PATCH", "/upload/"+upload.ID, "01234", map[string]string{
+		"Content-Type":  "application/octet-stream",
+		"Upload-Offset": "0",
+	})
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, wrongType.Code, "HTTP request code should match")
+
+	missing := performTusRequest(router, "PATCH", "/upload/0123456789abcdef0123456789abcdef", "01234", map[string]string{
+		"Content-Type":  tusChunkType,
+		"Upload-Offset": "0",
+	})
+
+	assert.Equal(t, http.StatusNotFound, missing.Code, "HTTP request code should match")
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	metadata := parseTusMetadata("filename dGVzdC5qcGc=, ib MQ==,is_confidential")
+
+	assert.Equal(t, "test.jpg", metadata["filename"], "Filename should match")
+	assert.Equal(t, "1", metadata["ib"], "Ib should match")
+	assert.Contains(t, metadata, "is_confidential", "Keys without values should be kept")
+}
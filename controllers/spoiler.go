@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/eirka/eirka-libs/audit"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+	"github.com/eirka/eirka-libs/user"
+
+	"github.com/eirka/eirka-post/models"
+)
+
+// audit actions for the spoiler toggle
+const (
+	auditSpoilerImage   = "Image Spoilered"
+	auditUnspoilerImage = "Image Unspoilered"
+)
+
+// spoilerForm contains the moderator input for the spoiler toggle
+type spoilerForm struct {
+	Ib      uint `json:"ib" binding:"required"`
+	Image   uint `json:"image" binding:"required"`
+	Spoiler bool `json:"spoiler"`
+}
+
+// SpoilerController lets moderators set or clear the spoiler flag on an image
+// images spoilered after posting have no blurred thumbnail so frontends use a placeholder
+func SpoilerController(c *gin.Context) {
+	var err error
+	var sf spoilerForm
+
+	// get userdata from session middleware
+	userdata := c.MustGet("userdata").(user.User)
+
+	err = c.Bind(&sf)
+	if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInvalidParam))
+		c.Error(err).SetMeta("SpoilerController.Bind")
+		return
+	}
+
+	// only moderators and admins of the board
+	if !userdata.IsAuthorized(sf.Ib) {
+		c.JSON(e.ErrorMessage(e.ErrForbidden))
+		c.Error(e.ErrForbidden).SetMeta("SpoilerController.IsAuthorized")
+		return
+	}
+
+	// Set parameters to SpoilerModel
+	m := models.SpoilerModel{
+		Ib:      sf.Ib,
+		Image:   sf.Image,
+		Spoiler: sf.Spoiler,
+	}
+
+	// Validate input parameters
+	err = m.ValidateInput()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+		c.Error(err).SetMeta("SpoilerController.ValidateInput")
+		return
+	}
+
+	// Check image for correct ib
+	err = m.Status()
+	if err == e.ErrNotFound {
+		c.JSON(e.ErrorMessage(e.ErrNotFound))
+		c.Error(err).SetMeta("SpoilerController.Status")
+		return
+	} else if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInternalError))
+		c.Error(err).SetMeta("SpoilerController.Status")
+		return
+	}
+
+	// Post data
+	err = m.Post()
+	if err != nil {
+		c.JSON(e.ErrorMessage(e.ErrInternalError))
+		c.Error(err).SetMeta("SpoilerController.Post")
+		return
+	}
+
+	// Delete redis stuff
+	redisErr := redis.NewKey("index").SetKey(fmt.Sprintf("%d", m.Ib), "0").Delete()
+	if redisErr != nil {
+		c.Error(redisErr).SetMeta("SpoilerController.redis.Index.Delete")
+	}
+
+	directoryKey := fmt.Sprintf("%s:%d", "directory", m.Ib)
+	threadKey := fmt.Sprintf("%s:%d:%d", "thread", m.Ib, m.Thread)
+	imageKey := fmt.Sprintf("%s:%d", "image", m.Ib)
+
+	// Continue even if redis fails since the flag was already changed
+	redisErr = redis.Cache.Delete(directoryKey, threadKey, imageKey)
+	if redisErr != nil {
+		c.Error(redisErr).SetMeta("SpoilerController.redis.Cache.Delete")
+	}
+
+	action := auditSpoilerImage
+	if !m.Spoiler {
+		action = auditUnspoilerImage
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success_message": action})
+
+	audit := audit.Audit{
+		User:   userdata.ID,
+		Ib:     m.Ib,
+		Type:   audit.ModLog,
+		IP:     c.ClientIP(),
+		Action: action,
+		Info:   fmt.Sprintf("%d", m.Image),
+	}
+
+	// submit audit
+	err = audit.Submit()
+	if err != nil {
+		c.Error(err).SetMeta("SpoilerController.audit.Submit")
+	}
+
+}
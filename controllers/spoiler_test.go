@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"database/sql"
+	"testing"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/audit"
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+	"github.com/eirka/eirka-libs/user"
+)
+
+func spoilerRouter(userdata user.User) *gin.Engine {
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.TrustedPlatform = "X-Real-IP"
+
+	router.Use(func(c *gin.Context) {
+		c.Set("userdata", userdata)
+		c.Next()
+	})
+
+	router.POST("/mod/spoiler", SpoilerController)
+
+	return router
+}
+
+func TestSpoilerController(t *testing.T) {
+
+	var err error
+
+	router := spoilerRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	// Set up fake Redis connection
+	redis.NewRedisMock()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	rolerows := sqlmock.NewRows([]string{"role"}).AddRow(3)
+	mock.ExpectQuery(`SELECT COALESCE`).WillReturnRows(rolerows)
+
+	threadrows := sqlmock.NewRows([]string{"thread_id"}).AddRow(5)
+	mock.ExpectQuery(`SELECT threads.thread_id FROM images`).
+		WithArgs(3, 1).
+		WillReturnRows(threadrows)
+
+	mock.ExpectExec("UPDATE images SET image_spoiler").
+		WithArgs(true, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectExec(`INSERT INTO audit \(user_id,ib_id,audit_type,audit_ip,audit_time,audit_action,audit_info\)`).
+		WithArgs(2, 1, audit.ModLog, "127.0.0.1", auditSpoilerImage, "3").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	redis.Cache.Mock.Command("DEL", "directory:1", "thread:1:5", "image:1")
+
+	first := performJSONRequest(router, "POST", "/mod/spoiler", []byte(`{"ib": 1, "image": 3, "spoiler": true}`))
+
+	assert.Equal(t, 200, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, first.Body.String(), successMessage(auditSpoilerImage), "HTTP response should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestSpoilerControllerForbidden(t *testing.T) {
+
+	var err error
+
+	router := spoilerRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	// a regular user
+	rolerows := sqlmock.NewRows([]string{"role"}).AddRow(1)
+	mock.ExpectQuery(`SELECT COALESCE`).WillReturnRows(rolerows)
+
+	first := performJSONRequest(router, "POST", "/mod/spoiler", []byte(`{"ib": 1, "image": 3, "spoiler": true}`))
+
+	assert.Equal(t, 403, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, first.Body.String(), errorMessage(e.ErrForbidden), "HTTP response should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+	// anonymous users never get to the database
+	anon := spoilerRouter(user.DefaultUser())
+
+	second := performJSONRequest(anon, "POST", "/mod/spoiler", []byte(`{"ib": 1, "image": 3}`))
+
+	assert.Equal(t, 403, second.Code, "HTTP request code should match")
+
+}
+
+func TestSpoilerControllerNotFound(t *testing.T) {
+
+	var err error
+
+	router := spoilerRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	rolerows := sqlmock.NewRows([]string{"role"}).AddRow(4)
+	mock.ExpectQuery(`SELECT COALESCE`).WillReturnRows(rolerows)
+
+	mock.ExpectQuery(`SELECT threads.thread_id FROM images`).
+		WithArgs(3, 1).
+		WillReturnError(sql.ErrNoRows)
+
+	first := performJSONRequest(router, "POST", "/mod/spoiler", []byte(`{"ib": 1, "image": 3, "spoiler": false}`))
+
+	assert.Equal(t, 404, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, first.Body.String(), errorMessage(e.ErrNotFound), "HTTP response should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestSpoilerControllerBadInput(t *testing.T) {
+
+	router := spoilerRouter(user.User{ID: 2, IsAuthenticated: true})
+
+	first := performJSONRequest(router, "POST", "/mod/spoiler", []byte(`{"ib": 1}`))
+
+	assert.Equal(t, 400, first.Code, "HTTP request code should match")
+	assert.JSONEq(t, first.Body.String(), errorMessage(e.ErrInvalidParam), "HTTP response should match")
+
+}
@@ -20,6 +20,12 @@ type threadForm struct {
 	Title   string `form:"title" binding:"required"`
 	Comment string `form:"comment" binding:"required"`
 	Ib      uint   `form:"ib" binding:"required"`
+	// Name is shown on boards with tripcodes, name#password makes one
+	Name    string `form:"name"`
+	Spoiler bool   `form:"spoiler"`
+	FileURL string `form:"file_url"`
+	// Uploads are finished resumable upload ids
+	Uploads []string `form:"upload_id"`
 }
 
 // ThreadController handles the creation of new threads
@@ -45,13 +51,12 @@ func ThreadController(c *gin.Context) {
 		Title:   tf.Title,
 		Comment: tf.Comment,
 		Ib:      tf.Ib,
+		Poster:  models.Poster{Name: tf.Name},
 	}
 
-	image := u.ImageType{}
-
-	// Check if theres a file
-	image.File, image.Header, err = req.FormFile("file")
-	if err == http.ErrMissingFile {
+	// Check if theres a file, a resumable upload, or a url to fetch one from
+	files := formFiles(c)
+	if len(files) == 0 && len(tf.Uploads) == 0 && tf.FileURL == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error_message": e.ErrNoImage.Error()})
 		c.Error(e.ErrNoImage).SetMeta("ThreadController.FormFile")
 		return
@@ -68,6 +73,7 @@ func ThreadController(c *gin.Context) {
 	// Check comment in SFS and Akismet
 	akismet := u.Akismet{
 		IP:      m.IP,
+		Name:    m.Poster.Name,
 		Ua:      req.UserAgent(),
 		Referer: req.Referer(),
 		Comment: m.Comment,
@@ -80,34 +86,34 @@ func ThreadController(c *gin.Context) {
 		return
 	}
 
-	// set the ib for duplicate checking
-	image.Ib = m.Ib
-
-	// Save the image to a file
-	err = image.SaveImage()
+	// Save the files, the ib is used for duplicate checking
+	saved, err := saveAttachments(c.Request.Context(), files, tf.Uploads, tf.FileURL, m.Ib, tf.Spoiler)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+		c.JSON(uploadStatus(c, err), gin.H{"error_message": err.Error()})
 		c.Error(err).SetMeta("ThreadController.SaveImage")
 		return
 	}
 
-	m.MD5 = image.MD5
-	m.SHA = image.SHA
-	m.OrigWidth = image.OrigWidth
-	m.OrigHeight = image.OrigHeight
-	m.ThumbWidth = image.ThumbWidth
-	m.ThumbHeight = image.ThumbHeight
-	m.Filename = image.Filename
-	m.Thumbnail = image.Thumbnail
+	m.Images = saved.images()
 
 	// Post data
 	err = m.Post()
 	if err != nil {
+		// the files are useless without the post
+		saved.remove()
+		if quoteError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error_message": err.Error()})
+			c.Error(err).SetMeta("ThreadController.Post")
+			return
+		}
 		c.JSON(e.ErrorMessage(e.ErrInternalError))
 		c.Error(err).SetMeta("ThreadController.Post")
 		return
 	}
 
+	// the post has the files now
+	saved.removeUploads()
+
 	// needs a fake hash index
 	// Continue even if redis fails since thread was already added successfully
 	redisErr := redis.NewKey("index").SetKey(fmt.Sprintf("%d", m.Ib), "0").Delete()
@@ -118,7 +124,7 @@ func ThreadController(c *gin.Context) {
 	directoryKey := fmt.Sprintf("%s:%d", "directory", m.Ib)
 
 	// Continue even if redis fails since thread was already added successfully
-	redisErr = redis.Cache.Delete(directoryKey)
+	redisErr = redis.Cache.Delete(append([]interface{}{directoryKey}, quotedKeys(m.Quoted)...)...)
 	if redisErr != nil {
 		c.Error(redisErr).SetMeta("ThreadController.redis.Cache.Delete")
 	}
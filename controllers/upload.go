@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	e "github.com/eirka/eirka-libs/errors"
+
+	"github.com/eirka/eirka-post/models"
+	u "github.com/eirka/eirka-post/utils"
+)
+
+// uploadStatus picks the status for a failed upload
+// a full media queue is temporary so the client is told when to retry
+func uploadStatus(c *gin.Context, err error) int {
+	if errors.Is(err, u.ErrQueueFull) {
+		c.Header("Retry-After", strconv.Itoa(u.MediaRetryAfter))
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusBadRequest
+}
+
+// formFiles gets every file sent in the file field
+func formFiles(c *gin.Context) (headers []*multipart.FileHeader) {
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return
+	}
+
+	return form.File["file"]
+}
+
+// attachments are the saved files of a post
+type attachments []*u.ImageType
+
+// saveAttachments runs every file through SaveImage
+// resumable uploads and a file url count as files and are opened by SaveImage
+// the files already saved are removed if one of them fails
+func saveAttachments(ctx context.Context, headers []*multipart.FileHeader, uploadIDs []string, fileURL string, ib uint, spoiler bool) (saved attachments, err error) {
+
+	limit, err := u.GetMaxFiles(ib)
+	if err != nil {
+		return nil, errors.New("problem loading upload policy")
+	}
+
+	var uploads []*u.ImageType
+
+	for _, header := range headers {
+		uploads = append(uploads, &u.ImageType{
+			Header:  header,
+			Ib:      ib,
+			Spoiler: spoiler,
+		})
+	}
+
+	for _, id := range uploadIDs {
+		uploads = append(uploads, &u.ImageType{
+			UploadID: id,
+			Ib:       ib,
+			Spoiler:  spoiler,
+		})
+	}
+
+	if fileURL != "" {
+		uploads = append(uploads, &u.ImageType{
+			URL:     fileURL,
+			Context: ctx,
+			Ib:      ib,
+			Spoiler: spoiler,
+		})
+	}
+
+	if len(uploads) > limit {
+		return nil, fmt.Errorf("too many files. Max: %d", limit)
+	}
+
+	for _, image := range uploads {
+		if image.Header != nil {
+			image.File, err = image.Header.Open()
+			if err != nil {
+				saved.remove()
+				return nil, errors.New("problem opening file")
+			}
+		}
+
+		// Save the image to a file
+		err = image.SaveImage()
+		if err != nil {
+			saved.remove()
+			return nil, err
+		}
+
+		// the duplicate check only sees files that were already posted
+		for _, other := range saved {
+			if other.SHA256 == image.SHA256 {
+				image.DeleteFiles()
+				saved.remove()
+				return nil, e.ErrDuplicateImage
+			}
+		}
+
+		saved = append(saved, image)
+	}
+
+	return
+}
+
+// remove deletes the saved files when the post fails
+func (a attachments) remove() {
+	for _, image := range a {
+		image.DeleteFiles()
+	}
+}
+
+// removeUploads deletes the resumable uploads once the post is made
+func (a attachments) removeUploads() {
+	for _, image := range a {
+		image.RemoveUpload()
+	}
+}
+
+// images converts the saved files for the post models
+func (a attachments) images() (images []models.PostImage) {
+	for _, image := range a {
+		post := models.PostImage{
+			Filename:     image.Filename,
+			Thumbnail:    image.Thumbnail,
+			MD5:          image.MD5,
+			SHA:          image.SHA,
+			SHA256:       image.SHA256,
+			SourceSHA256: image.SourceSHA256,
+			PHash:        image.PHash,
+			OrigWidth:    image.OrigWidth,
+			OrigHeight:   image.OrigHeight,
+			ThumbWidth:   image.ThumbWidth,
+			ThumbHeight:  image.ThumbHeight,
+			Spoiler:      image.Spoiler,
+		}
+
+		for _, thumb := range image.Thumbnails {
+			post.Thumbnails = append(post.Thumbnails, models.ImageThumbnail{
+				Profile:  thumb.Profile,
+				Filename: thumb.Filename,
+				Width:    thumb.Width,
+				Height:   thumb.Height,
+			})
+		}
+
+		images = append(images, post)
+	}
+
+	return
+}
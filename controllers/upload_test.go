@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/db"
+
+	local "github.com/eirka/eirka-post/config"
+	"github.com/eirka/eirka-post/models"
+	u "github.com/eirka/eirka-post/utils"
+)
+
+func TestUploadStatus(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	assert.Equal(t, http.StatusBadRequest, uploadStatus(c, errors.New("bad image")), "Status should match")
+	assert.Empty(t, w.Header().Get("Retry-After"), "Retry-After should not be set")
+
+	err := fmt.Errorf("thumbnail: %w", u.ErrQueueFull)
+
+	assert.Equal(t, http.StatusServiceUnavailable, uploadStatus(c, err), "Status should match")
+	assert.Equal(t, fmt.Sprint(u.MediaRetryAfter), w.Header().Get("Retry-After"), "Retry-After should match")
+}
+
+// multipartFiles builds a form with several files in the file field
+func multipartFiles(t *testing.T, names ...string) []*multipart.FileHeader {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for _, name := range names {
+		part, err := writer.CreateFormFile("file", name)
+		assert.NoError(t, err, "An error was not expected")
+		part.Write([]byte{0xff, 0xd8, 0xff, 0xe0})
+	}
+
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	assert.NoError(t, req.ParseMultipartForm(1<<20), "An error was not expected")
+
+	return req.MultipartForm.File["file"]
+}
+
+func TestSaveAttachmentsTooMany(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	rows := sqlmock.NewRows([]string{"formats", "size", "width", "height", "length", "audio", "files"}).
+		AddRow("", 0, 0, 0, 0, true, 2)
+
+	mock.ExpectQuery(`SELECT policy_formats`).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	saved, err := saveAttachments(context.Background(), multipartFiles(t, "one.jpg", "two.jpg", "three.jpg"), nil, "", 1, false)
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "too many files. Max: 2", err.Error(), "Error should match")
+	}
+	assert.Empty(t, saved, "Nothing should be saved")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestSaveAttachmentsURLCounted(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	rows := sqlmock.NewRows([]string{"formats", "size", "width", "height", "length", "audio", "files"}).
+		AddRow("", 0, 0, 0, 0, true, 2)
+
+	mock.ExpectQuery(`SELECT policy_formats`).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	saved, err := saveAttachments(context.Background(), multipartFiles(t, "one.jpg", "two.jpg"), nil, "https://example.com/three.jpg", 1, false)
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "too many files. Max: 2", err.Error(), "Error should match")
+	}
+	assert.Empty(t, saved, "Nothing should be saved")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestSaveAttachmentsPolicyError(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectQuery(`SELECT policy_formats`).
+		WithArgs(1).
+		WillReturnError(errors.New("SQL error"))
+
+	_, err = saveAttachments(context.Background(), multipartFiles(t, "one.jpg"), nil, "", 1, false)
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "problem loading upload policy", err.Error(), "Error should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestAttachmentsRemove(t *testing.T) {
+	imageDir := local.Settings.Directories.ImageDir
+	thumbDir := local.Settings.Directories.ThumbnailDir
+
+	assert.NoError(t, os.MkdirAll(imageDir, 0755), "Failed to ensure image directory exists")
+	assert.NoError(t, os.MkdirAll(thumbDir, 0755), "Failed to ensure thumbnail directory exists")
+
+	var saved attachments
+
+	for _, name := range []string{"attachone", "attachtwo"} {
+		image := &u.ImageType{
+			Filename:  name + ".jpg",
+			Filepath:  filepath.Join(imageDir, name+".jpg"),
+			Thumbnail: name + "s.jpg",
+			Thumbpath: filepath.Join(thumbDir, name+"s.jpg"),
+			Thumbnails: []u.ThumbnailFile{
+				{Profile: "catalog", Filename: name + "-catalog.jpg", Filepath: filepath.Join(thumbDir, name+"-catalog.jpg"), Width: 50, Height: 40},
+			},
+		}
+
+		for _, path := range []string{image.Filepath, image.Thumbpath, image.Thumbnails[0].Filepath} {
+			assert.NoError(t, os.WriteFile(path, []byte("test"), 0644), "An error was not expected")
+		}
+
+		saved = append(saved, image)
+	}
+
+	images := saved.images()
+	if assert.Len(t, images, 2, "Every file should be converted") {
+		assert.Equal(t, "attachtwo.jpg", images[1].Filename, "Order should be kept")
+		assert.Equal(t, []models.ImageThumbnail{{Profile: "catalog", Filename: "attachtwo-catalog.jpg", Width: 50, Height: 40}}, images[1].Thumbnails, "Thumbnails should match")
+	}
+
+	saved.remove()
+
+	for _, image := range saved {
+		for _, path := range []string{image.Filepath, image.Thumbpath, image.Thumbnails[0].Filepath} {
+			_, err := os.Stat(path)
+			assert.True(t, os.IsNotExist(err), "File should be removed")
+		}
+	}
+}
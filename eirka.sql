@@ -94,8 +94,21 @@ CREATE TABLE `banned_files` (
   `user_id` int unsigned NOT NULL,
   `ib_id` tinyint unsigned NOT NULL,
   `ban_hash` varchar(32) COLLATE utf8mb3_unicode_ci NOT NULL,
+  `ban_sha256` char(64) COLLATE utf8mb3_unicode_ci DEFAULT NULL,
+  `ban_phash` bigint DEFAULT NULL,
+  `ban_phash_band0` smallint unsigned GENERATED ALWAYS AS ((`ban_phash` >> 51) & 8191) STORED,
+  `ban_phash_band1` smallint unsigned GENERATED ALWAYS AS ((`ban_phash` >> 38) & 8191) STORED,
+  `ban_phash_band2` smallint unsigned GENERATED ALWAYS AS ((`ban_phash` >> 25) & 8191) STORED,
+  `ban_phash_band3` smallint unsigned GENERATED ALWAYS AS ((`ban_phash` >> 12) & 8191) STORED,
+  `ban_phash_band4` smallint unsigned GENERATED ALWAYS AS (`ban_phash` & 4095) STORED,
   `ban_reason` varchar(255) COLLATE utf8mb3_unicode_ci NOT NULL,
   UNIQUE KEY `bf_ban_hash` (`ban_hash`),
+  KEY `bf_ban_sha256` (`ban_sha256`),
+  KEY `bf_ban_phash_band0` (`ban_phash_band0`),
+  KEY `bf_ban_phash_band1` (`ban_phash_band1`),
+  KEY `bf_ban_phash_band2` (`ban_phash_band2`),
+  KEY `bf_ban_phash_band3` (`ban_phash_band3`),
+  KEY `bf_ban_phash_band4` (`ban_phash_band4`),
   KEY `bf_user_id` (`user_id`),
   KEY `bf_ib_id` (`ib_id`),
   CONSTRAINT `bf_ib_id` FOREIGN KEY (`ib_id`) REFERENCES `imageboards` (`ib_id`) ON DELETE CASCADE ON UPDATE CASCADE,
@@ -183,20 +196,73 @@ CREATE TABLE `images` (
   `image_thumbnail` varchar(20) COLLATE utf8mb3_unicode_ci NOT NULL,
   `image_hash` varchar(32) COLLATE utf8mb3_unicode_ci NOT NULL,
   `image_sha` char(40) COLLATE utf8mb3_unicode_ci NOT NULL,
+  `image_sha256` char(64) COLLATE utf8mb3_unicode_ci DEFAULT NULL,
+  `image_source_sha256` char(64) COLLATE utf8mb3_unicode_ci DEFAULT NULL,
+  `image_phash` bigint DEFAULT NULL,
+  `image_phash_band0` smallint unsigned GENERATED ALWAYS AS ((`image_phash` >> 51) & 8191) STORED,
+  `image_phash_band1` smallint unsigned GENERATED ALWAYS AS ((`image_phash` >> 38) & 8191) STORED,
+  `image_phash_band2` smallint unsigned GENERATED ALWAYS AS ((`image_phash` >> 25) & 8191) STORED,
+  `image_phash_band3` smallint unsigned GENERATED ALWAYS AS ((`image_phash` >> 12) & 8191) STORED,
+  `image_phash_band4` smallint unsigned GENERATED ALWAYS AS (`image_phash` & 4095) STORED,
   `image_orig_height` smallint unsigned NOT NULL DEFAULT '0',
   `image_orig_width` smallint unsigned NOT NULL DEFAULT '0',
   `image_tn_height` smallint unsigned NOT NULL DEFAULT '0',
   `image_tn_width` smallint unsigned NOT NULL DEFAULT '0',
+  `image_spoiler` tinyint(1) NOT NULL DEFAULT '0',
+  `image_deleted` tinyint(1) NOT NULL DEFAULT '0',
   PRIMARY KEY (`image_id`),
   UNIQUE KEY `image_filename_uniq` (`image_file`),
   KEY `post_id_idx` (`post_id`),
   KEY `p_id_i_id` (`post_id`,`image_id`),
   KEY `hash_idx` (`image_hash`),
   KEY `image_sha_idx` (`image_sha`),
+  KEY `image_sha256_idx` (`image_sha256`),
+  KEY `image_source_sha256_idx` (`image_source_sha256`),
+  KEY `image_phash_band0` (`image_phash_band0`),
+  KEY `image_phash_band1` (`image_phash_band1`),
+  KEY `image_phash_band2` (`image_phash_band2`),
+  KEY `image_phash_band3` (`image_phash_band3`),
+  KEY `image_phash_band4` (`image_phash_band4`),
   CONSTRAINT `post_id` FOREIGN KEY (`post_id`) REFERENCES `posts` (`post_id`) ON DELETE CASCADE ON UPDATE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_unicode_ci;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
+--
+-- Table structure for table `image_thumbnails`
+--
+
+DROP TABLE IF EXISTS `image_thumbnails`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `image_thumbnails` (
+  `image_id` int unsigned NOT NULL,
+  `thumbnail_profile` varchar(20) COLLATE utf8mb3_unicode_ci NOT NULL,
+  `thumbnail_file` varchar(40) COLLATE utf8mb3_unicode_ci NOT NULL,
+  `thumbnail_height` smallint unsigned NOT NULL DEFAULT '0',
+  `thumbnail_width` smallint unsigned NOT NULL DEFAULT '0',
+  PRIMARY KEY (`image_id`,`thumbnail_profile`),
+  CONSTRAINT `thumbnail_image_id` FOREIGN KEY (`image_id`) REFERENCES `images` (`image_id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_unicode_ci;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `post_revisions`
+--
+
+DROP TABLE IF EXISTS `post_revisions`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `post_revisions` (
+  `revision_id` int unsigned NOT NULL AUTO_INCREMENT,
+  `post_id` int unsigned NOT NULL,
+  `revision_time` datetime NOT NULL,
+  `revision_text` text COLLATE utf8mb3_unicode_ci,
+  PRIMARY KEY (`revision_id`),
+  KEY `revision_post_id` (`post_id`),
+  CONSTRAINT `revision_post_id` FOREIGN KEY (`post_id`) REFERENCES `posts` (`post_id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_unicode_ci;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `posts`
 --
@@ -212,7 +278,11 @@ CREATE TABLE `posts` (
   `post_num` smallint unsigned NOT NULL DEFAULT '1',
   `post_ip` varchar(255) COLLATE utf8mb3_unicode_ci NOT NULL,
   `post_time` datetime NOT NULL,
+  `post_edited` datetime DEFAULT NULL,
   `post_text` text COLLATE utf8mb3_unicode_ci,
+  `post_name` varchar(32) COLLATE utf8mb3_unicode_ci DEFAULT NULL,
+  `post_tripcode` char(10) COLLATE utf8mb3_unicode_ci DEFAULT NULL,
+  `post_poster_id` char(8) COLLATE utf8mb3_unicode_ci DEFAULT NULL,
   PRIMARY KEY (`post_id`),
   KEY `thread_id_idx` (`thread_id`),
   KEY `t_id_p_id` (`thread_id`,`post_id`),
@@ -222,6 +292,23 @@ CREATE TABLE `posts` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_unicode_ci;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
+--
+-- Table structure for table `reply_map`
+--
+
+DROP TABLE IF EXISTS `reply_map`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `reply_map` (
+  `post_id` int unsigned NOT NULL,
+  `reply_to` int unsigned NOT NULL,
+  PRIMARY KEY (`post_id`,`reply_to`),
+  KEY `reply_map_reply_to` (`reply_to`),
+  CONSTRAINT `reply_map_post_id` FOREIGN KEY (`post_id`) REFERENCES `posts` (`post_id`) ON DELETE CASCADE ON UPDATE CASCADE,
+  CONSTRAINT `reply_map_reply_to` FOREIGN KEY (`reply_to`) REFERENCES `posts` (`post_id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_unicode_ci;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `settings`
 --
@@ -361,9 +448,11 @@ CREATE TABLE `threads` (
   `thread_closed` tinyint(1) NOT NULL DEFAULT '0',
   `thread_sticky` tinyint(1) NOT NULL DEFAULT '0',
   `thread_deleted` tinyint(1) NOT NULL DEFAULT '0',
+  `thread_last_bump` datetime DEFAULT NULL,
   PRIMARY KEY (`thread_id`),
   KEY `ib_id_idx` (`ib_id`),
   KEY `t_id_ib_id` (`ib_id`,`thread_id`),
+  KEY `thread_bump_idx` (`ib_id`,`thread_last_bump`),
   FULLTEXT KEY `threads_thread_title_idx` (`thread_title`),
   CONSTRAINT `ib_id` FOREIGN KEY (`ib_id`) REFERENCES `imageboards` (`ib_id`) ON DELETE CASCADE ON UPDATE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_unicode_ci;
@@ -410,6 +499,27 @@ CREATE TABLE `user_role_map` (
 
 INSERT INTO user_role_map VALUES (1,1);
 
+--
+-- Table structure for table `upload_policies`
+--
+
+DROP TABLE IF EXISTS `upload_policies`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `upload_policies` (
+  `ib_id` tinyint unsigned NOT NULL,
+  `policy_formats` varchar(255) COLLATE utf8mb3_unicode_ci NOT NULL DEFAULT '',
+  `policy_max_size` int unsigned NOT NULL DEFAULT '0',
+  `policy_max_width` smallint unsigned NOT NULL DEFAULT '0',
+  `policy_max_height` smallint unsigned NOT NULL DEFAULT '0',
+  `policy_video_length` smallint unsigned NOT NULL DEFAULT '0',
+  `policy_audio` tinyint(1) NOT NULL DEFAULT '0',
+  `policy_max_files` tinyint unsigned NOT NULL DEFAULT '0',
+  PRIMARY KEY (`ib_id`),
+  CONSTRAINT `policy_ib_id` FOREIGN KEY (`ib_id`) REFERENCES `imageboards` (`ib_id`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb3 COLLATE=utf8mb3_unicode_ci;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `users`
 --
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -21,6 +22,7 @@ import (
 	local "github.com/eirka/eirka-post/config"
 	c "github.com/eirka/eirka-post/controllers"
 	m "github.com/eirka/eirka-post/middleware"
+	u "github.com/eirka/eirka-post/utils"
 )
 
 func init() {
@@ -64,13 +66,33 @@ func init() {
 	r.NewRedisCache()
 
 	// set cors domains
-	cors.SetDomains(local.Settings.CORS.Sites, strings.Split("POST", ","))
+	cors.SetDomains(local.Settings.CORS.Sites, strings.Split("POST,HEAD,PATCH,DELETE", ","))
+
+	// the default backend depends on the host so say which one is used
+	processor, err := u.NewImageProcessor(local.Settings.Post.ImageProcessor)
+	if err == nil {
+		log.Printf("Using %s image processor", processor.Name())
+	}
+
+	// warn about missing media tools, the health check will keep reporting them
+	err = u.RefreshHealth()
+	if err != nil {
+		log.Printf("WARNING: media processing is degraded: %v", err)
+	}
+
+	// the health endpoint reports the stored result
+	u.StartHealthCheck(time.Minute)
+
+	// remove abandoned resumable uploads
+	u.StartUploadExpiry(10 * time.Minute)
 
 }
 
 func main() {
 	r := gin.Default()
 
+	// resumable upload preflights need the tus headers
+	r.Use(m.Tus("/upload"))
 	r.Use(cors.CORS())
 	// verified the csrf token from the request
 	r.Use(csrf.Verify())
@@ -80,6 +102,8 @@ func main() {
 	r.Use(m.RequestLogger())
 
 	r.GET("/status", status.StatusController)
+	r.GET("/health", c.HealthController)
+	r.GET("/health/media", c.MediaStatsController)
 	r.NoRoute(c.ErrorController)
 
 	// all users
@@ -92,6 +116,13 @@ func main() {
 	public.POST("/login", c.LoginController)
 	public.POST("/logout", c.LogoutController)
 
+	// resumable uploads that the thread and reply forms can reference
+	public.POST("/upload", c.ResumableCreateController)
+	public.HEAD("/upload/:id", c.ResumableHeadController)
+	public.PATCH("/upload/:id", c.ResumablePatchController)
+	public.DELETE("/upload/:id", c.ResumableDeleteController)
+	public.POST("/upload/check", c.HashCheckController)
+
 	// new tags group to enforce login
 	tags := r.Group("/tag")
 	tags.Use(user.Auth(true))
@@ -107,6 +138,19 @@ func main() {
 	users.POST("/password", c.PasswordController)
 	users.POST("/email", c.EmailController)
 
+	// authors manage their own posts
+	posts := r.Group("/post")
+	posts.Use(user.Auth(true))
+
+	posts.POST("/edit", m.SpamFilter(), c.EditController)
+	posts.POST("/delete", c.DeleteController)
+
+	// moderators check their board role in the controller
+	mod := r.Group("/mod")
+	mod.Use(user.Auth(true))
+
+	mod.POST("/spoiler", c.SpoilerController)
+
 	s := &http.Server{
 		Addr:              fmt.Sprintf("%s:%d", local.Settings.Post.Host, local.Settings.Post.Port),
 		ReadHeaderTimeout: 2 * time.Second,
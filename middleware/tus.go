@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	local "github.com/eirka/eirka-post/config"
+	u "github.com/eirka/eirka-post/utils"
+)
+
+var (
+	// tusAllowHeaders are the request headers a browser can send to the upload endpoint
+	tusAllowHeaders = []string{"Origin", "Accept", "Content-Type", "Authorization", "Tus-Resumable", "Upload-Offset", "Upload-Length", "Upload-Metadata"}
+	// tusExposeHeaders are the response headers a browser client has to read
+	tusExposeHeaders = []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Upload-Offset", "Upload-Length", "Upload-Expires"}
+	tusAllowMethods  = []string{"POST", "HEAD", "PATCH", "DELETE", "OPTIONS"}
+)
+
+// Tus answers OPTIONS for the resumable upload endpoint and exposes its headers
+// it has to run before the cors middleware which answers every preflight with the default headers
+func Tus(path string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		requestPath := c.Request.URL.Path
+
+		if requestPath != path && !strings.HasPrefix(requestPath, path+"/") {
+			c.Next()
+			return
+		}
+
+		if c.Request.Method != http.MethodOptions {
+			c.Header("Access-Control-Expose-Headers", strings.Join(tusExposeHeaders, ","))
+			c.Next()
+			return
+		}
+
+		origin := c.GetHeader("Origin")
+
+		if isAllowedOrigin(origin) {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+
+		c.Header("Vary", "Origin")
+		c.Header("Access-Control-Allow-Credentials", "true")
+		c.Header("Access-Control-Allow-Methods", strings.Join(tusAllowMethods, ","))
+		c.Header("Access-Control-Allow-Headers", strings.Join(tusAllowHeaders, ","))
+		c.Header("Access-Control-Max-Age", "86400")
+
+		// tus clients use OPTIONS to discover what the server supports
+		c.Header("Tus-Resumable", u.TusVersion)
+		c.Header("Tus-Version", u.TusVersion)
+		c.Header("Tus-Extension", u.TusExtensions)
+
+		c.AbortWithStatus(http.StatusNoContent)
+
+	}
+}
+
+// isAllowedOrigin checks the origin host against the cors sites
+func isAllowedOrigin(origin string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+
+	return slices.Contains(local.Settings.CORS.Sites, strings.ToLower(parsed.Host))
+}
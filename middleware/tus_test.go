@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/cors"
+
+	local "github.com/eirka/eirka-post/config"
+	u "github.com/eirka/eirka-post/utils"
+)
+
+func tusRequest(r http.Handler, method, path, origin string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("Origin", origin)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestTus(t *testing.T) {
+
+	original := local.Settings.CORS.Sites
+	defer func() {
+		local.Settings.CORS.Sites = original
+	}()
+
+	local.Settings.CORS.Sites = []string{"example.com"}
+
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+
+	router.Use(Tus("/upload"))
+	router.Use(cors.CORS())
+
+	router.POST("/upload", func(c *gin.Context) {
+		c.Header("Location", "/upload/abc")
+		c.Status(http.StatusCreated)
+	})
+	router.POST("/reply", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	preflight := tusRequest(router, "OPTIONS", "/upload/abc", "https://example.com")
+
+	assert.Equal(t, 204, preflight.Code, "HTTP request code should match")
+	assert.Equal(t, "https://example.com", preflight.Header().Get("Access-Control-Allow-Origin"), "Origin should be allowed")
+	assert.Contains(t, preflight.Header().Get("Access-Control-Allow-Headers"), "Upload-Metadata", "Tus headers should be allowed")
+	assert.Contains(t, preflight.Header().Get("Access-Control-Allow-Methods"), "PATCH", "Tus methods should be allowed")
+	assert.Equal(t, u.TusVersion, preflight.Header().Get("Tus-Version"), "Version should be sent")
+	assert.Equal(t, u.TusExtensions, preflight.Header().Get("Tus-Extension"), "Extensions should be sent")
+
+	other := tusRequest(router, "OPTIONS", "/upload", "https://other.com")
+
+	assert.Equal(t, 204, other.Code, "HTTP request code should match")
+	assert.Empty(t, other.Header().Get("Access-Control-Allow-Origin"), "Origin should not be allowed")
+
+	create := tusRequest(router, "POST", "/upload", "https://example.com")
+
+	assert.Equal(t, 201, create.Code, "HTTP request code should match")
+	assert.Contains(t, create.Header().Get("Access-Control-Expose-Headers"), "Location", "Location should be exposed")
+	assert.Contains(t, create.Header().Get("Access-Control-Expose-Headers"), "Upload-Offset", "Offset should be exposed")
+
+	reply := tusRequest(router, "POST", "/reply", "https://example.com")
+
+	assert.Equal(t, 200, reply.Code, "HTTP request code should match")
+	assert.Empty(t, reply.Header().Get("Access-Control-Expose-Headers"), "Other routes should not expose tus headers")
+
+	// other routes keep the default preflight
+	replyPreflight := tusRequest(router, "OPTIONS", "/reply", "https://example.com")
+
+	assert.Equal(t, 200, replyPreflight.Code, "HTTP request code should match")
+	assert.NotContains(t, replyPreflight.Header().Get("Access-Control-Allow-Headers"), "Upload-Metadata", "Tus headers should not be allowed")
+
+}
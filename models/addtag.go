@@ -65,7 +65,7 @@ func (a *AddTagModel) Status() (err error) {
 	err = tx.QueryRow(`SELECT count(1) FROM images
 	LEFT JOIN posts on images.post_id = posts.post_id
 	LEFT JOIN threads on posts.thread_id = threads.thread_id
-	WHERE image_id = ? AND ib_id = ?`, a.Image, a.Ib).Scan(&check)
+	WHERE image_id = ? AND ib_id = ? AND image_deleted = 0`, a.Image, a.Ib).Scan(&check)
 	if err != nil {
 		return
 	}
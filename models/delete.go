@@ -0,0 +1,240 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+)
+
+var (
+	// ErrNoImage is returned when only the image is deleted from a post without one
+	ErrNoImage = errors.New("post has no image")
+	// ErrEmptyPost is returned when removing the image would leave the post blank
+	ErrEmptyPost = errors.New("post would be empty, delete the post instead")
+)
+
+// DeleteModel holds the request input
+type DeleteModel struct {
+	UID    uint
+	Ib     uint
+	Thread uint
+	// Num is the post number in the thread
+	Num uint
+	// ImageOnly removes the attachments and keeps the comment
+	// the image rows are kept and marked deleted so their hashes stay around
+	ImageOnly bool
+	// PostID is the post being deleted, set by Status
+	PostID uint
+	// Images are the removed attachments whose files need deleting, set by Post
+	Images []DeletedImage
+}
+
+// DeletedImage has the filenames of a removed attachment
+type DeletedImage struct {
+	Filename   string
+	Thumbnail  string
+	Thumbnails []string
+}
+
+// IsValid will check struct validity
+func (m *DeleteModel) IsValid() bool {
+
+	if m.UID == 0 {
+		return false
+	}
+
+	if m.Ib == 0 {
+		return false
+	}
+
+	if m.Thread == 0 {
+		return false
+	}
+
+	if m.Num == 0 {
+		return false
+	}
+
+	if m.PostID == 0 {
+		return false
+	}
+
+	return true
+
+}
+
+// ValidateInput will make sure all the parameters are valid
+func (m *DeleteModel) ValidateInput() (err error) {
+
+	if m.Thread == 0 {
+		return e.ErrInvalidParam
+	}
+
+	if m.Num == 0 {
+		return e.ErrInvalidParam
+	}
+
+	return
+
+}
+
+// Status will check that the user wrote the post
+func (m *DeleteModel) Status() (err error) {
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	var owner uint
+	var empty bool
+
+	err = dbase.QueryRow(`SELECT posts.post_id, posts.user_id, threads.ib_id, COALESCE(post_text, '') = ''
+	FROM posts
+	INNER JOIN threads on posts.thread_id = threads.thread_id
+	WHERE threads.thread_id = ? AND post_num = ? AND post_deleted = 0 AND thread_deleted = 0`,
+		m.Thread, m.Num).Scan(&m.PostID, &owner, &m.Ib, &empty)
+	if err == sql.ErrNoRows {
+		return e.ErrNotFound
+	} else if err != nil {
+		return
+	}
+
+	// only the author can delete
+	if owner != m.UID {
+		return e.ErrForbidden
+	}
+
+	// a post with only an image would be left blank
+	if m.ImageOnly && empty {
+		return ErrEmptyPost
+	}
+
+	return
+
+}
+
+// IsThread is true when the post is the first post of the thread
+func (m *DeleteModel) IsThread() bool {
+	return m.Num == 1 && !m.ImageOnly
+}
+
+// Post will mark the post or thread as deleted or remove the attachments
+func (m *DeleteModel) Post() (err error) {
+
+	// check model validity
+	if !m.IsValid() {
+		return errors.New("DeleteModel is not valid")
+	}
+
+	// Get transaction handle
+	tx, err := db.GetTransaction()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	switch {
+	case m.ImageOnly:
+		m.Images, err = deletedImages(tx, m.PostID)
+		if err != nil {
+			return
+		}
+
+		if len(m.Images) == 0 {
+			return ErrNoImage
+		}
+
+		// the rows keep the hashes for the ban checks like a deleted post does
+		_, err = tx.Exec("UPDATE images SET image_deleted = 1 WHERE post_id = ? AND image_deleted = 0", m.PostID)
+	case m.IsThread():
+		// the first post takes the whole thread with it
+		_, err = tx.Exec("UPDATE threads SET thread_deleted = 1 WHERE thread_id = ?", m.Thread)
+	default:
+		_, err = tx.Exec("UPDATE posts SET post_deleted = 1 WHERE post_id = ?", m.PostID)
+	}
+	if err != nil {
+		return
+	}
+
+	// Commit transaction
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	return
+
+}
+
+// deletedImages gets the filenames of the attachments of a post
+func deletedImages(tx *sql.Tx, postID uint) (images []DeletedImage, err error) {
+
+	rows, err := tx.Query(`SELECT image_id, image_file, image_thumbnail FROM images
+	WHERE post_id = ? AND image_deleted = 0 ORDER BY image_id FOR UPDATE`, postID)
+	if err != nil {
+		return
+	}
+
+	var ids []uint
+
+	for rows.Next() {
+		var id uint
+		var image DeletedImage
+
+		err = rows.Scan(&id, &image.Filename, &image.Thumbnail)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		ids = append(ids, id)
+		images = append(images, image)
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for n, id := range ids {
+		images[n].Thumbnails, err = thumbnailFiles(tx, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// thumbnailFiles gets the extra thumbnail sizes of an image
+func thumbnailFiles(tx *sql.Tx, imageID uint) (files []string, err error) {
+
+	rows, err := tx.Query("SELECT thumbnail_file FROM image_thumbnails WHERE image_id = ?", imageID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var file string
+
+		err = rows.Scan(&file)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+)
+
+func TestDeleteIsValid(t *testing.T) {
+
+	baddeletes := []DeleteModel{
+		{UID: 0, Ib: 1, Thread: 1, Num: 2, PostID: 5},
+		{UID: 2, Ib: 0, Thread: 1, Num: 2, PostID: 5},
+		{UID: 2, Ib: 1, Thread: 0, Num: 2, PostID: 5},
+		{UID: 2, Ib: 1, Thread: 1, Num: 0, PostID: 5},
+		{UID: 2, Ib: 1, Thread: 1, Num: 2, PostID: 0},
+	}
+
+	for _, input := range baddeletes {
+		assert.False(t, input.IsValid(), "Should be false")
+	}
+
+	gooddelete := DeleteModel{UID: 2, Ib: 1, Thread: 1, Num: 2, PostID: 5}
+
+	assert.True(t, gooddelete.IsValid(), "Should be true")
+
+}
+
+func TestDeleteValidateInput(t *testing.T) {
+
+	baddeletes := []DeleteModel{
+		{Thread: 0, Num: 1},
+		{Thread: 1, Num: 0},
+	}
+
+	for _, input := range baddeletes {
+		assert.Equal(t, e.ErrInvalidParam, input.ValidateInput(), "Error should match")
+	}
+
+	gooddelete := DeleteModel{Thread: 1, Num: 1}
+
+	assert.NoError(t, gooddelete.ValidateInput(), "An error was not expected")
+
+}
+
+func TestDeleteStatus(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	rows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "empty"}).AddRow(5, 2, 1, 0)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 2).
+		WillReturnRows(rows)
+
+	// a post without a comment
+	empty := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "empty"}).AddRow(5, 2, 1, 1)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 2).
+		WillReturnRows(empty)
+
+	first := DeleteModel{UID: 2, Thread: 1, Num: 2, ImageOnly: true}
+
+	err = first.Status()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, uint(5), first.PostID, "Post should match")
+		assert.Equal(t, uint(1), first.Ib, "Ib should match")
+		assert.True(t, first.ImageOnly, "Only the image should be deleted")
+	}
+
+	second := DeleteModel{UID: 2, Thread: 1, Num: 2, ImageOnly: true}
+
+	err = second.Status()
+	assert.Equal(t, ErrEmptyPost, err, "Error should match")
+	assert.True(t, second.ImageOnly, "The post should not be deleted instead")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestDeleteStatusErrors(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 2).
+		WillReturnError(sql.ErrNoRows)
+
+	other := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "empty"}).AddRow(5, 3, 1, 0)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id`).
+		WithArgs(1, 2).
+		WillReturnRows(other)
+
+	for _, expected := range []error{e.ErrNotFound, e.ErrForbidden} {
+		input := DeleteModel{UID: 2, Thread: 1, Num: 2}
+
+		err = input.Status()
+		assert.Equal(t, expected, err, "Error should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestDeletePost(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE posts SET post_deleted = 1").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	input := DeleteModel{UID: 2, Ib: 1, Thread: 1, Num: 2, PostID: 5}
+
+	err = input.Post()
+	assert.NoError(t, err, "An error was not expected")
+	assert.False(t, input.IsThread(), "Should not be a thread")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestDeletePostThread(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE threads SET thread_deleted = 1").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	input := DeleteModel{UID: 2, Ib: 1, Thread: 1, Num: 1, PostID: 4}
+
+	err = input.Post()
+	assert.NoError(t, err, "An error was not expected")
+	assert.True(t, input.IsThread(), "Should be a thread")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestDeletePostImageOnly(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	images := sqlmock.NewRows([]string{"image_id", "image_file", "image_thumbnail"}).
+		AddRow(7, "1.jpg", "1s.jpg").
+		AddRow(8, "2.png", "2s.jpg")
+	// images that were already removed are skipped
+	mock.ExpectQuery(`SELECT image_id, image_file, image_thumbnail FROM images\s+WHERE post_id = \? AND image_deleted = 0`).
+		WithArgs(4).
+		WillReturnRows(images)
+
+	thumbs := sqlmock.NewRows([]string{"thumbnail_file"}).AddRow("1s_large.jpg")
+	mock.ExpectQuery("SELECT thumbnail_file FROM image_thumbnails").
+		WithArgs(7).
+		WillReturnRows(thumbs)
+
+	mock.ExpectQuery("SELECT thumbnail_file FROM image_thumbnails").
+		WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"thumbnail_file"}))
+
+	// the rows stay for the ban checks
+	mock.ExpectExec("UPDATE images SET image_deleted = 1 WHERE post_id = \\? AND image_deleted = 0").
+		WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	mock.ExpectCommit()
+
+	// the first post keeps its thread when only the image goes
+	input := DeleteModel{UID: 2, Ib: 1, Thread: 1, Num: 1, PostID: 4, ImageOnly: true}
+
+	err = input.Post()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, []DeletedImage{
+			{Filename: "1.jpg", Thumbnail: "1s.jpg", Thumbnails: []string{"1s_large.jpg"}},
+			{Filename: "2.png", Thumbnail: "2s.jpg"},
+		}, input.Images, "Images should match")
+	}
+	assert.False(t, input.IsThread(), "Should not delete the thread")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestDeletePostNoImage(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT image_id, image_file, image_thumbnail FROM images").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"image_id", "image_file", "image_thumbnail"}))
+	mock.ExpectRollback()
+
+	input := DeleteModel{UID: 2, Ib: 1, Thread: 1, Num: 2, PostID: 5, ImageOnly: true}
+
+	err = input.Post()
+	assert.Equal(t, ErrNoImage, err, "Error should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
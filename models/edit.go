@@ -0,0 +1,282 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+
+	"github.com/microcosm-cc/bluemonday"
+
+	"github.com/eirka/eirka-libs/config"
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/validate"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// defaultEditWindow is how many minutes a post can be edited when the config is empty
+const defaultEditWindow = 10
+
+// ErrEditWindow is returned when the post is too old to edit
+var ErrEditWindow = errors.New("post can no longer be edited")
+
+// EditModel holds the request input
+type EditModel struct {
+	UID    uint
+	Ib     uint
+	Thread uint
+	// Num is the post number in the thread
+	Num     uint
+	IP      string
+	Comment string
+	// PostID is the post being edited, set by Status
+	PostID uint
+	// Quoted are the other threads that gained or lost a backlink, set by Post
+	Quoted []QuotedThread
+}
+
+// editWindow is how many minutes the author can edit a post
+func editWindow() int {
+	if local.Settings.Posting.EditWindow > 0 {
+		return local.Settings.Posting.EditWindow
+	}
+	return defaultEditWindow
+}
+
+// IsValid will check struct validity
+func (m *EditModel) IsValid() bool {
+
+	if m.UID == 0 {
+		return false
+	}
+
+	if m.Ib == 0 {
+		return false
+	}
+
+	if m.Thread == 0 {
+		return false
+	}
+
+	if m.Num == 0 {
+		return false
+	}
+
+	if m.PostID == 0 {
+		return false
+	}
+
+	if m.IP == "" {
+		return false
+	}
+
+	if m.Comment == "" {
+		return false
+	}
+
+	return true
+
+}
+
+// ValidateInput will make sure all the parameters are valid
+func (m *EditModel) ValidateInput() (err error) {
+
+	if m.Thread == 0 {
+		return e.ErrInvalidParam
+	}
+
+	if m.Num == 0 {
+		return e.ErrInvalidParam
+	}
+
+	// Initialize bluemonday
+	p := bluemonday.StrictPolicy()
+
+	// sanitize for html and xss
+	m.Comment = html.UnescapeString(p.Sanitize(m.Comment))
+
+	// an edit always replaces the comment so one is required
+	comment := validate.Validate{Input: m.Comment, Max: config.Settings.Limits.CommentMaxLength, Min: config.Settings.Limits.CommentMinLength}
+
+	if comment.IsEmpty() {
+		return e.ErrNoComment
+	} else if comment.MinLength() {
+		return e.ErrCommentShort
+	} else if comment.MaxLength() {
+		return e.ErrCommentLong
+	}
+
+	// check the quotes can be parsed, they are resolved when posting
+	quotes, err := parseQuotes(m.Comment)
+	if err != nil {
+		return
+	}
+
+	// a post cant quote itself
+	for _, quote := range quotes {
+		if quote.Board == "" && quote.Num == m.Num {
+			return ErrInvalidQuote
+		}
+	}
+
+	return
+
+}
+
+// Status will check that the user wrote the post and can still edit it
+func (m *EditModel) Status() (err error) {
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	var owner uint
+	var closed, editable bool
+
+	err = dbase.QueryRow(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed,
+	post_time > DATE_SUB(NOW(), INTERVAL ? MINUTE)
+	FROM posts
+	INNER JOIN threads on posts.thread_id = threads.thread_id
+	WHERE threads.thread_id = ? AND post_num = ? AND post_deleted = 0 AND thread_deleted = 0`,
+		editWindow(), m.Thread, m.Num).Scan(&m.PostID, &owner, &m.Ib, &closed, &editable)
+	if err == sql.ErrNoRows {
+		return e.ErrNotFound
+	} else if err != nil {
+		return
+	}
+
+	// only the author can edit
+	if owner != m.UID {
+		return e.ErrForbidden
+	}
+
+	if closed {
+		return e.ErrThreadClosed
+	}
+
+	if !editable {
+		return ErrEditWindow
+	}
+
+	return
+
+}
+
+// Post will save the old comment as a revision and update the post
+func (m *EditModel) Post() (err error) {
+
+	// check model validity
+	if !m.IsValid() {
+		return errors.New("EditModel is not valid")
+	}
+
+	// Get transaction handle
+	tx, err := db.GetTransaction()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// keep the current text for the moderators
+	_, err = tx.Exec(`INSERT INTO post_revisions (post_id, revision_time, revision_text)
+	SELECT post_id, COALESCE(post_edited, post_time), post_text FROM posts WHERE post_id = ?`, m.PostID)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec("UPDATE posts SET post_text = ?, post_edited = NOW() WHERE post_id = ?", m.Comment, m.PostID)
+	if err != nil {
+		return
+	}
+
+	// threads that lose a backlink need to be refreshed too
+	previous, err := quotedThreads(tx, m.PostID, m.Thread)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec("DELETE FROM reply_map WHERE post_id = ?", m.PostID)
+	if err != nil {
+		return
+	}
+
+	quotes, err := parseQuotes(m.Comment)
+	if err != nil {
+		return
+	}
+
+	targets, quoted, err := resolveQuotes(tx, m.Thread, quotes)
+	if err != nil {
+		return
+	}
+
+	err = insertQuotes(tx, int64(m.PostID), targets)
+	if err != nil {
+		return
+	}
+
+	// Commit transaction
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+
+	m.Quoted = mergeQuoted(previous, quoted)
+
+	return
+
+}
+
+// quotedThreads gets the other threads a post has backlinks in
+func quotedThreads(tx *sql.Tx, postID, thread uint) (threads []QuotedThread, err error) {
+
+	rows, err := tx.Query(`SELECT DISTINCT threads.ib_id, threads.thread_id FROM reply_map
+	INNER JOIN posts on reply_map.reply_to = posts.post_id
+	INNER JOIN threads on posts.thread_id = threads.thread_id
+	WHERE reply_map.post_id = ? AND threads.thread_id != ?`, postID, thread)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var quoted QuotedThread
+
+		err = rows.Scan(&quoted.Ib, &quoted.Thread)
+		if err != nil {
+			return nil, err
+		}
+
+		threads = append(threads, quoted)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// mergeQuoted combines lists of quoted threads without duplicates
+func mergeQuoted(lists ...[]QuotedThread) (merged []QuotedThread) {
+
+	seen := make(map[QuotedThread]bool)
+
+	for _, list := range lists {
+		for _, quoted := range list {
+			if seen[quoted] {
+				continue
+			}
+
+			seen[quoted] = true
+
+			merged = append(merged, quoted)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+)
+
+func TestEditIsValid(t *testing.T) {
+
+	badedits := []EditModel{
+		{UID: 0, Ib: 1, Thread: 1, Num: 2, PostID: 5, IP: "127.0.0.1", Comment: "test"},
+		{UID: 2, Ib: 0, Thread: 1, Num: 2, PostID: 5, IP: "127.0.0.1", Comment: "test"},
+		{UID: 2, Ib: 1, Thread: 0, Num: 2, PostID: 5, IP: "127.0.0.1", Comment: "test"},
+		{UID: 2, Ib: 1, Thread: 1, Num: 0, PostID: 5, IP: "127.0.0.1", Comment: "test"},
+		{UID: 2, Ib: 1, Thread: 1, Num: 2, PostID: 0, IP: "127.0.0.1", Comment: "test"},
+		{UID: 2, Ib: 1, Thread: 1, Num: 2, PostID: 5, IP: "", Comment: "test"},
+		{UID: 2, Ib: 1, Thread: 1, Num: 2, PostID: 5, IP: "127.0.0.1", Comment: ""},
+	}
+
+	for _, edit := range badedits {
+		assert.False(t, edit.IsValid(), "Should be false")
+	}
+
+	goodedit := EditModel{UID: 2, Ib: 1, Thread: 1, Num: 2, PostID: 5, IP: "127.0.0.1", Comment: "test"}
+
+	assert.True(t, goodedit.IsValid(), "Should be true")
+
+}
+
+func TestEditValidateInput(t *testing.T) {
+
+	badedits := []EditModel{
+		{Thread: 0, Num: 2, Comment: "hello there"},
+		{Thread: 1, Num: 0, Comment: "hello there"},
+		{Thread: 1, Num: 2, Comment: ""},
+		{Thread: 1, Num: 2, Comment: "<b></b>"},
+		{Thread: 1, Num: 2, Comment: "d"},
+		{Thread: 1, Num: 2, Comment: randSeq(2000)},
+	}
+
+	for _, edit := range badedits {
+		assert.Error(t, edit.ValidateInput(), "Should return error")
+	}
+
+	// a post cant quote itself
+	self := EditModel{Thread: 1, Num: 2, Comment: "see >>2"}
+	assert.Equal(t, ErrInvalidQuote, self.ValidateInput(), "Error should match")
+
+	goodedit := EditModel{Thread: 1, Num: 2, Comment: "<b>hello</b> there >>1"}
+
+	if assert.NoError(t, goodedit.ValidateInput(), "Should not return error") {
+		assert.Equal(t, "hello there >>1", goodedit.Comment, "Comment should be sanitized")
+	}
+
+}
+
+func TestEditStatus(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	rows := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "thread_closed", "editable"}).AddRow(5, 2, 1, 0, 1)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed`).
+		WithArgs(defaultEditWindow, 1, 2).
+		WillReturnRows(rows)
+
+	edit := EditModel{UID: 2, Thread: 1, Num: 2}
+
+	err = edit.Status()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, uint(5), edit.PostID, "Post should match")
+		assert.Equal(t, uint(1), edit.Ib, "Ib should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestEditStatusErrors(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed`).
+		WithArgs(defaultEditWindow, 1, 2).
+		WillReturnError(sql.ErrNoRows)
+
+	// another users post
+	other := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "thread_closed", "editable"}).AddRow(5, 3, 1, 0, 1)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed`).
+		WithArgs(defaultEditWindow, 1, 2).
+		WillReturnRows(other)
+
+	closed := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "thread_closed", "editable"}).AddRow(5, 2, 1, 1, 1)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed`).
+		WithArgs(defaultEditWindow, 1, 2).
+		WillReturnRows(closed)
+
+	old := sqlmock.NewRows([]string{"post_id", "user_id", "ib_id", "thread_closed", "editable"}).AddRow(5, 2, 1, 0, 0)
+	mock.ExpectQuery(`SELECT posts.post_id, posts.user_id, threads.ib_id, thread_closed`).
+		WithArgs(defaultEditWindow, 1, 2).
+		WillReturnRows(old)
+
+	for _, expected := range []error{e.ErrNotFound, e.ErrForbidden, e.ErrThreadClosed, ErrEditWindow} {
+		edit := EditModel{UID: 2, Thread: 1, Num: 2}
+
+		err = edit.Status()
+		assert.Equal(t, expected, err, "Error should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestEditPost(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	mock.ExpectExec("INSERT INTO post_revisions").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("UPDATE posts SET post_text").
+		WithArgs(">>1 >>>/pics/45", 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// the old comment quoted another thread
+	previous := sqlmock.NewRows([]string{"ib_id", "thread_id"}).AddRow(3, 60)
+	mock.ExpectQuery("SELECT DISTINCT threads.ib_id, threads.thread_id FROM reply_map").
+		WithArgs(5, 1).
+		WillReturnRows(previous)
+
+	mock.ExpectExec("DELETE FROM reply_map").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	same := sqlmock.NewRows([]string{"post_id"}).AddRow(4)
+	mock.ExpectQuery("SELECT post_id FROM posts").
+		WithArgs(1, 1).
+		WillReturnRows(same)
+
+	cross := sqlmock.NewRows([]string{"post_id", "ib_id"}).AddRow(20, 2)
+	mock.ExpectQuery("SELECT posts.post_id,threads.ib_id FROM posts").
+		WithArgs("pics", 45).
+		WillReturnRows(cross)
+
+	mock.ExpectExec("INSERT INTO reply_map").
+		WithArgs(5, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectExec("INSERT INTO reply_map").
+		WithArgs(5, 20).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectCommit()
+
+	edit := EditModel{
+		UID:     2,
+		Ib:      1,
+		Thread:  1,
+		Num:     2,
+		PostID:  5,
+		IP:      "10.0.0.1",
+		Comment: ">>1 >>>/pics/45",
+	}
+
+	err = edit.Post()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, []QuotedThread{{Ib: 3, Thread: 60}, {Ib: 2, Thread: 45}}, edit.Quoted, "Quoted threads should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestEditPostInvalid(t *testing.T) {
+
+	edit := EditModel{UID: 2, Ib: 1, Thread: 1, Num: 2, IP: "10.0.0.1", Comment: "test"}
+
+	assert.Error(t, edit.Post(), "An error was expected")
+
+}
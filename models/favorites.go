@@ -62,7 +62,7 @@ func (m *FavoritesModel) Status() (err error) {
 
 	// Check if image exists
 	var imageExists bool
-	err = tx.QueryRow("SELECT count(1) FROM images WHERE image_id = ?", m.Image).Scan(&imageExists)
+	err = tx.QueryRow("SELECT count(1) FROM images WHERE image_id = ? AND image_deleted = 0", m.Image).Scan(&imageExists)
 	if err != nil {
 		return
 	}
@@ -120,7 +120,7 @@ func (m *FavoritesModel) Post() (err error) {
 
 	// Check if image exists
 	var imageExists bool
-	err = tx.QueryRow("SELECT count(1) FROM images WHERE image_id = ?", m.Image).Scan(&imageExists)
+	err = tx.QueryRow("SELECT count(1) FROM images WHERE image_id = ? AND image_deleted = 0", m.Image).Scan(&imageExists)
 	if err != nil {
 		return
 	}
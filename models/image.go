@@ -0,0 +1,127 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// PostImage is an uploaded file attached to a post
+type PostImage struct {
+	Filename  string
+	Thumbnail string
+	MD5       string
+	SHA       string
+	SHA256    string
+	// SourceSHA256 is the hash of a video before it was converted
+	SourceSHA256 string
+	// PHash is null for files that are not hashed like audio
+	PHash       sql.NullInt64
+	OrigWidth   int
+	OrigHeight  int
+	ThumbWidth  int
+	ThumbHeight int
+	Thumbnails  []ImageThumbnail
+	Spoiler     bool
+}
+
+// ImageThumbnail is an extra thumbnail size for an image
+type ImageThumbnail struct {
+	Profile  string
+	Filename string
+	Width    int
+	Height   int
+}
+
+// IsValid will check struct validity
+func (i *PostImage) IsValid() bool {
+
+	if i.Filename == "" {
+		return false
+	}
+
+	if i.Thumbnail == "" {
+		return false
+	}
+
+	if i.MD5 == "" {
+		return false
+	}
+
+	if i.OrigWidth == 0 {
+		return false
+	}
+
+	if i.OrigHeight == 0 {
+		return false
+	}
+
+	if i.ThumbWidth == 0 {
+		return false
+	}
+
+	if i.ThumbHeight == 0 {
+		return false
+	}
+
+	return true
+
+}
+
+// validImages checks every attachment
+func validImages(images []PostImage) bool {
+
+	for n := range images {
+		if !images[n].IsValid() {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+// insertImages records the attachments of a post in order
+func insertImages(tx *sql.Tx, postID int64, images []PostImage) (err error) {
+
+	for _, image := range images {
+		var result sql.Result
+
+		result, err = tx.Exec("INSERT INTO images (post_id,image_file,image_thumbnail,image_hash,image_sha,image_sha256,image_source_sha256,image_phash,image_orig_height,image_orig_width,image_tn_height,image_tn_width,image_spoiler) VALUES (?,?,?,?,?,?,NULLIF(?, ''),?,?,?,?,?,?)",
+			postID, image.Filename, image.Thumbnail, image.MD5, image.SHA, image.SHA256, image.SourceSHA256, image.PHash, image.OrigHeight, image.OrigWidth, image.ThumbHeight, image.ThumbWidth, image.Spoiler)
+		if err != nil {
+			return
+		}
+
+		if len(image.Thumbnails) == 0 {
+			continue
+		}
+
+		var imageID int64
+
+		imageID, err = result.LastInsertId()
+		if err != nil {
+			return
+		}
+
+		// record the extra thumbnail sizes
+		err = insertThumbnails(tx, imageID, image.Thumbnails)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// insertThumbnails records the extra thumbnail sizes for an image
+func insertThumbnails(tx *sql.Tx, imageID int64, thumbnails []ImageThumbnail) (err error) {
+
+	for _, thumb := range thumbnails {
+		_, err = tx.Exec("INSERT INTO image_thumbnails (image_id,thumbnail_profile,thumbnail_file,thumbnail_height,thumbnail_width) VALUES (?,?,?,?,?)",
+			imageID, thumb.Profile, thumb.Filename, thumb.Height, thumb.Width)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// lengths of the poster fields
+const (
+	nameMaxLength  = 32
+	tripcodeLength = 10
+	posterIDLength = 8
+)
+
+// ErrNameLong is returned when the poster name is too long
+var ErrNameLong = errors.New("name too long")
+
+// Poster is how a post is signed on boards with names or ids
+type Poster struct {
+	// Name is the name input, a password after # makes a tripcode
+	Name string
+	// Tripcode is set by Post on boards with tripcodes
+	Tripcode string
+	// ID is the per thread poster id, set by Post on boards with ids
+	ID string
+	// password is split from the name by ValidateInput
+	password string
+}
+
+// validate sanitizes the name and splits off the tripcode password
+func (p *Poster) validate() (err error) {
+
+	name, password, _ := strings.Cut(p.Name, "#")
+
+	// sanitize html and xss
+	name = strings.TrimSpace(html.UnescapeString(bluemonday.StrictPolicy().Sanitize(name)))
+
+	if len([]rune(name)) > nameMaxLength {
+		return ErrNameLong
+	}
+
+	p.Name = name
+	p.password = password
+
+	return
+
+}
+
+// sign sets the tripcode and poster id the board uses
+func (p *Poster) sign(ib, thread uint, ip string, now time.Time) {
+
+	secret := local.Settings.Posting.Secret
+
+	// names and tripcodes are dropped on anonymous boards
+	if secret == "" || !slices.Contains(local.Settings.Posting.Tripcodes, ib) {
+		p.Name = ""
+		p.Tripcode = ""
+	} else if p.password != "" {
+		p.Tripcode = posterHash([]byte(secret), "trip:"+p.password, tripcodeLength)
+	}
+
+	if secret != "" && slices.Contains(local.Settings.Posting.PosterIDs, ib) {
+		p.ID = posterHash(dailySalt(secret, now), fmt.Sprintf("id:%d:%s", thread, ip), posterIDLength)
+	}
+
+}
+
+// dailySalt changes the poster ids every day so they cant be followed across days
+func dailySalt(secret string, now time.Time) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("salt:" + now.UTC().Format(time.DateOnly)))
+	return mac.Sum(nil)
+}
+
+// posterHash is a short printable hmac of the input
+func posterHash(key []byte, input string, length int) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(input))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:length]
+}
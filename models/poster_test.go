@@ -0,0 +1,146 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/db"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// posterTestBoards turns on names and ids for board 1
+func posterTestBoards(t *testing.T) {
+	t.Helper()
+
+	original := local.Settings.Posting
+
+	local.Settings.Posting.Secret = "secret"
+	local.Settings.Posting.PosterIDs = []uint{1}
+	local.Settings.Posting.Tripcodes = []uint{1}
+
+	t.Cleanup(func() {
+		local.Settings.Posting = original
+	})
+}
+
+func TestPosterValidate(t *testing.T) {
+
+	poster := Poster{Name: " <b>anon</b>#hunter2#more"}
+
+	if assert.NoError(t, poster.validate(), "An error was not expected") {
+		assert.Equal(t, "anon", poster.Name, "Name should be sanitized")
+		assert.Equal(t, "hunter2#more", poster.password, "Password should be split off")
+	}
+
+	long := Poster{Name: strings.Repeat("a", nameMaxLength+1) + "#password"}
+
+	assert.Equal(t, ErrNameLong, long.validate(), "Error should match")
+
+	// only the name counts toward the length
+	fits := Poster{Name: strings.Repeat("a", nameMaxLength) + "#" + strings.Repeat("b", 100)}
+
+	assert.NoError(t, fits.validate(), "An error was not expected")
+
+}
+
+func TestPosterSign(t *testing.T) {
+	posterTestBoards(t)
+
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	sign := func(ib, thread uint, ip, name string, now time.Time) Poster {
+		poster := Poster{Name: name}
+		assert.NoError(t, poster.validate(), "An error was not expected")
+		poster.sign(ib, thread, ip, now)
+		return poster
+	}
+
+	first := sign(1, 5, "10.0.0.1", "anon#hunter2", day)
+
+	assert.Equal(t, "anon", first.Name, "Name should match")
+	assert.Len(t, first.Tripcode, tripcodeLength, "Tripcode should be set")
+	assert.Len(t, first.ID, posterIDLength, "Poster id should be set")
+
+	// the same poster in the same thread on the same day
+	again := sign(1, 5, "10.0.0.1", "other#hunter2", day.Add(time.Hour))
+
+	assert.Equal(t, first.Tripcode, again.Tripcode, "Tripcode should only depend on the password")
+	assert.Equal(t, first.ID, again.ID, "Poster id should be stable")
+
+	assert.NotEqual(t, first.ID, sign(1, 6, "10.0.0.1", "", day).ID, "Poster id should change with the thread")
+	assert.NotEqual(t, first.ID, sign(1, 5, "10.0.0.2", "", day).ID, "Poster id should change with the ip")
+	assert.NotEqual(t, first.ID, sign(1, 5, "10.0.0.1", "", day.Add(24*time.Hour)).ID, "Poster id should change every day")
+	assert.NotEqual(t, first.Tripcode, sign(1, 5, "10.0.0.1", "anon#hunter3", day).Tripcode, "Tripcode should change with the password")
+
+	// a name without a password has no tripcode
+	plain := sign(1, 5, "10.0.0.1", "anon", day)
+
+	assert.Equal(t, "anon", plain.Name, "Name should match")
+	assert.Empty(t, plain.Tripcode, "Tripcode should be empty")
+
+	// other boards are anonymous
+	anonymous := sign(2, 5, "10.0.0.1", "anon#hunter2", day)
+
+	assert.Equal(t, Poster{password: "hunter2"}, anonymous, "Nothing should be set")
+
+	// the secret is needed for both
+	local.Settings.Posting.Secret = ""
+
+	assert.Equal(t, Poster{password: "hunter2"}, sign(1, 5, "10.0.0.1", "anon#hunter2", day), "Nothing should be set")
+
+}
+
+func TestReplyPostPoster(t *testing.T) {
+	posterTestBoards(t)
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	rows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	mock.ExpectExec("INSERT INTO posts").
+		WithArgs(1, 1, 2, "10.0.0.1", "test", "anon", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(6, 1))
+
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectCommit()
+
+	reply := ReplyModel{
+		UID:     1,
+		Ib:      1,
+		Thread:  1,
+		IP:      "10.0.0.1",
+		Comment: "test",
+		Poster:  Poster{Name: "anon#hunter2"},
+	}
+
+	assert.NoError(t, reply.ValidateInput(), "An error was not expected")
+
+	err = reply.Post()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Len(t, reply.Poster.Tripcode, tripcodeLength, "Tripcode should be set")
+		assert.Len(t, reply.Poster.ID, posterIDLength, "Poster id should be set")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+// maxQuotes is the most posts a comment can quote
+const maxQuotes = 20
+
+var (
+	// ErrInvalidQuote is returned when a comment quotes a post that does not exist or was deleted
+	ErrInvalidQuote = errors.New("quoted post does not exist")
+	// ErrTooManyQuotes is returned when a comment quotes too many posts
+	ErrTooManyQuotes = errors.New("too many quotes")
+)
+
+// quoteRegex matches >>123 for a post in the same thread and >>>/board/456 for another thread
+var quoteRegex = regexp.MustCompile(`>>>/([A-Za-z0-9_-]+)/(\d+)|>>(\d+)`)
+
+// Quote is a reference to another post in a comment
+type Quote struct {
+	// Board is the board title for a reference to another thread
+	Board string
+	// Thread is the quoted thread, the post is its first post
+	Thread uint
+	// Num is the post number in the same thread
+	Num uint
+}
+
+// QuotedThread is another thread that got a backlink from the post
+type QuotedThread struct {
+	Ib     uint
+	Thread uint
+}
+
+// parseQuotes finds the unique quotes in a comment
+func parseQuotes(comment string) (quotes []Quote, err error) {
+
+	seen := make(map[Quote]bool)
+
+	for _, match := range quoteRegex.FindAllStringSubmatch(comment, -1) {
+		var quote Quote
+
+		if match[1] != "" {
+			quote.Board = match[1]
+			quote.Thread, err = parseQuoteNum(match[2])
+		} else {
+			quote.Num, err = parseQuoteNum(match[3])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[quote] {
+			continue
+		}
+
+		seen[quote] = true
+
+		quotes = append(quotes, quote)
+	}
+
+	if len(quotes) > maxQuotes {
+		return nil, ErrTooManyQuotes
+	}
+
+	return
+}
+
+// parseQuoteNum rejects numbers that cant be a post or thread
+func parseQuoteNum(num string) (uint, error) {
+	n, err := strconv.ParseUint(num, 10, 32)
+	if err != nil || n == 0 {
+		return 0, ErrInvalidQuote
+	}
+
+	return uint(n), nil
+}
+
+// resolveQuotes finds the post ids of the quotes
+// thread is the thread being posted to, a new thread has no posts to quote yet
+func resolveQuotes(tx *sql.Tx, thread uint, quotes []Quote) (targets []uint, threads []QuotedThread, err error) {
+
+	seen := make(map[uint]bool)
+
+	for _, quote := range quotes {
+		var target uint
+		var quoted QuotedThread
+
+		if quote.Board == "" {
+			if thread == 0 {
+				return nil, nil, ErrInvalidQuote
+			}
+
+			err = tx.QueryRow(`SELECT post_id FROM posts
+			WHERE thread_id = ? AND post_num = ? AND post_deleted = 0`, thread, quote.Num).Scan(&target)
+		} else {
+			err = tx.QueryRow(`SELECT posts.post_id,threads.ib_id FROM posts
+			INNER JOIN threads on posts.thread_id = threads.thread_id
+			INNER JOIN imageboards on threads.ib_id = imageboards.ib_id
+			WHERE ib_title = ? AND threads.thread_id = ? AND post_num = 1 AND post_deleted = 0 AND thread_deleted = 0`,
+				quote.Board, quote.Thread).Scan(&target, &quoted.Ib)
+			quoted.Thread = quote.Thread
+		}
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrInvalidQuote
+		} else if err != nil {
+			return
+		}
+
+		if seen[target] {
+			continue
+		}
+
+		seen[target] = true
+
+		targets = append(targets, target)
+
+		// the thread being posted to is already invalidated
+		if quote.Board != "" && quote.Thread != thread {
+			threads = append(threads, quoted)
+		}
+	}
+
+	return
+}
+
+// insertQuotes adds the quoted posts to the reply map
+func insertQuotes(tx *sql.Tx, postID int64, targets []uint) (err error) {
+
+	for _, target := range targets {
+		_, err = tx.Exec("INSERT INTO reply_map (post_id,reply_to) VALUES (?,?)", postID, target)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
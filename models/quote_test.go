@@ -0,0 +1,176 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/db"
+)
+
+func TestParseQuotes(t *testing.T) {
+
+	quotes, err := parseQuotes(">>2 look at this >>>/pics/45 and >>2 again\n>>13")
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, []Quote{
+			{Num: 2},
+			{Board: "pics", Thread: 45},
+			{Num: 13},
+		}, quotes, "Quotes should match")
+	}
+
+	quotes, err = parseQuotes("no quotes > here >> at all >>> either")
+	assert.NoError(t, err, "An error was not expected")
+	assert.Empty(t, quotes, "There should be no quotes")
+
+	_, err = parseQuotes(">>0")
+	assert.Equal(t, ErrInvalidQuote, err, "Error should match")
+
+	_, err = parseQuotes(">>99999999999")
+	assert.Equal(t, ErrInvalidQuote, err, "Error should match")
+
+	var many []string
+	for i := 1; i <= maxQuotes+1; i++ {
+		many = append(many, fmt.Sprintf(">>%d", i))
+	}
+
+	_, err = parseQuotes(strings.Join(many, " "))
+	assert.Equal(t, ErrTooManyQuotes, err, "Error should match")
+
+}
+
+func TestReplyPostQuotes(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	rows := sqlmock.NewRows([]string{"nextnum"}).AddRow(3)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	same := sqlmock.NewRows([]string{"post_id"}).AddRow(10)
+	mock.ExpectQuery("SELECT post_id FROM posts").
+		WithArgs(1, 2).
+		WillReturnRows(same)
+
+	cross := sqlmock.NewRows([]string{"post_id", "ib_id"}).AddRow(20, 2)
+	mock.ExpectQuery("SELECT posts.post_id,threads.ib_id FROM posts").
+		WithArgs("pics", 45).
+		WillReturnRows(cross)
+
+	mock.ExpectExec("INSERT INTO posts").
+		WithArgs(1, 1, 3, "10.0.0.1", ">>2 >>>/pics/45", "", "", "").
+		WillReturnResult(sqlmock.NewResult(30, 1))
+
+	mock.ExpectExec("INSERT INTO reply_map").
+		WithArgs(30, 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectExec("INSERT INTO reply_map").
+		WithArgs(30, 20).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectCommit()
+
+	reply := ReplyModel{
+		UID:     1,
+		Ib:      1,
+		Thread:  1,
+		IP:      "10.0.0.1",
+		Comment: ">>2 >>>/pics/45",
+	}
+
+	err = reply.Post()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, []QuotedThread{{Ib: 2, Thread: 45}}, reply.Quoted, "Quoted threads should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestReplyPostInvalidQuote(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	rows := sqlmock.NewRows([]string{"nextnum"}).AddRow(3)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	// deleted posts are not found
+	mock.ExpectQuery("SELECT post_id FROM posts").
+		WithArgs(1, 2).
+		WillReturnError(sql.ErrNoRows)
+
+	mock.ExpectRollback()
+
+	reply := ReplyModel{
+		UID:     1,
+		Ib:      1,
+		Thread:  1,
+		IP:      "10.0.0.1",
+		Comment: ">>2",
+	}
+
+	err = reply.Post()
+	assert.Equal(t, ErrInvalidQuote, err, "Error should match")
+	assert.Empty(t, reply.Quoted, "Nothing should be quoted")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestThreadPostQuotes(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	thread := ThreadModel{
+		UID:     1,
+		Ib:      1,
+		IP:      "10.0.0.1",
+		Title:   "test",
+		Comment: ">>1 first",
+		Images:  []PostImage{{Filename: "test.jpg", Thumbnail: "tests.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}},
+	}
+
+	// a new thread has no posts to quote
+	err = thread.Post()
+	assert.Equal(t, ErrInvalidQuote, err, "Error should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"html"
+	"time"
 
 	"github.com/microcosm-cc/bluemonday"
 
@@ -11,24 +12,27 @@ import (
 	"github.com/eirka/eirka-libs/db"
 	e "github.com/eirka/eirka-libs/errors"
 	"github.com/eirka/eirka-libs/validate"
+
+	local "github.com/eirka/eirka-post/config"
 )
 
 // ReplyModel holds the request input
 type ReplyModel struct {
-	UID         uint
-	Ib          uint
-	Thread      uint
-	IP          string
-	Comment     string
-	Filename    string
-	Thumbnail   string
-	MD5         string
-	SHA         string
-	OrigWidth   int
-	OrigHeight  int
-	ThumbWidth  int
-	ThumbHeight int
-	Image       bool
+	UID     uint
+	Ib      uint
+	Thread  uint
+	IP      string
+	Comment string
+	Images  []PostImage
+	Image   bool
+	// Quoted are the other threads the comment quotes, set by Post
+	Quoted []QuotedThread
+	// Poster has the name, tripcode and poster id
+	Poster Poster
+	// Sage replies do not bump the thread
+	Sage bool
+	// posts is how many posts the thread had, set by Post
+	posts uint
 }
 
 // IsValid will check struct validity
@@ -56,31 +60,11 @@ func (m *ReplyModel) IsValid() bool {
 
 	if m.Image {
 
-		if m.Filename == "" {
-			return false
-		}
-
-		if m.Thumbnail == "" {
-			return false
-		}
-
-		if m.MD5 == "" {
-			return false
-		}
-
-		if m.OrigWidth == 0 {
-			return false
-		}
-
-		if m.OrigHeight == 0 {
-			return false
-		}
-
-		if m.ThumbWidth == 0 {
+		if len(m.Images) == 0 {
 			return false
 		}
 
-		if m.ThumbHeight == 0 {
+		if !validImages(m.Images) {
 			return false
 		}
 
@@ -123,6 +107,17 @@ func (m *ReplyModel) ValidateInput() (err error) {
 		}
 	}
 
+	// check the quotes can be parsed, they are resolved when posting
+	_, err = parseQuotes(m.Comment)
+	if err != nil {
+		return
+	}
+
+	err = m.Poster.validate()
+	if err != nil {
+		return
+	}
+
 	return
 
 }
@@ -185,6 +180,20 @@ func (m *ReplyModel) Status() (err error) {
 	return
 }
 
+// Bumps is true when the reply moves the thread to the top of the board
+func (m *ReplyModel) Bumps() bool {
+	return !m.Sage && m.posts < bumpLimit()
+}
+
+// bumpLimit is how many posts a thread can have before replies stop bumping it
+func bumpLimit() uint {
+	limit := local.Settings.Posting.BumpLimit
+	if limit > 0 && limit < config.Settings.Limits.PostsMax {
+		return limit
+	}
+	return config.Settings.Limits.PostsMax
+}
+
 // Post will add the reply to the database with a transaction
 func (m *ReplyModel) Post() (err error) {
 
@@ -200,6 +209,15 @@ func (m *ReplyModel) Post() (err error) {
 	}
 	defer tx.Rollback()
 
+	// lock the thread and count its posts so concurrent replies cant bump past the limit
+	err = tx.QueryRow(`SELECT count(post_num) FROM threads
+    INNER JOIN posts on threads.thread_id = posts.thread_id
+    WHERE threads.thread_id = ? AND post_deleted != 1
+    FOR UPDATE`, m.Thread).Scan(&m.posts)
+	if err != nil {
+		return
+	}
+
 	// First get next post_num with row locking to prevent race conditions
 	var nextPostNum uint
 	err = tx.QueryRow(`SELECT COALESCE(MAX(post_num), 0) + 1 
@@ -210,27 +228,52 @@ func (m *ReplyModel) Post() (err error) {
 		return
 	}
 
+	// find the quoted posts before the reply exists so it cant quote itself
+	quotes, err := parseQuotes(m.Comment)
+	if err != nil {
+		return
+	}
+
+	targets, quoted, err := resolveQuotes(tx, m.Thread, quotes)
+	if err != nil {
+		return
+	}
+
+	// the board decides if the post gets a name and poster id
+	m.Poster.sign(m.Ib, m.Thread, m.IP, time.Now())
+
 	// insert new post with the safely obtained post_num
-	e1, err := tx.Exec(`INSERT INTO posts (thread_id, user_id, post_num, post_time, post_ip, post_text)
-                      VALUES (?, ?, ?, NOW(), ?, ?)`,
-		m.Thread, m.UID, nextPostNum, m.IP, m.Comment)
+	e1, err := tx.Exec(`INSERT INTO posts (thread_id, user_id, post_num, post_time, post_ip, post_text, post_name, post_tripcode, post_poster_id)
+                      VALUES (?, ?, ?, NOW(), ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))`,
+		m.Thread, m.UID, nextPostNum, m.IP, m.Comment, m.Poster.Name, m.Poster.Tripcode, m.Poster.ID)
 	if err != nil {
 		return
 	}
 
-	if m.Image {
-		var pID int64
+	pID, err := e1.LastInsertId()
+	if err != nil {
+		return
+	}
 
-		pID, err = e1.LastInsertId()
+	if m.Image {
+		// insert the attachments if there are any
+		err = insertImages(tx, pID, m.Images)
 		if err != nil {
 			return err
 		}
+	}
 
-		// insert image if there is one
-		_, err = tx.Exec("INSERT INTO images (post_id,image_file,image_thumbnail,image_hash,image_sha,image_orig_height,image_orig_width,image_tn_height,image_tn_width) VALUES (?,?,?,?,?,?,?,?,?)",
-			pID, m.Filename, m.Thumbnail, m.MD5, m.SHA, m.OrigHeight, m.OrigWidth, m.ThumbHeight, m.ThumbWidth)
+	// add the backlinks
+	err = insertQuotes(tx, pID, targets)
+	if err != nil {
+		return
+	}
+
+	// threads past the bump limit keep their place
+	if m.Bumps() {
+		_, err = tx.Exec("UPDATE threads SET thread_last_bump = NOW() WHERE thread_id = ?", m.Thread)
 		if err != nil {
-			return err
+			return
 		}
 	}
 
@@ -240,5 +283,7 @@ func (m *ReplyModel) Post() (err error) {
 		return
 	}
 
+	m.Quoted = quoted
+
 	return
 }
@@ -12,6 +12,8 @@ import (
 	"github.com/eirka/eirka-libs/config"
 	"github.com/eirka/eirka-libs/db"
 	e "github.com/eirka/eirka-libs/errors"
+
+	local "github.com/eirka/eirka-post/config"
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -32,13 +34,14 @@ func TestReplyIsValid(t *testing.T) {
 		{UID: 1, Ib: 1, Thread: 0, IP: "127.0.0.1", Comment: "test", Image: false},
 		{UID: 1, Ib: 1, Thread: 1, IP: "", Comment: "test", Image: false},
 		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: false},
-		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Filename: ""},
-		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Filename: "filename.png", Thumbnail: ""},
-		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Filename: "filename.png", Thumbnail: "thumb.png", MD5: ""},
-		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 0},
-		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 100, OrigHeight: 0},
-		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 100, OrigHeight: 100, ThumbWidth: 0},
-		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 100, OrigHeight: 100, ThumbWidth: 100, ThumbHeight: 0},
+		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true},
+		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Images: []PostImage{{Filename: ""}}},
+		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Images: []PostImage{{Filename: "filename.png", Thumbnail: ""}}},
+		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Images: []PostImage{{Filename: "filename.png", Thumbnail: "thumb.png", MD5: ""}}},
+		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Images: []PostImage{{Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 0}}},
+		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Images: []PostImage{{Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 100, OrigHeight: 0}}},
+		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Images: []PostImage{{Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 100, OrigHeight: 100, ThumbWidth: 0}}},
+		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "", Image: true, Images: []PostImage{{Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 100, OrigHeight: 100, ThumbWidth: 100, ThumbHeight: 0}}},
 	}
 
 	for _, reply := range badreplies {
@@ -47,7 +50,7 @@ func TestReplyIsValid(t *testing.T) {
 
 	goodreplies := []ReplyModel{
 		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "test", Image: false},
-		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "test", Image: true, Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 100, OrigHeight: 100, ThumbWidth: 100, ThumbHeight: 100},
+		{UID: 1, Ib: 1, Thread: 1, IP: "127.0.0.1", Comment: "test", Image: true, Images: []PostImage{{Filename: "filename.png", Thumbnail: "thumb.png", MD5: "hash", OrigWidth: 100, OrigHeight: 100, ThumbWidth: 100, ThumbHeight: 100}}},
 	}
 
 	for _, reply := range goodreplies {
@@ -92,15 +95,22 @@ func TestReplyPostConcurrentRace(t *testing.T) {
 
 	// First transaction starts
 	rows1 := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
 		WithArgs(1).
 		WillReturnRows(rows1)
 
 	// First transaction gets post_num = 2 and inserts
 	mock.ExpectExec("INSERT INTO posts").
-		WithArgs(1, 1, 2, "10.0.0.1", "test 1").
+		WithArgs(1, 1, 2, "10.0.0.1", "test 1", "", "", "").
 		WillReturnResult(sqlmock.NewResult(6, 1))
 
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
 	mock.ExpectCommit()
 
 	// Second transaction begins
@@ -108,15 +118,22 @@ func TestReplyPostConcurrentRace(t *testing.T) {
 
 	// Second transaction should get post_num = 3 (incremented)
 	rows2 := sqlmock.NewRows([]string{"nextnum"}).AddRow(3)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
 		WithArgs(1).
 		WillReturnRows(rows2)
 
 	// Second transaction inserts with post_num = 3
 	mock.ExpectExec("INSERT INTO posts").
-		WithArgs(1, 1, 3, "10.0.0.1", "test 2").
+		WithArgs(1, 1, 3, "10.0.0.1", "test 2", "", "", "").
 		WillReturnResult(sqlmock.NewResult(7, 1))
 
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
 	mock.ExpectCommit()
 
 	// First reply
@@ -310,15 +327,22 @@ func TestReplyPost(t *testing.T) {
 
 	// Expect a query for the post_num with locking
 	rows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
 		WithArgs(1).
 		WillReturnRows(rows)
 
 	// Expect the insert with the safely obtained post_num
 	mock.ExpectExec("INSERT INTO posts").
-		WithArgs(1, 1, 2, "10.0.0.1", "test").
+		WithArgs(1, 1, 2, "10.0.0.1", "test", "", "", "").
 		WillReturnResult(sqlmock.NewResult(2, 1))
 
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
 	mock.ExpectCommit()
 
 	reply := ReplyModel{
@@ -349,36 +373,107 @@ func TestReplyPostImage(t *testing.T) {
 
 	// Expect a query for the post_num with locking
 	rows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
 		WithArgs(1).
 		WillReturnRows(rows)
 
 	// Expect the insert with the safely obtained post_num
 	mock.ExpectExec("INSERT INTO posts").
-		WithArgs(1, 1, 2, "10.0.0.1", "test").
+		WithArgs(1, 1, 2, "10.0.0.1", "test", "", "", "").
+		WillReturnResult(sqlmock.NewResult(6, 1))
+
+	mock.ExpectExec("INSERT INTO images").
+		WithArgs(6, "test.jpg", "tests.jpg", "test", "test", "test", "", -42, 1000, 1000, 100, 100, false).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectCommit()
+
+	reply := ReplyModel{
+		UID:     1,
+		Ib:      1,
+		Thread:  1,
+		IP:      "10.0.0.1",
+		Comment: "test",
+		Image:   true,
+		Images: []PostImage{
+			{
+				Filename:    "test.jpg",
+				Thumbnail:   "tests.jpg",
+				MD5:         "test",
+				SHA:         "test",
+				SHA256:      "test",
+				PHash:       sql.NullInt64{Int64: -42, Valid: true},
+				OrigWidth:   1000,
+				OrigHeight:  1000,
+				ThumbWidth:  100,
+				ThumbHeight: 100,
+			},
+		},
+	}
+
+	err = reply.Post()
+	assert.NoError(t, err, "An error was not expected")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestReplyPostAudioImage(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	rows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	mock.ExpectExec("INSERT INTO posts").
+		WithArgs(1, 1, 2, "10.0.0.1", "test", "", "", "").
 		WillReturnResult(sqlmock.NewResult(6, 1))
 
+	// the audio file has no hash
 	mock.ExpectExec("INSERT INTO images").
-		WithArgs(6, "test.jpg", "tests.jpg", "test", "test", 1000, 1000, 100, 100).
+		WithArgs(6, "test.mp3", "tests.jpg", "audio", "audio", "audio", "", nil, 500, 500, 100, 100, false).
 		WillReturnResult(sqlmock.NewResult(2, 1))
 
+	// a solid color image hashes to zero
+	mock.ExpectExec("INSERT INTO images").
+		WithArgs(6, "test.png", "tests.png", "solid", "solid", "solid", "", 0, 500, 500, 100, 100, false).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+
+	mock.ExpectExec("UPDATE threads SET thread_last_bump").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
 	mock.ExpectCommit()
 
 	reply := ReplyModel{
-		UID:         1,
-		Ib:          1,
-		Thread:      1,
-		IP:          "10.0.0.1",
-		Comment:     "test",
-		Image:       true,
-		Filename:    "test.jpg",
-		Thumbnail:   "tests.jpg",
-		MD5:         "test",
-		SHA:         "test",
-		OrigWidth:   1000,
-		OrigHeight:  1000,
-		ThumbWidth:  100,
-		ThumbHeight: 100,
+		UID:     1,
+		Ib:      1,
+		Thread:  1,
+		IP:      "10.0.0.1",
+		Comment: "test",
+		Image:   true,
+		Images: []PostImage{
+			{Filename: "test.mp3", Thumbnail: "tests.jpg", MD5: "audio", SHA: "audio", SHA256: "audio", OrigWidth: 500, OrigHeight: 500, ThumbWidth: 100, ThumbHeight: 100},
+			{Filename: "test.png", Thumbnail: "tests.png", MD5: "solid", SHA: "solid", SHA256: "solid", PHash: sql.NullInt64{Int64: 0, Valid: true}, OrigWidth: 500, OrigHeight: 500, ThumbWidth: 100, ThumbHeight: 100},
+		},
 	}
 
 	err = reply.Post()
@@ -388,6 +483,71 @@ func TestReplyPostImage(t *testing.T) {
 
 }
 
+func TestReplyPostThumbnailsRollback(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	rows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	mock.ExpectExec("INSERT INTO posts").
+		WithArgs(1, 1, 2, "10.0.0.1", "test", "", "", "").
+		WillReturnResult(sqlmock.NewResult(6, 1))
+
+	mock.ExpectExec("INSERT INTO images").
+		WithArgs(6, "test.jpg", "tests.jpg", "test", "test", "test", "", -42, 1000, 1000, 100, 100, false).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	mock.ExpectExec("INSERT INTO image_thumbnails").
+		WithArgs(2, "catalog", "test-catalog.jpg", 50, 50).
+		WillReturnError(errors.New("SQL error"))
+
+	mock.ExpectRollback()
+
+	reply := ReplyModel{
+		UID:     1,
+		Ib:      1,
+		Thread:  1,
+		IP:      "10.0.0.1",
+		Comment: "test",
+		Image:   true,
+		Images: []PostImage{
+			{
+				Filename:    "test.jpg",
+				Thumbnail:   "tests.jpg",
+				MD5:         "test",
+				SHA:         "test",
+				SHA256:      "test",
+				PHash:       sql.NullInt64{Int64: -42, Valid: true},
+				OrigWidth:   1000,
+				OrigHeight:  1000,
+				ThumbWidth:  100,
+				ThumbHeight: 100,
+				Thumbnails: []ImageThumbnail{
+					{Profile: "catalog", Filename: "test-catalog.jpg", Width: 50, Height: 50},
+				},
+			},
+		},
+	}
+
+	err = reply.Post()
+	assert.Error(t, err, "An error was expected")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
 func TestReplyPostRollback(t *testing.T) {
 
 	var err error
@@ -400,13 +560,16 @@ func TestReplyPostRollback(t *testing.T) {
 
 	// Expect a query for the post_num with locking
 	rows := sqlmock.NewRows([]string{"nextnum"}).AddRow(2)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
 		WithArgs(1).
 		WillReturnRows(rows)
 
 	// The insert fails with SQL error
 	mock.ExpectExec("INSERT INTO posts").
-		WithArgs(1, 1, 2, "10.0.0.1", "test").
+		WithArgs(1, 1, 2, "10.0.0.1", "test", "", "", "").
 		WillReturnError(errors.New("SQL error"))
 
 	mock.ExpectRollback()
@@ -449,3 +612,134 @@ func TestReplyPostInvalid(t *testing.T) {
 	}
 
 }
+
+func TestReplyBumps(t *testing.T) {
+
+	original := local.Settings.Posting.BumpLimit
+	defer func() {
+		local.Settings.Posting.BumpLimit = original
+	}()
+
+	local.Settings.Posting.BumpLimit = 0
+
+	assert.Equal(t, config.Settings.Limits.PostsMax, bumpLimit(), "Bump limit should be the post limit")
+
+	assert.True(t, (&ReplyModel{posts: 10}).Bumps(), "Replies should bump")
+	assert.False(t, (&ReplyModel{posts: 10, Sage: true}).Bumps(), "Sage should not bump")
+
+	local.Settings.Posting.BumpLimit = 10
+
+	assert.Equal(t, uint(10), bumpLimit(), "Bump limit should match")
+
+	assert.True(t, (&ReplyModel{posts: 9}).Bumps(), "Replies under the limit should bump")
+	assert.False(t, (&ReplyModel{posts: 10}).Bumps(), "Replies at the limit should not bump")
+
+	// the post limit is always the highest
+	local.Settings.Posting.BumpLimit = config.Settings.Limits.PostsMax + 1
+
+	assert.Equal(t, config.Settings.Limits.PostsMax, bumpLimit(), "Bump limit should be the post limit")
+
+}
+
+func TestReplyPostSage(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	rows := sqlmock.NewRows([]string{"ib", "closed", "total"}).AddRow(1, 0, 2)
+	mock.ExpectQuery(`SELECT ib_id, thread_closed, count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+
+	nums := sqlmock.NewRows([]string{"nextnum"}).AddRow(3)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
+		WithArgs(1).
+		WillReturnRows(nums)
+
+	// the thread is not bumped
+	mock.ExpectExec("INSERT INTO posts").
+		WithArgs(1, 1, 3, "10.0.0.1", "test", "", "", "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	mock.ExpectCommit()
+
+	reply := ReplyModel{
+		UID:     1,
+		Thread:  1,
+		IP:      "10.0.0.1",
+		Comment: "test",
+		Sage:    true,
+	}
+
+	err = reply.Status()
+	assert.NoError(t, err, "An error was not expected")
+
+	err = reply.Post()
+	assert.NoError(t, err, "An error was not expected")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestReplyPostBumpLimit(t *testing.T) {
+
+	original := local.Settings.Posting.BumpLimit
+	defer func() {
+		local.Settings.Posting.BumpLimit = original
+	}()
+
+	local.Settings.Posting.BumpLimit = 10
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	// other replies reached the limit after the status check
+	mock.ExpectBegin()
+
+	total := sqlmock.NewRows([]string{"count"}).AddRow(10)
+	mock.ExpectQuery(`SELECT count\(post_num\) FROM threads.*FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(total)
+
+	nums := sqlmock.NewRows([]string{"nextnum"}).AddRow(11)
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(post_num\\), 0\\) \\+ 1").
+		WithArgs(1).
+		WillReturnRows(nums)
+
+	// the thread is not bumped
+	mock.ExpectExec("INSERT INTO posts").
+		WithArgs(1, 1, 11, "10.0.0.1", "test", "", "", "").
+		WillReturnResult(sqlmock.NewResult(12, 1))
+
+	mock.ExpectCommit()
+
+	reply := ReplyModel{
+		UID:     1,
+		Ib:      1,
+		Thread:  1,
+		IP:      "10.0.0.1",
+		Comment: "test",
+		posts:   5,
+	}
+
+	err = reply.Post()
+	assert.NoError(t, err, "An error was not expected")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
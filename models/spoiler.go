@@ -0,0 +1,98 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+)
+
+// SpoilerModel holds the request input
+type SpoilerModel struct {
+	Ib      uint
+	Image   uint
+	Spoiler bool
+	Thread  uint
+}
+
+// IsValid will check struct validity
+func (m *SpoilerModel) IsValid() bool {
+
+	if m.Ib == 0 {
+		return false
+	}
+
+	if m.Image == 0 {
+		return false
+	}
+
+	if m.Thread == 0 {
+		return false
+	}
+
+	return true
+
+}
+
+// ValidateInput will make sure all the parameters are valid
+func (m *SpoilerModel) ValidateInput() (err error) {
+
+	if m.Ib == 0 {
+		return e.ErrInvalidParam
+	}
+
+	if m.Image == 0 {
+		return e.ErrInvalidParam
+	}
+
+	return
+
+}
+
+// Status will check that the image is on the board and get its thread
+func (m *SpoilerModel) Status() (err error) {
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	// the thread is needed to clear the cache
+	err = dbase.QueryRow(`SELECT threads.thread_id FROM images
+	INNER JOIN posts on images.post_id = posts.post_id
+	INNER JOIN threads on posts.thread_id = threads.thread_id
+	WHERE image_id = ? AND ib_id = ? AND image_deleted = 0`, m.Image, m.Ib).Scan(&m.Thread)
+	if err == sql.ErrNoRows {
+		return e.ErrNotFound
+	} else if err != nil {
+		return
+	}
+
+	return
+
+}
+
+// Post will set the spoiler flag on the image
+func (m *SpoilerModel) Post() (err error) {
+
+	// check model validity
+	if !m.IsValid() {
+		return errors.New("SpoilerModel is not valid")
+	}
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	_, err = dbase.Exec("UPDATE images SET image_spoiler = ? WHERE image_id = ?", m.Spoiler, m.Image)
+	if err != nil {
+		return
+	}
+
+	return
+
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+)
+
+func TestSpoilerValidateInput(t *testing.T) {
+
+	var err error
+
+	badspoilers := []SpoilerModel{
+		{Ib: 0, Image: 1},
+		{Ib: 1, Image: 0},
+	}
+
+	for _, input := range badspoilers {
+		err = input.ValidateInput()
+		if assert.Error(t, err, "An error was expected") {
+			assert.Equal(t, e.ErrInvalidParam, err, "Error should match")
+		}
+	}
+
+	goodspoiler := SpoilerModel{Ib: 1, Image: 1}
+
+	err = goodspoiler.ValidateInput()
+	assert.NoError(t, err, "An error was not expected")
+
+}
+
+func TestSpoilerStatus(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	rows := sqlmock.NewRows([]string{"thread_id"}).AddRow(5)
+	mock.ExpectQuery(`SELECT threads.thread_id FROM images`).
+		WithArgs(3, 1).
+		WillReturnRows(rows)
+
+	spoiler := SpoilerModel{Ib: 1, Image: 3}
+
+	err = spoiler.Status()
+	assert.NoError(t, err, "An error was not expected")
+	assert.Equal(t, uint(5), spoiler.Thread, "Thread should match")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestSpoilerStatusNotFound(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectQuery(`SELECT threads.thread_id FROM images`).
+		WithArgs(3, 2).
+		WillReturnError(sql.ErrNoRows)
+
+	spoiler := SpoilerModel{Ib: 2, Image: 3}
+
+	err = spoiler.Status()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, e.ErrNotFound, err, "Error should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestSpoilerPost(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectExec("UPDATE images SET image_spoiler").
+		WithArgs(false, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	spoiler := SpoilerModel{Ib: 1, Image: 3, Thread: 5}
+
+	err = spoiler.Post()
+	assert.NoError(t, err, "An error was not expected")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+	// the thread comes from the status check
+	invalid := SpoilerModel{Ib: 1, Image: 3}
+
+	err = invalid.Post()
+	assert.Error(t, err, "An error was expected")
+
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"errors"
 	"html"
+	"time"
 
 	"github.com/microcosm-cc/bluemonday"
 
@@ -14,19 +15,16 @@ import (
 
 // ThreadModel holds the request input
 type ThreadModel struct {
-	UID         uint
-	Ib          uint
-	IP          string
-	Title       string
-	Comment     string
-	Filename    string
-	Thumbnail   string
-	MD5         string
-	SHA         string
-	OrigWidth   int
-	OrigHeight  int
-	ThumbWidth  int
-	ThumbHeight int
+	UID     uint
+	Ib      uint
+	IP      string
+	Title   string
+	Comment string
+	Images  []PostImage
+	// Quoted are the threads the comment quotes, set by Post
+	Quoted []QuotedThread
+	// Poster has the name, tripcode and poster id
+	Poster Poster
 }
 
 // IsValid will check struct validity
@@ -52,31 +50,12 @@ func (m *ThreadModel) IsValid() bool {
 		return false
 	}
 
-	if m.Filename == "" {
+	// new threads need a file
+	if len(m.Images) == 0 {
 		return false
 	}
 
-	if m.Thumbnail == "" {
-		return false
-	}
-
-	if m.MD5 == "" {
-		return false
-	}
-
-	if m.OrigWidth == 0 {
-		return false
-	}
-
-	if m.OrigHeight == 0 {
-		return false
-	}
-
-	if m.ThumbWidth == 0 {
-		return false
-	}
-
-	if m.ThumbHeight == 0 {
+	if !validImages(m.Images) {
 		return false
 	}
 
@@ -116,6 +95,17 @@ func (m *ThreadModel) ValidateInput() (err error) {
 		return e.ErrCommentLong
 	}
 
+	// check the quotes can be parsed, they are resolved when posting
+	_, err = parseQuotes(m.Comment)
+	if err != nil {
+		return
+	}
+
+	err = m.Poster.validate()
+	if err != nil {
+		return
+	}
+
 	return
 
 }
@@ -135,8 +125,19 @@ func (m *ThreadModel) Post() (err error) {
 	}
 	defer tx.Rollback()
 
+	// a new thread can only quote other threads
+	quotes, err := parseQuotes(m.Comment)
+	if err != nil {
+		return
+	}
+
+	targets, quoted, err := resolveQuotes(tx, 0, quotes)
+	if err != nil {
+		return
+	}
+
 	// insert into threads table
-	e1, err := tx.Exec("INSERT INTO threads (ib_id,thread_title) VALUES (?,?)",
+	e1, err := tx.Exec("INSERT INTO threads (ib_id,thread_title,thread_last_bump) VALUES (?,?,NOW())",
 		m.Ib, m.Title)
 	if err != nil {
 		return
@@ -148,9 +149,13 @@ func (m *ThreadModel) Post() (err error) {
 		return
 	}
 
+	// the poster id needs the new thread
+	m.Poster.sign(m.Ib, uint(tID), m.IP, time.Now())
+
 	// insert into posts table
-	e2, err := tx.Exec("INSERT INTO posts (thread_id,user_id,post_time,post_ip,post_text) VALUES (?,?,NOW(),?,?)",
-		tID, m.UID, m.IP, m.Comment)
+	e2, err := tx.Exec(`INSERT INTO posts (thread_id,user_id,post_time,post_ip,post_text,post_name,post_tripcode,post_poster_id)
+	VALUES (?,?,NOW(),?,?,NULLIF(?, ''),NULLIF(?, ''),NULLIF(?, ''))`,
+		tID, m.UID, m.IP, m.Comment, m.Poster.Name, m.Poster.Tripcode, m.Poster.ID)
 	if err != nil {
 		return
 	}
@@ -161,9 +166,14 @@ func (m *ThreadModel) Post() (err error) {
 		return
 	}
 
-	// insert into images table
-	_, err = tx.Exec("INSERT INTO images (post_id,image_file,image_thumbnail,image_hash,image_sha,image_orig_height,image_orig_width,image_tn_height,image_tn_width) VALUES (?,?,?,?,?,?,?,?,?)",
-		pID, m.Filename, m.Thumbnail, m.MD5, m.SHA, m.OrigHeight, m.OrigWidth, m.ThumbHeight, m.ThumbWidth)
+	// insert the attachments
+	err = insertImages(tx, pID, m.Images)
+	if err != nil {
+		return
+	}
+
+	// add the backlinks
+	err = insertQuotes(tx, pID, targets)
 	if err != nil {
 		return
 	}
@@ -174,6 +184,8 @@ func (m *ThreadModel) Post() (err error) {
 		return
 	}
 
+	m.Quoted = quoted
+
 	return
 
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"database/sql"
 	"errors"
 	"testing"
 
@@ -13,18 +14,19 @@ import (
 func TestThreadIsValid(t *testing.T) {
 
 	badthreads := []ThreadModel{
-		{UID: 0, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 0, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 0, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 0, ThumbWidth: 100, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 0, ThumbHeight: 100},
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 0},
+		{UID: 0, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 0, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test"},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 0, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 0, ThumbWidth: 100, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 0, ThumbHeight: 100}}},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 0}}},
 	}
 
 	for _, v := range badthreads {
@@ -32,7 +34,7 @@ func TestThreadIsValid(t *testing.T) {
 	}
 
 	goodthreads := []ThreadModel{
-		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
+		{UID: 1, Ib: 1, IP: "127.0.0.1", Title: "test", Comment: "test", Images: []PostImage{{Filename: "test.jpg", Thumbnail: "test.jpg", MD5: "test", OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100}}},
 	}
 
 	for _, v := range goodthreads {
@@ -80,29 +82,35 @@ func TestThreadPost(t *testing.T) {
 		WillReturnResult(sqlmock.NewResult(9, 1))
 
 	mock.ExpectExec("INSERT INTO posts").
-		WithArgs(9, 1, "10.0.0.1", "test").
+		WithArgs(9, 1, "10.0.0.1", "test", "", "", "").
 		WillReturnResult(sqlmock.NewResult(7, 1))
 
 	mock.ExpectExec("INSERT INTO images").
-		WithArgs(7, "test.jpg", "tests.jpg", "test", "test", 1000, 1000, 100, 100).
+		WithArgs(7, "test.jpg", "tests.jpg", "test", "test", "test", "", 42, 1000, 1000, 100, 100, false).
 		WillReturnResult(sqlmock.NewResult(2, 1))
 
 	mock.ExpectCommit()
 
 	thread := ThreadModel{
-		UID:         1,
-		Ib:          1,
-		IP:          "10.0.0.1",
-		Title:       "a cool thread",
-		Comment:     "test",
-		Filename:    "test.jpg",
-		Thumbnail:   "tests.jpg",
-		MD5:         "test",
-		SHA:         "test",
-		OrigWidth:   1000,
-		OrigHeight:  1000,
-		ThumbWidth:  100,
-		ThumbHeight: 100,
+		UID:     1,
+		Ib:      1,
+		IP:      "10.0.0.1",
+		Title:   "a cool thread",
+		Comment: "test",
+		Images: []PostImage{
+			{
+				Filename:    "test.jpg",
+				Thumbnail:   "tests.jpg",
+				MD5:         "test",
+				SHA:         "test",
+				SHA256:      "test",
+				PHash:       sql.NullInt64{Int64: 42, Valid: true},
+				OrigWidth:   1000,
+				OrigHeight:  1000,
+				ThumbWidth:  100,
+				ThumbHeight: 100,
+			},
+		},
 	}
 
 	err = thread.Post()
@@ -112,6 +120,121 @@ func TestThreadPost(t *testing.T) {
 
 }
 
+func TestThreadPostThumbnails(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	mock.ExpectExec("INSERT INTO threads").
+		WithArgs(1, "a cool thread").
+		WillReturnResult(sqlmock.NewResult(9, 1))
+
+	mock.ExpectExec("INSERT INTO posts").
+		WithArgs(9, 1, "10.0.0.1", "test", "", "", "").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+
+	mock.ExpectExec("INSERT INTO images").
+		WithArgs(7, "test.jpg", "tests.jpg", "test", "test", "test", "", 42, 1000, 1000, 100, 100, false).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	mock.ExpectExec("INSERT INTO image_thumbnails").
+		WithArgs(2, "catalog", "test-catalog.jpg", 50, 50).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectExec("INSERT INTO image_thumbnails").
+		WithArgs(2, "preview", "test-preview.png", 400, 400).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectCommit()
+
+	thread := ThreadModel{
+		UID:     1,
+		Ib:      1,
+		IP:      "10.0.0.1",
+		Title:   "a cool thread",
+		Comment: "test",
+		Images: []PostImage{
+			{
+				Filename:    "test.jpg",
+				Thumbnail:   "tests.jpg",
+				MD5:         "test",
+				SHA:         "test",
+				SHA256:      "test",
+				PHash:       sql.NullInt64{Int64: 42, Valid: true},
+				OrigWidth:   1000,
+				OrigHeight:  1000,
+				ThumbWidth:  100,
+				ThumbHeight: 100,
+				Thumbnails: []ImageThumbnail{
+					{Profile: "catalog", Filename: "test-catalog.jpg", Width: 50, Height: 50},
+					{Profile: "preview", Filename: "test-preview.png", Width: 400, Height: 400},
+				},
+			},
+		},
+	}
+
+	err = thread.Post()
+	assert.NoError(t, err, "An error was not expected")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
+func TestThreadPostMultipleImages(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectBegin()
+
+	mock.ExpectExec("INSERT INTO threads").
+		WithArgs(1, "a cool thread").
+		WillReturnResult(sqlmock.NewResult(9, 1))
+
+	mock.ExpectExec("INSERT INTO posts").
+		WithArgs(9, 1, "10.0.0.1", "test", "", "", "").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+
+	mock.ExpectExec("INSERT INTO images").
+		WithArgs(7, "one.jpg", "ones.jpg", "one", "one", "one", "", 42, 1000, 1000, 100, 100, false).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	// a failed attachment rolls back the whole post
+	mock.ExpectExec("INSERT INTO images").
+		WithArgs(7, "two.png", "twos.jpg", "two", "two", "two", "", 24, 500, 500, 50, 50, true).
+		WillReturnError(errors.New("SQL error"))
+
+	mock.ExpectRollback()
+
+	thread := ThreadModel{
+		UID:     1,
+		Ib:      1,
+		IP:      "10.0.0.1",
+		Title:   "a cool thread",
+		Comment: "test",
+		Images: []PostImage{
+			{Filename: "one.jpg", Thumbnail: "ones.jpg", MD5: "one", SHA: "one", SHA256: "one", PHash: sql.NullInt64{Int64: 42, Valid: true}, OrigWidth: 1000, OrigHeight: 1000, ThumbWidth: 100, ThumbHeight: 100},
+			{Filename: "two.png", Thumbnail: "twos.jpg", MD5: "two", SHA: "two", SHA256: "two", PHash: sql.NullInt64{Int64: 24, Valid: true}, OrigWidth: 500, OrigHeight: 500, ThumbWidth: 50, ThumbHeight: 50, Spoiler: true},
+		},
+	}
+
+	err = thread.Post()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, errors.New("SQL error"), err, "Error should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+}
+
 func TestThreadPostRollback(t *testing.T) {
 
 	var err error
@@ -129,18 +252,22 @@ func TestThreadPostRollback(t *testing.T) {
 	mock.ExpectRollback()
 
 	thread := ThreadModel{
-		UID:         1,
-		Ib:          1,
-		IP:          "10.0.0.1",
-		Title:       "a cool thread",
-		Comment:     "test",
-		Filename:    "test.jpg",
-		Thumbnail:   "tests.jpg",
-		MD5:         "test",
-		OrigWidth:   1000,
-		OrigHeight:  1000,
-		ThumbWidth:  100,
-		ThumbHeight: 100,
+		UID:     1,
+		Ib:      1,
+		IP:      "10.0.0.1",
+		Title:   "a cool thread",
+		Comment: "test",
+		Images: []PostImage{
+			{
+				Filename:    "test.jpg",
+				Thumbnail:   "tests.jpg",
+				MD5:         "test",
+				OrigWidth:   1000,
+				OrigHeight:  1000,
+				ThumbWidth:  100,
+				ThumbHeight: 100,
+			},
+		},
 	}
 
 	err = thread.Post()
@@ -157,18 +284,22 @@ func TestThreadPostInvalid(t *testing.T) {
 	var err error
 
 	thread := ThreadModel{
-		UID:         1,
-		Ib:          0,
-		IP:          "10.0.0.1",
-		Title:       "a cool thread",
-		Comment:     "test",
-		Filename:    "test.jpg",
-		Thumbnail:   "tests.jpg",
-		MD5:         "test",
-		OrigWidth:   1000,
-		OrigHeight:  1000,
-		ThumbWidth:  100,
-		ThumbHeight: 100,
+		UID:     1,
+		Ib:      0,
+		IP:      "10.0.0.1",
+		Title:   "a cool thread",
+		Comment: "test",
+		Images: []PostImage{
+			{
+				Filename:    "test.jpg",
+				Thumbnail:   "tests.jpg",
+				MD5:         "test",
+				OrigWidth:   1000,
+				OrigHeight:  1000,
+				ThumbWidth:  100,
+				ThumbHeight: 100,
+			},
+		},
 	}
 
 	err = thread.Post()
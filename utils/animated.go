@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eirka/eirka-libs/config"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// AnimatedProfile is the profile recorded for animated previews
+const AnimatedProfile = "animated"
+
+// defaults for animated previews when the config is empty
+const (
+	defaultAnimatedFormat      = "webp"
+	defaultAnimatedMaxDuration = 3
+	defaultAnimatedMaxSize     = 1 << 20
+	animatedFrameRate          = 15
+)
+
+// ffmpeg encoder options for each preview format
+var animatedFormats = map[string][]string{
+	"webp": {"-c:v", "libwebp", "-quality", "60", "-loop", "0", "-f", "webp"},
+	"webm": {"-c:v", "libvpx-vp9", "-b:v", "0", "-crf", "40", "-deadline", "realtime", "-pix_fmt", "yuv420p", "-f", "webm"},
+}
+
+// animatedFormat is the configured preview format
+func animatedFormat() string {
+	if local.Settings.Uploads.AnimatedFormat != "" {
+		return strings.ToLower(local.Settings.Uploads.AnimatedFormat)
+	}
+	return defaultAnimatedFormat
+}
+
+// animatedMaxDuration is the longest preview in seconds
+func animatedMaxDuration() int {
+	if local.Settings.Uploads.AnimatedMaxDuration > 0 {
+		return local.Settings.Uploads.AnimatedMaxDuration
+	}
+	return defaultAnimatedMaxDuration
+}
+
+// animatedMaxSize is the largest preview in bytes
+func animatedMaxSize() int64 {
+	if local.Settings.Uploads.AnimatedMaxSize > 0 {
+		return int64(local.Settings.Uploads.AnimatedMaxSize)
+	}
+	return defaultAnimatedMaxSize
+}
+
+// isAnimated is true for videos and gifs with more than one frame
+func (i *ImageType) isAnimated() bool {
+	return i.video || (i.mime == "image/gif" && i.frames > 1)
+}
+
+// animatedArgs builds the ffmpeg command for a preview
+func animatedArgs(src, dst, format string, width, height, duration int) []string {
+	args := []string{
+		"-hide_banner",
+		"-loglevel",
+		"error",
+		"-t",
+		fmt.Sprintf("%d", duration),
+		"-i",
+		src,
+		"-an",
+		"-vf",
+		fmt.Sprintf("fps=%d,scale=%d:%d", animatedFrameRate, width, height),
+	}
+
+	args = append(args, animatedFormats[format]...)
+
+	return append(args, "-y", dst)
+}
+
+// createAnimatedThumbnail makes a short looping preview of animated uploads
+// the jpeg thumbnail is always made so any failure here just skips the preview
+func (i *ImageType) createAnimatedThumbnail() (err error) {
+
+	if !local.Settings.Uploads.AnimatedThumbnails || i.avatar || !i.isAnimated() {
+		return
+	}
+
+	// a preview would show what the spoiler hides
+	if i.Spoiler {
+		return
+	}
+
+	format := animatedFormat()
+
+	if _, ok := animatedFormats[format]; !ok {
+		return fmt.Errorf("invalid animated thumbnail format %q", format)
+	}
+
+	width, height := fitDimensions(i.OrigWidth, i.OrigHeight, config.Settings.Limits.ThumbnailMaxWidth, config.Settings.Limits.ThumbnailMaxHeight)
+
+	// the encoders want even dimensions
+	width = max(2, width&^1)
+	height = max(2, height&^1)
+
+	thumbDir := local.Settings.Directories.ThumbnailDir
+
+	filename := fmt.Sprintf("%s-%s.%s", strings.TrimSuffix(i.Filename, i.Ext), AnimatedProfile, format)
+
+	store := LocalStorage{Dir: thumbDir}
+
+	_, err = runMedia(ffmpegOpTimeout, "ffmpeg", animatedArgs(i.Filepath, filepath.Join(thumbDir, filename), format, width, height, animatedMaxDuration())...)
+	if err != nil {
+		_ = store.Delete(filename)
+		return nil
+	}
+
+	root, err := os.OpenRoot(thumbDir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer root.Close()
+
+	info, err := root.Stat(filename)
+	if err != nil || info.Size() == 0 || info.Size() > animatedMaxSize() {
+		// too big to be worth it
+		_ = store.Delete(filename)
+		return nil
+	}
+
+	i.Thumbnails = append(i.Thumbnails, ThumbnailFile{
+		Profile:  AnimatedProfile,
+		Filename: filename,
+		Filepath: filepath.Join(thumbDir, filename),
+		Width:    width,
+		Height:   height,
+	})
+
+	return
+}
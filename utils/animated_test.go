@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/config"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+func TestIsAnimated(t *testing.T) {
+	assert.True(t, (&ImageType{video: true, mime: "video/webm"}).isAnimated(), "Videos should be animated")
+	assert.True(t, (&ImageType{mime: "image/gif", frames: 2}).isAnimated(), "Gifs with frames should be animated")
+	assert.False(t, (&ImageType{mime: "image/gif", frames: 1}).isAnimated(), "Single frame gifs should not be animated")
+	assert.False(t, (&ImageType{mime: "image/png"}).isAnimated(), "Pngs should not be animated")
+}
+
+func TestAnimatedArgs(t *testing.T) {
+	args := animatedArgs("/src/test.gif", "/thumb/test-animated.webp", "webp", 200, 100, 3)
+
+	assert.Equal(t, []string{
+		"-hide_banner", "-loglevel", "error",
+		"-t", "3",
+		"-i", "/src/test.gif",
+		"-an",
+		"-vf", "fps=15,scale=200:100",
+		"-c:v", "libwebp", "-quality", "60", "-loop", "0", "-f", "webp",
+		"-y", "/thumb/test-animated.webp",
+	}, args, "Args should match")
+
+	args = animatedArgs("/src/test.webm", "/thumb/test-animated.webm", "webm", 200, 100, 5)
+
+	assert.Contains(t, args, "libvpx-vp9", "Webm previews should use vp9")
+	assert.Contains(t, args, "-an", "Previews should be muted")
+	assert.Equal(t, "/thumb/test-animated.webm", args[len(args)-1], "Output should be last")
+}
+
+func TestCreateAnimatedThumbnailSettings(t *testing.T) {
+	original := local.Settings.Uploads
+	defer func() { local.Settings.Uploads = original }()
+
+	img := ImageType{mime: "image/gif", frames: 10}
+
+	// off by default
+	local.Settings.Uploads.AnimatedThumbnails = false
+	assert.NoError(t, img.createAnimatedThumbnail(), "An error was not expected")
+	assert.Empty(t, img.Thumbnails, "No preview should be made")
+
+	local.Settings.Uploads.AnimatedThumbnails = true
+	local.Settings.Uploads.AnimatedFormat = "mkv"
+	assert.Error(t, img.createAnimatedThumbnail(), "An error was expected")
+
+	// still images are skipped
+	still := ImageType{mime: "image/gif", frames: 1}
+	assert.NoError(t, still.createAnimatedThumbnail(), "An error was not expected")
+	assert.Empty(t, still.Thumbnails, "No preview should be made")
+
+	// spoilers are skipped before anything is encoded
+	for _, spoiler := range []ImageType{
+		{mime: "image/gif", frames: 10, Spoiler: true},
+		{mime: "video/webm", video: true, Spoiler: true},
+	} {
+		assert.NoError(t, spoiler.createAnimatedThumbnail(), "An error was not expected")
+		assert.Empty(t, spoiler.Thumbnails, "No preview should be made")
+	}
+}
+
+func TestCreateAnimatedThumbnailFallback(t *testing.T) {
+	original := local.Settings.Uploads
+	defer func() { local.Settings.Uploads = original }()
+
+	local.Settings.Uploads.AnimatedThumbnails = true
+	local.Settings.Uploads.AnimatedFormat = ""
+
+	config.Settings.Limits.ThumbnailMaxWidth = 200
+	config.Settings.Limits.ThumbnailMaxHeight = 200
+
+	assert.NoError(t, os.MkdirAll(local.Settings.Directories.ThumbnailDir, 0755), "Failed to ensure thumbnail directory exists")
+
+	img := ImageType{
+		mime:       "image/gif",
+		frames:     10,
+		Filename:   "animatedfallback.gif",
+		Filepath:   filepath.Join(local.Settings.Directories.ImageDir, "animatedfallback.gif"),
+		Ext:        ".gif",
+		OrigWidth:  400,
+		OrigHeight: 300,
+	}
+
+	// ffmpeg cant read a missing file so the jpeg thumbnail is all there is
+	assert.NoError(t, img.createAnimatedThumbnail(), "An error was not expected")
+	assert.Empty(t, img.Thumbnails, "No preview should be recorded")
+
+	_, err := os.Stat(filepath.Join(local.Settings.Directories.ThumbnailDir, "animatedfallback-animated.webp"))
+	assert.True(t, os.IsNotExist(err), "Partial preview should be removed")
+}
+
+func TestCreateProfileThumbnailsReserved(t *testing.T) {
+	original := local.Settings.Uploads.Thumbnails
+	defer func() { local.Settings.Uploads.Thumbnails = original }()
+
+	local.Settings.Uploads.Thumbnails = []local.ThumbnailProfile{
+		{Name: AnimatedProfile, MaxWidth: 100, MaxHeight: 100},
+	}
+
+	img := ImageType{Filename: "test.jpg", Ext: ".jpg"}
+
+	assert.Error(t, img.createProfileThumbnails(), "An error was expected")
+}
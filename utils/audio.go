@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// audio limits and waveform settings
+const (
+	defaultAudioMaxDuration = 600
+	defaultAudioMaxBitrate  = 1600000
+	minAudioBitrate         = 8000
+	waveformWidth           = 600
+	waveformHeight          = 240
+	waveformColor           = "0x34345c"
+)
+
+// audioContainer lists the types and codecs allowed for an ffprobe format
+type audioContainer struct {
+	mimes  []string
+	codecs map[string]bool
+	names  string
+}
+
+// allowed audio containers by ffprobe format name
+var audioContainers = map[string]audioContainer{
+	"mp3": {
+		mimes:  []string{"audio/mpeg"},
+		codecs: map[string]bool{"mp3": true},
+		names:  "MP3",
+	},
+	"ogg": {
+		mimes:  []string{"audio/ogg"},
+		codecs: map[string]bool{"vorbis": true, "opus": true},
+		names:  "Vorbis or Opus",
+	},
+	"flac": {
+		mimes:  []string{"audio/flac"},
+		codecs: map[string]bool{"flac": true},
+		names:  "FLAC",
+	},
+}
+
+// audioMaxDuration is the longest audio upload in seconds
+func audioMaxDuration() int {
+	if local.Settings.Uploads.AudioMaxDuration > 0 {
+		return local.Settings.Uploads.AudioMaxDuration
+	}
+	return defaultAudioMaxDuration
+}
+
+// audioMaxBitrate is the highest audio bitrate
+func audioMaxBitrate() int64 {
+	if local.Settings.Uploads.AudioMaxBitrate > 0 {
+		return int64(local.Settings.Uploads.AudioMaxBitrate)
+	}
+	return defaultAudioMaxBitrate
+}
+
+// audioType detects audio files that the http sniffer misses or names differently
+func audioType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return "audio/ogg"
+	case bytes.HasPrefix(data, []byte("ID3")), isMP3Frame(data):
+		return "audio/mpeg"
+	}
+
+	return ""
+}
+
+// isMP3Frame checks for an mpeg layer 3 frame header without an id3 tag
+func isMP3Frame(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+
+	// frame sync
+	if data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return false
+	}
+
+	// layer 3, a valid bitrate, and a valid sample rate
+	return (data[1]>>1)&0x03 == 0x01 && data[2]>>4 != 0x0F && (data[2]>>2)&0x03 != 0x03
+}
+
+// checkAudio probes the audio file and checks its codecs and limits
+func (i *ImageType) checkAudio() (err error) {
+
+	ffprobe, err := i.probe()
+	if err != nil {
+		return
+	}
+
+	return i.validateAudio(ffprobe)
+}
+
+// validateAudio checks the ffprobe output against the container rules and limits
+func (i *ImageType) validateAudio(ffprobe ffprobe) (err error) {
+
+	name := mediaName(i.mime)
+
+	container, ok := audioContainers[ffprobe.Format.FormatName]
+	if !ok || !slices.Contains(container.mimes, i.mime) {
+		return fmt.Errorf("file is not a %s", name)
+	}
+
+	var audioStream, coverStream *ffprobeStream
+
+	for n, stream := range ffprobe.Streams {
+		switch {
+		case stream.CodecType == "audio" && audioStream == nil:
+			audioStream = &ffprobe.Streams[n]
+		case stream.CodecType == "video" && stream.Disposition.AttachedPic == 1 && coverStream == nil:
+			// the embedded cover art shows up as a picture stream
+			coverStream = &ffprobe.Streams[n]
+		default:
+			return fmt.Errorf("%s contains too many streams", name)
+		}
+	}
+
+	if audioStream == nil {
+		return fmt.Errorf("%s contains no audio stream", name)
+	}
+
+	if !container.codecs[strings.ToLower(audioStream.CodecName)] {
+		return fmt.Errorf("audio codec '%s' is not allowed, must be %s", audioStream.CodecName, container.names)
+	}
+
+	duration, err := strconv.ParseFloat(ffprobe.Format.Duration, 64)
+	if err != nil {
+		return fmt.Errorf("problem decoding %s duration", name)
+	}
+
+	if duration <= 0 {
+		return fmt.Errorf("%s has invalid duration", name)
+	}
+
+	i.duration = int(duration)
+
+	if i.duration > audioMaxDuration() {
+		return fmt.Errorf("%s duration %d sec is too long (max: %d sec)", name, i.duration, audioMaxDuration())
+	}
+
+	if ffprobe.Format.BitRate != "" {
+		bitrate, err := strconv.ParseInt(ffprobe.Format.BitRate, 10, 64)
+		if err == nil && bitrate > 0 {
+			if bitrate < minAudioBitrate {
+				return fmt.Errorf("%s bitrate %d bps is too low (min: %d bps)", name, bitrate, minAudioBitrate)
+			}
+			if bitrate > audioMaxBitrate() {
+				return fmt.Errorf("%s bitrate %d bps is too high (max: %d bps)", name, bitrate, audioMaxBitrate())
+			}
+		}
+	}
+
+	if i.size > int64(i.maxSize()) {
+		return fmt.Errorf("%s file size too large. Max: %dMB", name, (i.maxSize()/1024)/1024)
+	}
+
+	// the thumbnail size comes from the cover art or the waveform
+	if coverStream != nil && coverStream.Width > 0 && coverStream.Height > 0 {
+		err = checkPixels(coverStream.Width, coverStream.Height)
+		if err != nil {
+			return
+		}
+
+		i.coverArt = true
+		i.OrigWidth = coverStream.Width
+		i.OrigHeight = coverStream.Height
+	} else {
+		i.coverArt = false
+		i.OrigWidth = waveformWidth
+		i.OrigHeight = waveformHeight
+	}
+
+	return
+}
+
+// coverArgs extracts the embedded cover art as a jpeg
+func coverArgs(src, dst string) []string {
+	return []string{
+		"-v",
+		"quiet",
+		"-i",
+		src,
+		"-an",
+		"-map",
+		"0:v:0",
+		"-frames:v",
+		"1",
+		"-f",
+		"mjpeg",
+		"-y",
+		dst,
+	}
+}
+
+// waveformArgs draws the waveform on a white background as a jpeg
+func waveformArgs(src, dst string) []string {
+	size := fmt.Sprintf("%dx%d", waveformWidth, waveformHeight)
+
+	return []string{
+		"-v",
+		"quiet",
+		"-i",
+		src,
+		"-filter_complex",
+		fmt.Sprintf("[0:a]aformat=channel_layouts=mono,showwavespic=s=%s:colors=%s[wave];color=c=white:s=%s[bg];[bg][wave]overlay=format=auto,format=yuvj420p",
+			size, waveformColor, size),
+		"-frames:v",
+		"1",
+		"-f",
+		"mjpeg",
+		"-y",
+		dst,
+	}
+}
+
+// createAudioThumbnail writes the cover art or a waveform where the thumbnail will be made from
+func (i *ImageType) createAudioThumbnail() (err error) {
+
+	if i.coverArt {
+		_, err = runMedia(ffmpegOpTimeout, "ffmpeg", coverArgs(i.Filepath, i.Thumbpath)...)
+		if err == nil || errors.Is(err, ErrQueueFull) {
+			return
+		}
+
+		// unreadable cover art gets a waveform instead
+		i.coverArt = false
+		i.OrigWidth = waveformWidth
+		i.OrigHeight = waveformHeight
+	}
+
+	_, err = runMedia(ffmpegOpTimeout, "ffmpeg", waveformArgs(i.Filepath, i.Thumbpath)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			return
+		case errors.Is(err, errMediaTimeout):
+			return fmt.Errorf("ffmpeg operation timed out after %v", ffmpegOpTimeout)
+		}
+		return fmt.Errorf("problem creating thumbnail from %s", mediaName(i.mime))
+	}
+
+	return
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/eirka/eirka-libs/config"
+	"github.com/stretchr/testify/assert"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// mockAudioProbe is an mp3 with cover art
+func mockAudioProbe() ffprobe {
+	probe := mockGoodFFProbeData()
+
+	probe.Streams = []ffprobeStream{
+		{
+			Index:     0,
+			CodecName: "mp3",
+			CodecType: "audio",
+		},
+		{
+			Index:     1,
+			CodecName: "mjpeg",
+			CodecType: "video",
+			Width:     500,
+			Height:    500,
+		},
+	}
+
+	probe.Streams[1].Disposition.AttachedPic = 1
+
+	probe.Format.Filename = "test.mp3"
+	probe.Format.FormatName = "mp3"
+	probe.Format.Duration = "185.2"
+	probe.Format.BitRate = "320000"
+
+	return probe
+}
+
+func TestAudioType(t *testing.T) {
+	assert.Equal(t, "audio/flac", audioType([]byte("fLaC\x00\x00\x00\x22")), "Flac should be detected")
+	assert.Equal(t, "audio/ogg", audioType([]byte("OggS\x00\x02\x00\x00")), "Ogg should be detected")
+	assert.Equal(t, "audio/mpeg", audioType([]byte("ID3\x04\x00\x00\x00\x00")), "Tagged mp3 should be detected")
+	assert.Equal(t, "audio/mpeg", audioType([]byte{0xFF, 0xFB, 0x90, 0x64}), "Untagged mp3 should be detected")
+	assert.Equal(t, "", audioType([]byte{0xFF, 0xD8, 0xFF, 0xE0}), "Jpeg should not be audio")
+	assert.Equal(t, "", audioType([]byte{0xFF, 0xFB, 0xF0, 0x64}), "Bad bitrate should not be audio")
+	assert.Equal(t, "", audioType([]byte("RIFF")), "Wav should not be detected")
+}
+
+func TestDetectContentTypeAudio(t *testing.T) {
+	assert.Equal(t, "audio/ogg", detectContentType([]byte("OggS\x00\x02\x00\x00\x00\x00")), "Ogg should be audio")
+	assert.Equal(t, "audio/flac", detectContentType([]byte("fLaC\x00\x00\x00\x22")), "Flac should be audio")
+}
+
+func TestCheckMagicAudio(t *testing.T) {
+	for _, test := range []struct {
+		ext  string
+		data string
+	}{
+		{".mp3", "ID3\x04\x00\x00\x00\x00"},
+		{".ogg", "OggS\x00\x02\x00\x00"},
+		{".opus", "OggS\x00\x02\x00\x00"},
+		{".flac", "fLaC\x00\x00\x00\x22"},
+	} {
+		img := ImageType{Ext: test.ext, size: 4096, policy: &UploadPolicy{Audio: true}}
+		img.header = []byte(test.data)
+
+		err := img.checkMagic()
+		if assert.NoError(t, err, "An error was not expected for %s", test.ext) {
+			assert.True(t, img.audio, "File should be audio")
+			assert.False(t, img.video, "File should not be video")
+			assert.True(t, img.needsFrame(), "Audio should be thumbnailed from a frame")
+		}
+	}
+
+	img := ImageType{Ext: ".flac", size: 4096, policy: &UploadPolicy{Audio: true}}
+	img.header = []byte("ID3\x04\x00\x00\x00\x00")
+	assert.Error(t, img.checkMagic(), "An error was expected")
+}
+
+func TestAudioValidation(t *testing.T) {
+	config.Settings.Limits.ImageMaxSize = 10000000
+
+	original := local.Settings.Uploads
+	defer func() { local.Settings.Uploads = original }()
+
+	local.Settings.Uploads.AudioMaxDuration = 300
+
+	tests := []struct {
+		name           string
+		mime           string
+		modifyFFProbe  func(ffprobe) ffprobe
+		expectedErrStr string
+	}{
+		{
+			name:          "Valid mp3",
+			mime:          "audio/mpeg",
+			modifyFFProbe: func(f ffprobe) ffprobe { return f },
+		},
+		{
+			name: "Valid ogg",
+			mime: "audio/ogg",
+			modifyFFProbe: func(f ffprobe) ffprobe {
+				f.Format.FormatName = "ogg"
+				f.Streams[0].CodecName = "opus"
+				return f
+			},
+		},
+		{
+			name: "Wrong container",
+			mime: "audio/flac",
+			modifyFFProbe: func(f ffprobe) ffprobe {
+				return f
+			},
+			expectedErrStr: "file is not a flac",
+		},
+		{
+			name: "Wrong codec",
+			mime: "audio/ogg",
+			modifyFFProbe: func(f ffprobe) ffprobe {
+				f.Format.FormatName = "ogg"
+				f.Streams[0].CodecName = "speex"
+				return f
+			},
+			expectedErrStr: "audio codec 'speex' is not allowed, must be Vorbis or Opus",
+		},
+		{
+			name: "No audio",
+			mime: "audio/mpeg",
+			modifyFFProbe: func(f ffprobe) ffprobe {
+				f.Streams = f.Streams[1:]
+				return f
+			},
+			expectedErrStr: "mp3 contains no audio stream",
+		},
+		{
+			name: "Real video stream",
+			mime: "audio/mpeg",
+			modifyFFProbe: func(f ffprobe) ffprobe {
+				f.Streams[1].Disposition.AttachedPic = 0
+				return f
+			},
+			expectedErrStr: "mp3 contains too many streams",
+		},
+		{
+			name: "Too long",
+			mime: "audio/mpeg",
+			modifyFFProbe: func(f ffprobe) ffprobe {
+				f.Format.Duration = "301"
+				return f
+			},
+			expectedErrStr: "mp3 duration 301 sec is too long (max: 300 sec)",
+		},
+		{
+			name: "Bitrate too high",
+			mime: "audio/mpeg",
+			modifyFFProbe: func(f ffprobe) ffprobe {
+				f.Format.BitRate = "5000000"
+				return f
+			},
+			expectedErrStr: "mp3 bitrate 5000000 bps is too high (max: 1600000 bps)",
+		},
+		{
+			name: "Bitrate too low",
+			mime: "audio/mpeg",
+			modifyFFProbe: func(f ffprobe) ffprobe {
+				f.Format.BitRate = "4000"
+				return f
+			},
+			expectedErrStr: "mp3 bitrate 4000 bps is too low (min: 8000 bps)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			img := ImageType{mime: tc.mime, audio: true}
+
+			err := img.validateAudio(tc.modifyFFProbe(mockAudioProbe()))
+			if tc.expectedErrStr == "" {
+				assert.NoError(t, err, "An error was not expected")
+			} else {
+				assert.EqualError(t, err, tc.expectedErrStr, "Error should match")
+			}
+		})
+	}
+}
+
+func TestAudioValidationThumbnailSize(t *testing.T) {
+	config.Settings.Limits.ImageMaxSize = 10000000
+
+	img := ImageType{mime: "audio/mpeg", audio: true}
+
+	err := img.validateAudio(mockAudioProbe())
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.True(t, img.coverArt, "Cover art should be used")
+		assert.Equal(t, 500, img.OrigWidth, "Width should match the cover")
+		assert.Equal(t, 500, img.OrigHeight, "Height should match the cover")
+		assert.Equal(t, 185, img.duration, "Duration should match")
+	}
+
+	probe := mockAudioProbe()
+	probe.Streams = probe.Streams[:1]
+
+	bare := ImageType{mime: "audio/mpeg", audio: true}
+
+	err = bare.validateAudio(probe)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.False(t, bare.coverArt, "A waveform should be used")
+		assert.Equal(t, waveformWidth, bare.OrigWidth, "Width should match the waveform")
+		assert.Equal(t, waveformHeight, bare.OrigHeight, "Height should match the waveform")
+	}
+}
+
+func TestAudioThumbnailArgs(t *testing.T) {
+	cover := coverArgs("/src/test.mp3", "/thumb/tests.jpg")
+	assert.Equal(t, []string{"-v", "quiet", "-i", "/src/test.mp3", "-an", "-map", "0:v:0", "-frames:v", "1", "-f", "mjpeg", "-y", "/thumb/tests.jpg"}, cover, "Args should match")
+
+	wave := waveformArgs("/src/test.mp3", "/thumb/tests.jpg")
+	assert.Contains(t, wave[5], "showwavespic=s=600x240", "Waveform size should match")
+	assert.Equal(t, "/thumb/tests.jpg", wave[len(wave)-1], "Output should be last")
+}
+
+func TestCreateAudioThumbnailError(t *testing.T) {
+	img := ImageType{
+		mime:      "audio/mpeg",
+		audio:     true,
+		coverArt:  true,
+		Filepath:  "/tmp/eirka/src/missing.mp3",
+		Thumbpath: "/tmp/eirka/thumb/missings.jpg",
+	}
+
+	err := img.createAudioThumbnail()
+	assert.EqualError(t, err, "problem creating thumbnail from mp3", "Error should match")
+	assert.False(t, img.coverArt, "Waveform should be tried after the cover art")
+	assert.Equal(t, waveformWidth, img.OrigWidth, "Width should match the waveform")
+}
@@ -3,7 +3,6 @@ package utils
 import (
 	"bytes"
 	"errors"
-	"net/http"
 
 	"github.com/1l0/identicon"
 )
@@ -29,21 +28,15 @@ func (i *ImageType) SaveAvatar() (err error) {
 		return
 	}
 
-	// copy the multipart file into a buffer
-	err = i.copyBytes()
-	if err != nil {
-		return
-	}
-
-	// get file hash
-	err = i.getHash()
+	// stream the multipart file to a temp file and hash it
+	err = i.copyFile()
 	if err != nil {
 		return
 	}
 
 	// For test compatibility, we need to set mime type and extension before checkMagic
 	// because our new validation checks extension/mime type consistency
-	i.mime = http.DetectContentType(i.image.Bytes())
+	i.mime = detectContentType(i.header)
 
 	// check file magic sig - with more advanced validation
 	err = i.checkMagic()
@@ -51,12 +44,24 @@ func (i *ImageType) SaveAvatar() (err error) {
 		return
 	}
 
-	// videos cant be avatars
-	if i.video {
+	// videos and avifs cant be avatars
+	if i.needsFrame() {
 		err = errors.New("format not supported")
 		return
 	}
 
+	// reject decompression bombs before anything decodes the pixels
+	err = i.checkLimits()
+	if err != nil {
+		return
+	}
+
+	// strip metadata and apply the orientation
+	err = i.sanitize()
+	if err != nil {
+		return
+	}
+
 	// check image stats
 	err = i.getStats()
 	if err != nil {
@@ -75,6 +80,12 @@ func (i *ImageType) SaveAvatar() (err error) {
 		return
 	}
 
+	// move the avatar to storage
+	err = i.publishFiles()
+	if err != nil {
+		return
+	}
+
 	// Mark as successful to prevent cleanup
 	success = true
 	return
@@ -91,10 +102,7 @@ func GenerateAvatar(uid uint) (err error) {
 		OrigWidth:  420,
 		OrigHeight: 420,
 		Ext:        ".png",
-		image:      new(bytes.Buffer),
 		Ib:         uid,
-		MD5:        "fake",
-		SHA:        "fake",
 		mime:       "image/png",
 	}
 
@@ -112,8 +120,15 @@ func GenerateAvatar(uid uint) (err error) {
 	id := identicon.New()
 	// a colorful theme
 	id.Theme = identicon.Free
-	// put the output into our image buffer
-	err = id.GeneratePNG(img.image)
+	// put the output into a buffer, identicons are small
+	var buf bytes.Buffer
+	err = id.GeneratePNG(&buf)
+	if err != nil {
+		return
+	}
+
+	// write it out like an upload
+	err = img.writeTemp(&buf)
 	if err != nil {
 		return
 	}
@@ -130,6 +145,12 @@ func GenerateAvatar(uid uint) (err error) {
 		return
 	}
 
+	// move the avatar to storage
+	err = img.publishFiles()
+	if err != nil {
+		return
+	}
+
 	// Mark as successful to prevent cleanup
 	success = true
 	return
@@ -206,7 +206,7 @@ func TestAvatarImageTypes(t *testing.T) {
 	}
 
 	invalidExtensions := []string{
-		".pdf", ".mp4", ".txt", ".php", ".html",
+		".pdf", ".bmp", ".txt", ".php", ".html",
 	}
 
 	// Test that valid extensions are accepted
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ftyp brands for avif stills and sequences
+var avifBrands = map[string]bool{
+	"avif": true,
+	"avis": true,
+}
+
+// isoBox is a single box from an iso base media file
+type isoBox struct {
+	kind string
+	data []byte
+}
+
+// isoBoxes splits data into its boxes
+func isoBoxes(data []byte) (boxes []isoBox, err error) {
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, errors.New("problem reading avif boxes")
+		}
+
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		kind := string(data[pos+4 : pos+8])
+		header := 8
+
+		switch size {
+		case 0:
+			// the box runs to the end of the file
+			size = len(data) - pos
+		case 1:
+			// 64 bit size
+			if pos+16 > len(data) {
+				return nil, errors.New("problem reading avif boxes")
+			}
+			large := binary.BigEndian.Uint64(data[pos+8 : pos+16])
+			if large > uint64(len(data)-pos) {
+				return nil, errors.New("problem reading avif boxes")
+			}
+			size = int(large)
+			header = 16
+		}
+
+		if size < header || pos+size > len(data) {
+			return nil, errors.New("problem reading avif boxes")
+		}
+
+		boxes = append(boxes, isoBox{kind: kind, data: data[pos+header : pos+size]})
+
+		pos += size
+	}
+
+	return
+}
+
+// findBox returns the first box of a kind
+func findBox(boxes []isoBox, kind string) (isoBox, bool) {
+	for _, box := range boxes {
+		if box.kind == kind {
+			return box, true
+		}
+	}
+
+	return isoBox{}, false
+}
+
+// isAVIF checks the ftyp box for an avif brand
+func isAVIF(data []byte) bool {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	if size < 16 || size > len(data) {
+		return false
+	}
+
+	// major brand
+	if avifBrands[string(data[8:12])] {
+		return true
+	}
+
+	// compatible brands follow the minor version
+	for pos := 16; pos+4 <= size; pos += 4 {
+		if avifBrands[string(data[pos:pos+4])] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// avifDimensions reads the image size from the item properties
+func avifDimensions(data []byte) (width, height int, err error) {
+	boxes, err := isoBoxes(data)
+	if err != nil {
+		return
+	}
+
+	meta, ok := findBox(boxes, "meta")
+	// meta is a full box with a version and flags
+	if !ok || len(meta.data) < 4 {
+		return 0, 0, errors.New("avif has no metadata")
+	}
+
+	children, err := isoBoxes(meta.data[4:])
+	if err != nil {
+		return
+	}
+
+	iprp, ok := findBox(children, "iprp")
+	if !ok {
+		return 0, 0, errors.New("avif has no item properties")
+	}
+
+	children, err = isoBoxes(iprp.data)
+	if err != nil {
+		return
+	}
+
+	ipco, ok := findBox(children, "ipco")
+	if !ok {
+		return 0, 0, errors.New("avif has no item properties")
+	}
+
+	properties, err := isoBoxes(ipco.data)
+	if err != nil {
+		return
+	}
+
+	var rotated bool
+
+	for _, property := range properties {
+		switch property.kind {
+		case "ispe":
+			if len(property.data) < 12 {
+				continue
+			}
+			w := uint64(binary.BigEndian.Uint32(property.data[4:8]))
+			h := uint64(binary.BigEndian.Uint32(property.data[8:12]))
+			// grids and alpha planes have their own sizes, the largest is the image
+			// compared as uint64 since two uint32 sizes cant overflow it
+			if w*h > uint64(width)*uint64(height) {
+				width, height = int(w), int(h)
+			}
+		case "irot":
+			if len(property.data) >= 1 && property.data[0]&0x01 != 0 {
+				rotated = true
+			}
+		}
+	}
+
+	if width <= 0 || height <= 0 {
+		return 0, 0, errors.New("avif has invalid dimensions")
+	}
+
+	// quarter turns swap the displayed size
+	if rotated {
+		width, height = height, width
+	}
+
+	return
+}
+
+// createAVIFFrame decodes the avif with ffmpeg into the thumbnail path
+func (i *ImageType) createAVIFFrame() (err error) {
+
+	ffmpegArgs := []string{
+		"-i",
+		i.Filepath,
+		"-v",
+		"quiet",
+		"-an",
+		"-vframes",
+		"1",
+		"-f",
+		"mjpeg",
+		i.Thumbpath,
+	}
+
+	_, err = runMedia(ffmpegOpTimeout, "ffmpeg", ffmpegArgs...)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			return
+		case errors.Is(err, errMediaTimeout):
+			return fmt.Errorf("ffmpeg operation timed out after %v", ffmpegOpTimeout)
+		}
+		return errors.New("problem decoding avif")
+	}
+
+	// make sure ffmpeg actually wrote the frame
+	frame, err := os.OpenInRoot(filepath.Dir(i.Thumbpath), filepath.Base(i.Thumbpath))
+	if err != nil {
+		return fmt.Errorf("failed to verify thumbnail creation: %v", err)
+	}
+	frame.Close()
+
+	return
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/config"
+)
+
+// testBox builds an iso box
+func testBox(kind string, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(len(body)+8))
+	box = append(box, kind...)
+	return append(box, body...)
+}
+
+// testISPE builds an image spatial extents property
+func testISPE(width, height uint32) []byte {
+	data := []byte{0, 0, 0, 0}
+	data = binary.BigEndian.AppendUint32(data, width)
+	data = binary.BigEndian.AppendUint32(data, height)
+	return testBox("ispe", data)
+}
+
+// testAVIF builds the boxes of an avif without any image data
+func testAVIF(brand string, properties ...[]byte) []byte {
+	ftyp := testBox("ftyp", []byte(brand), []byte{0, 0, 0, 0}, []byte("mif1miaf"))
+	ipco := testBox("ipco", properties...)
+	meta := testBox("meta", []byte{0, 0, 0, 0}, testBox("hdlr", make([]byte, 24)), testBox("iprp", ipco))
+	return append(ftyp, meta...)
+}
+
+func TestIsAVIF(t *testing.T) {
+	assert.True(t, isAVIF(testAVIF("avif")), "Major brand should match")
+	assert.True(t, isAVIF(testAVIF("avis")), "Sequences should match")
+	assert.False(t, isAVIF(testAVIF("heic")), "Other brands should not match")
+	assert.False(t, isAVIF(testPng(10).Bytes()), "Png should not match")
+
+	// compatible brands count too
+	ftyp := testBox("ftyp", []byte("mif1"), []byte{0, 0, 0, 0}, []byte("miafavif"))
+	assert.True(t, isAVIF(ftyp), "Compatible brand should match")
+
+	assert.Equal(t, "image/avif", detectContentType(testAVIF("avif")), "Mime should match")
+}
+
+func TestAVIFDimensions(t *testing.T) {
+	width, height, err := avifDimensions(testAVIF("avif", testISPE(320, 240), testISPE(640, 480)))
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, 640, width, "Width should match the largest item")
+		assert.Equal(t, 480, height, "Height should match the largest item")
+	}
+
+	// huge sizes cant overflow the comparison and hide behind a small item
+	width, height, err = avifDimensions(testAVIF("avif", testISPE(640, 480), testISPE(0xFFFFFFFF, 0xFFFFFFFF)))
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, 0xFFFFFFFF, width, "Width should match the largest item")
+		assert.Equal(t, 0xFFFFFFFF, height, "Height should match the largest item")
+	}
+
+	// a quarter turn swaps the size
+	width, height, err = avifDimensions(testAVIF("avif", testISPE(640, 480), testBox("irot", []byte{1})))
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, 480, width, "Width should be swapped")
+		assert.Equal(t, 640, height, "Height should be swapped")
+	}
+
+	_, _, err = avifDimensions(testAVIF("avif"))
+	assert.Error(t, err, "An error was expected")
+
+	_, _, err = avifDimensions(testBox("ftyp", []byte("avif")))
+	assert.Error(t, err, "An error was expected")
+
+	// truncated boxes
+	bad := testAVIF("avif", testISPE(640, 480))
+	_, _, err = avifDimensions(bad[:len(bad)-4])
+	assert.Error(t, err, "An error was expected")
+}
+
+func TestGetStatsAVIF(t *testing.T) {
+	config.Settings.Limits.ImageMaxWidth = 1000
+	config.Settings.Limits.ImageMinWidth = 100
+	config.Settings.Limits.ImageMaxHeight = 1000
+	config.Settings.Limits.ImageMinHeight = 100
+	config.Settings.Limits.ImageMaxSize = 300000
+
+	img := ImageType{
+		mime: "image/avif",
+	}
+
+	testUpload(t, &img, testAVIF("avif", testISPE(640, 480)))
+
+	err := img.getStats()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, 640, img.OrigWidth, "Width should match")
+		assert.Equal(t, 480, img.OrigHeight, "Height should match")
+	}
+
+	assert.True(t, img.needsFrame(), "Avif should use an extracted frame")
+}
+
+func TestCheckMagicAVIF(t *testing.T) {
+	// pad past the minimum size check
+	data := testAVIF("avif", testISPE(640, 480), testBox("free", make([]byte, 100)))
+
+	img := ImageType{
+		Ext: ".avif",
+	}
+
+	testUpload(t, &img, data)
+
+	err := img.checkMagic()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, "image/avif", img.mime, "Mime should match")
+	}
+
+	detected := ImageType{}
+
+	testUpload(t, &detected, data)
+
+	err = detected.checkMagic()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, ".avif", detected.Ext, "Ext should be set from the content")
+	}
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"errors"
+	"io"
+
+	"github.com/eirka/eirka-libs/db"
+)
+
+// BackfillStats counts what happened to the images without a sha256
+type BackfillStats struct {
+	// Updated images have their sha256 stored
+	Updated int
+	// Missing images have no file in storage
+	Missing int
+	// Mismatched files do not have the stored md5 and are left alone
+	Mismatched int
+	// Bans are banned files that got the sha256 of a posted image
+	Bans int64
+}
+
+// BackfillSHA256 hashes the files of images stored before sha256 was
+// the files are read from the store and the stored md5 is checked so a replaced file is not trusted
+func BackfillSHA256(store Storage, batch int) (stats BackfillStats, err error) {
+
+	if batch < 1 {
+		return stats, errors.New("batch size must be positive")
+	}
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	var lastID uint
+
+	for {
+		var count int
+
+		count, lastID, err = backfillBatch(store, lastID, batch, &stats)
+		if err != nil {
+			return
+		}
+
+		if count < batch {
+			break
+		}
+	}
+
+	// banned files only have a md5 so take the sha256 from the image it was posted as
+	result, err := dbase.Exec(`UPDATE banned_files INNER JOIN images ON banned_files.ban_hash = images.image_hash
+	SET banned_files.ban_sha256 = images.image_sha256
+	WHERE banned_files.ban_sha256 IS NULL AND images.image_sha256 IS NOT NULL`)
+	if err != nil {
+		return
+	}
+
+	stats.Bans, err = result.RowsAffected()
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// backfillBatch hashes the next batch of images after lastID
+func backfillBatch(store Storage, lastID uint, batch int, stats *BackfillStats) (count int, next uint, err error) {
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	type backfillImage struct {
+		id   uint
+		file string
+		md5  string
+	}
+
+	rows, err := dbase.Query(`SELECT image_id,image_file,image_hash FROM images
+	WHERE image_sha256 IS NULL AND image_id > ? ORDER BY image_id LIMIT ?`, lastID, batch)
+	if err != nil {
+		return
+	}
+
+	var images []backfillImage
+
+	for rows.Next() {
+		var image backfillImage
+
+		err = rows.Scan(&image.id, &image.file, &image.md5)
+		if err != nil {
+			rows.Close()
+			return
+		}
+
+		images = append(images, image)
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return
+	}
+
+	next = lastID
+
+	for _, image := range images {
+		next = image.id
+
+		hashed := ImageType{}
+
+		file, openErr := store.Get(image.file)
+		if openErr != nil {
+			stats.Missing++
+			continue
+		}
+
+		hasher := newFileHasher()
+
+		_, copyErr := io.Copy(hasher.writer(), file)
+		file.Close()
+		if copyErr != nil {
+			stats.Missing++
+			continue
+		}
+
+		hasher.set(&hashed)
+
+		if hashed.MD5 != image.md5 {
+			stats.Mismatched++
+			continue
+		}
+
+		_, err = dbase.Exec(`UPDATE images SET image_sha256 = ? WHERE image_id = ?`, hashed.SHA256, image.id)
+		if err != nil {
+			return
+		}
+
+		stats.Updated++
+	}
+
+	return len(images), next, nil
+}
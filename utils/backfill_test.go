@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/db"
+)
+
+func TestBackfillSHA256(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	dir := t.TempDir()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "good.jpg"), []byte(""), 0644), "An error was not expected")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "changed.jpg"), []byte("changed"), 0644), "An error was not expected")
+
+	first := sqlmock.NewRows([]string{"image_id", "image_file", "image_hash"}).
+		AddRow(1, "good.jpg", testMD5).
+		AddRow(2, "missing.jpg", testMD5)
+
+	mock.ExpectQuery(`SELECT image_id,image_file,image_hash FROM images`).
+		WithArgs(0, 2).
+		WillReturnRows(first)
+
+	mock.ExpectExec(`UPDATE images SET image_sha256 = \? WHERE image_id = \?`).
+		WithArgs(testSHA256, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	second := sqlmock.NewRows([]string{"image_id", "image_file", "image_hash"}).
+		AddRow(3, "changed.jpg", testMD5)
+
+	mock.ExpectQuery(`SELECT image_id,image_file,image_hash FROM images`).
+		WithArgs(2, 2).
+		WillReturnRows(second)
+
+	mock.ExpectExec(`UPDATE banned_files INNER JOIN images`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	stats, err := BackfillSHA256(&LocalStorage{Dir: dir}, 2)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, BackfillStats{Updated: 1, Missing: 1, Mismatched: 1, Bans: 3}, stats, "Stats should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestBackfillSHA256Error(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectQuery(`SELECT image_id,image_file,image_hash FROM images`).
+		WillReturnError(errors.New("SQL error"))
+
+	_, err = BackfillSHA256(&LocalStorage{Dir: t.TempDir()}, 10)
+	assert.Error(t, err, "An error was expected")
+
+	_, err = BackfillSHA256(&LocalStorage{Dir: t.TempDir()}, 0)
+	assert.Error(t, err, "An error was expected")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestBackfillSHA256S3(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	fake, server := newFakeS3()
+	defer server.Close()
+
+	store := &S3Storage{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    "eirka",
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		Prefix:    "src/",
+	}
+
+	// the file is only in the bucket
+	assert.NoError(t, store.Put("good.jpg", "image/jpeg", bytes.NewReader([]byte("")), 0), "An error was not expected")
+	assert.True(t, fake.has("/eirka/src/good.jpg"), "Object should be stored")
+
+	rows := sqlmock.NewRows([]string{"image_id", "image_file", "image_hash"}).
+		AddRow(1, "good.jpg", testMD5).
+		AddRow(2, "missing.jpg", testMD5)
+
+	mock.ExpectQuery(`SELECT image_id,image_file,image_hash FROM images`).
+		WithArgs(0, 10).
+		WillReturnRows(rows)
+
+	mock.ExpectExec(`UPDATE images SET image_sha256 = \? WHERE image_id = \?`).
+		WithArgs(testSHA256, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectExec(`UPDATE banned_files INNER JOIN images`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	stats, err := BackfillSHA256(store, 10)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, BackfillStats{Updated: 1, Missing: 1}, stats, "Stats should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
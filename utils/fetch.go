@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// limits for uploads fetched from a url
+const (
+	fetchTimeout       = 30 * time.Second
+	fetchDialTimeout   = 5 * time.Second
+	fetchHeaderTimeout = 10 * time.Second
+	fetchMaxRedirects  = 3
+	fetchMaxURLLength  = 2048
+)
+
+// ErrBlockedAddress is returned when a url resolves to a private or reserved address
+var ErrBlockedAddress = errors.New("file url is not allowed")
+
+// reserved ranges that are not caught by the netip helpers
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	// nat64 and ipv4 compatible addresses embed an ipv4 address too
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("::/96"),
+	// 6to4 and teredo embed an ipv4 address that could be internal
+	netip.MustParsePrefix("2002::/16"),
+	netip.MustParsePrefix("2001::/32"),
+}
+
+// fetchClient downloads remote uploads and refuses to connect to internal addresses
+var fetchClient = newFetchClient(checkPublicAddr, fetchTimeout)
+
+// checkPublicAddr only allows globally routable addresses
+func checkPublicAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return ErrBlockedAddress
+	}
+
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return ErrBlockedAddress
+		}
+	}
+
+	return nil
+}
+
+// newFetchClient builds a client that checks every address it connects to
+// checking at connect time covers redirects and dns rebinding
+func newFetchClient(check func(netip.Addr) error, timeout time.Duration) *http.Client {
+
+	dialer := &net.Dialer{
+		Timeout: fetchDialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return ErrBlockedAddress
+			}
+
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return ErrBlockedAddress
+			}
+
+			return check(addr)
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// a proxy would make the connection for us and skip the check
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   fetchDialTimeout,
+			ResponseHeaderTimeout: fetchHeaderTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+			DisableCompression:    true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= fetchMaxRedirects {
+				return errors.New("too many redirects")
+			}
+
+			return checkFetchURL(req.URL)
+		},
+	}
+}
+
+// checkFetchURL only allows plain http and https urls
+func checkFetchURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("file url must be http or https")
+	}
+
+	if u.Hostname() == "" || u.User != nil {
+		return errors.New("invalid file url")
+	}
+
+	return nil
+}
+
+// openURL requests the file url and sets the header the pipeline expects from an upload
+// the body is streamed to the temp file by copyFile
+func (i *ImageType) openURL() (err error) {
+
+	if len(i.URL) > fetchMaxURLLength {
+		return errors.New("file url too long")
+	}
+
+	u, err := url.Parse(strings.TrimSpace(i.URL))
+	if err != nil {
+		return errors.New("invalid file url")
+	}
+
+	err = checkFetchURL(u)
+	if err != nil {
+		return
+	}
+
+	ctx := i.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// the download stops when the post request goes away
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return errors.New("invalid file url")
+	}
+
+	req.Header.Set("User-Agent", "eirka-post")
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlockedAddress) {
+			return ErrBlockedAddress
+		}
+		return errors.New("problem fetching file url")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return fmt.Errorf("file url returned status %d", resp.StatusCode)
+	}
+
+	// fail early when the server tells us the size
+	maxSize := int64(i.maxSize())
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		resp.Body.Close()
+		return fmt.Errorf("image filesize too large. Max: %dMB", (maxSize/1024)/1024)
+	}
+
+	i.remote = resp.Body
+	i.Header = &multipart.FileHeader{
+		Filename: fetchName(resp.Request.URL, resp.Header.Get("Content-Type")),
+		Size:     resp.ContentLength,
+	}
+
+	return
+}
+
+// fetchName picks a filename from the final url or the content type
+func fetchName(u *url.URL, contentType string) string {
+	name := path.Base(u.Path)
+
+	if isAllowedExt(path.Ext(name)) {
+		return name
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	if ext, ok := mimeExt[mediaType]; ok {
+		return "remote" + ext
+	}
+
+	// checkReqExt will reject it
+	return name
+}
+
+// closeRemote closes a url download that was never copied
+func (i *ImageType) closeRemote() {
+	if i.remote != nil {
+		i.remote.Close()
+		i.remote = nil
+	}
+}
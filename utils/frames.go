@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+	"strconv"
+)
+
+// video frame sampling settings
+const (
+	frameSamples     = 5   // frames spread across the clip
+	frameMinMean     = 20  // average luma below this is a black frame
+	frameMaxMean     = 240 // average luma above this is a white frame
+	frameMinDeviance = 12  // luma deviation below this is a flat frame
+	frameMaxPixels   = 65536
+)
+
+// frameTimepoints spreads the samples across the clip, skipping the very start and end
+func frameTimepoints(duration int) []string {
+	if duration < 1 {
+		return []string{"0"}
+	}
+
+	var points []string
+
+	for n := 1; n <= frameSamples; n++ {
+		seconds := float64(duration) * float64(n) / float64(frameSamples+1)
+		points = append(points, strconv.FormatFloat(seconds, 'f', 2, 64))
+	}
+
+	return points
+}
+
+// frameArgs grabs one jpeg frame at the timepoint and writes it to stdout
+func frameArgs(src, timepoint string) []string {
+	return []string{
+		"-v",
+		"quiet",
+		"-ss",
+		timepoint,
+		"-i",
+		src,
+		"-an",
+		"-frames:v",
+		"1",
+		"-f",
+		"mjpeg",
+		"pipe:1",
+	}
+}
+
+// frameScore rates how much a frame shows with the entropy of its luma histogram
+// black, white, and flat frames are rejected
+func frameScore(img image.Image) (score float64, ok bool) {
+	bounds := img.Bounds()
+
+	// sample a grid of pixels so big frames are cheap
+	step := max(1, int(math.Sqrt(float64(bounds.Dx()*bounds.Dy())/frameMaxPixels)))
+
+	var histogram [256]int
+	var count int
+	var sum, squares float64
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			r, g, b, _ := img.At(x, y).RGBA()
+
+			luma := (299*r + 587*g + 114*b) / 1000 >> 8
+
+			histogram[luma]++
+			count++
+			sum += float64(luma)
+			squares += float64(luma * luma)
+		}
+	}
+
+	if count == 0 {
+		return 0, false
+	}
+
+	mean := sum / float64(count)
+	deviance := math.Sqrt(max(0, squares/float64(count)-mean*mean))
+
+	if mean < frameMinMean || mean > frameMaxMean || deviance < frameMinDeviance {
+		return 0, false
+	}
+
+	for _, n := range histogram {
+		if n > 0 {
+			p := float64(n) / float64(count)
+			score -= p * math.Log2(p)
+		}
+	}
+
+	return score, true
+}
+
+// pickVideoFrame samples frames across the video and returns the best one as a jpeg
+// nil means no usable frame was found and the caller should fall back
+func (i *ImageType) pickVideoFrame() []byte {
+
+	var best []byte
+	var bestScore float64
+
+	for _, timepoint := range frameTimepoints(i.duration) {
+		frame, err := runMedia(ffmpegOpTimeout, "ffmpeg", frameArgs(i.Filepath, timepoint)...)
+		if err != nil || len(frame) == 0 {
+			continue
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(frame))
+		if err != nil {
+			continue
+		}
+
+		score, ok := frameScore(img)
+
+		// earlier frames win ties so the choice is deterministic
+		if ok && score > bestScore {
+			best = frame
+			bestScore = score
+		}
+	}
+
+	return best
+}
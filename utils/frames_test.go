@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// testFill makes a single color image
+func testFill(c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+
+	for x := range 64 {
+		for y := range 64 {
+			img.Set(x, y, c)
+		}
+	}
+
+	return img
+}
+
+func TestFrameTimepoints(t *testing.T) {
+	assert.Equal(t, []string{"0"}, frameTimepoints(0), "Short clips should use the start")
+	assert.Equal(t, []string{"1.00", "2.00", "3.00", "4.00", "5.00"}, frameTimepoints(6), "Timepoints should be spread out")
+	assert.Len(t, frameTimepoints(120), frameSamples, "Sample count should match")
+}
+
+func TestFrameArgs(t *testing.T) {
+	args := frameArgs("/src/test.webm", "2.50")
+
+	assert.Equal(t, []string{"-v", "quiet", "-ss", "2.50", "-i", "/src/test.webm", "-an", "-frames:v", "1", "-f", "mjpeg", "pipe:1"}, args, "Args should match")
+}
+
+func TestFrameScore(t *testing.T) {
+	_, ok := frameScore(testFill(color.Black))
+	assert.False(t, ok, "Black frames should be rejected")
+
+	_, ok = frameScore(testFill(color.White))
+	assert.False(t, ok, "White frames should be rejected")
+
+	_, ok = frameScore(testFill(color.RGBA{120, 120, 120, 255}))
+	assert.False(t, ok, "Flat frames should be rejected")
+
+	halves, ok := frameScore(testHalves(64, 64))
+	assert.True(t, ok, "Two color frames should be accepted")
+
+	noise := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	rng := rand.New(rand.NewSource(1))
+	for x := range 64 {
+		for y := range 64 {
+			v := uint8(rng.Intn(256))
+			noise.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+
+	detailed, ok := frameScore(noise)
+	assert.True(t, ok, "Detailed frames should be accepted")
+	assert.Greater(t, detailed, halves, "More detail should score higher")
+}
+
+func TestPickVideoFrameFallback(t *testing.T) {
+	img := ImageType{
+		Filepath: filepath.Join(local.Settings.Directories.ImageDir, "missing.webm"),
+		duration: 10,
+		video:    true,
+	}
+
+	assert.Nil(t, img.pickVideoFrame(), "No frame should be picked")
+}
+
+func TestWriteFrame(t *testing.T) {
+	assert.NoError(t, os.MkdirAll(local.Settings.Directories.ThumbnailDir, 0755), "Failed to ensure thumbnail directory exists")
+
+	img := ImageType{
+		Thumbpath: filepath.Join(local.Settings.Directories.ThumbnailDir, "writeframes.jpg"),
+	}
+	defer os.Remove(img.Thumbpath)
+
+	assert.NoError(t, img.writeFrame([]byte("frame")), "An error was not expected")
+
+	data, err := os.ReadFile(img.Thumbpath)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, []byte("frame"), data, "Frame should match")
+	}
+}
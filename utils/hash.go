@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// fileHasher computes every hash kept for a file in one pass
+// sha256 identifies the file, md5 and sha1 are kept for older rows and clients
+type fileHasher struct {
+	md5    hash.Hash
+	sha1   hash.Hash
+	sha256 hash.Hash
+}
+
+func newFileHasher() *fileHasher {
+	return &fileHasher{
+		md5:    md5.New(),
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+	}
+}
+
+// writer feeds the data to every hash
+func (h *fileHasher) writer() io.Writer {
+	return io.MultiWriter(h.md5, h.sha1, h.sha256)
+}
+
+// set records the hashes on the image
+func (h *fileHasher) set(i *ImageType) {
+	i.MD5 = hex.EncodeToString(h.md5.Sum(nil))
+	i.SHA = hex.EncodeToString(h.sha1.Sum(nil))
+	i.SHA256 = hex.EncodeToString(h.sha256.Sum(nil))
+}
+
+// hashClause matches a file by sha256
+// the md5 is only compared for rows stored before sha256 was
+func hashClause(sha256Column, md5Column, sha256, md5 string) (clause string, args []interface{}) {
+	if sha256 == "" {
+		return md5Column + " = ?", []interface{}{md5}
+	}
+
+	return "(" + sha256Column + " = ? OR (" + sha256Column + " IS NULL AND " + md5Column + " = ?))", []interface{}{sha256, md5}
+}
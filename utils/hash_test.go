@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileHasher(t *testing.T) {
+	hasher := newFileHasher()
+
+	hasher.writer().Write([]byte(""))
+
+	img := ImageType{}
+	hasher.set(&img)
+
+	assert.Equal(t, testMD5, img.MD5, "MD5 should match")
+	assert.Equal(t, testSHA, img.SHA, "SHA should match")
+	assert.Equal(t, testSHA256, img.SHA256, "SHA256 should match")
+}
+
+func TestWriteTempSHA256(t *testing.T) {
+	img := ImageType{}
+
+	assert.NoError(t, img.writeTemp(strings.NewReader("")), "An error was not expected")
+	defer img.removeTemp()
+
+	assert.Equal(t, testSHA256, img.SHA256, "SHA256 should be set")
+}
+
+func TestHashClause(t *testing.T) {
+	clause, args := hashClause("image_sha256", "image_hash", "sha", "md5")
+	assert.Equal(t, "(image_sha256 = ? OR (image_sha256 IS NULL AND image_hash = ?))", clause, "Clause should match")
+	assert.Equal(t, []interface{}{"sha", "md5"}, args, "Args should match")
+
+	clause, args = hashClause("image_sha256", "image_hash", "", "md5")
+	assert.Equal(t, "image_hash = ?", clause, "Clause should match")
+	assert.Equal(t, []interface{}{"md5"}, args, "Args should match")
+}
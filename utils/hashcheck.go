@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+)
+
+var (
+	maxHashChecks         = 30
+	hashCheckSeconds uint = 60
+)
+
+// ErrMaxHashChecks is returned when an ip has checked too many hashes
+var ErrMaxHashChecks = errors.New("hash check limit exceeded")
+
+// HashStatus is what is known about a file before it is uploaded
+type HashStatus struct {
+	Banned    bool `json:"banned"`
+	Duplicate bool `json:"duplicate"`
+	Thread    uint `json:"thread,omitempty"`
+	Post      uint `json:"post,omitempty"`
+}
+
+// HashCheckCounter will increment a counter in redis to limit hash checks
+// so the check cant be used to enumerate the hashes on a board
+func HashCheckCounter(ip string) error {
+	if ip == "" {
+		return e.ErrInternalError
+	}
+
+	// key is like hashcheck:10.0.0.1
+	key := fmt.Sprintf("hashcheck:%s", ip)
+
+	result, err := redis.Cache.Incr(key)
+	if err != nil {
+		return e.ErrInternalError
+	}
+
+	err = redis.Cache.Expire(key, hashCheckSeconds)
+	if err != nil {
+		return e.ErrInternalError
+	}
+
+	if result > maxHashChecks {
+		return ErrMaxHashChecks
+	}
+
+	return nil
+}
+
+// validHash checks a hex hash of the given byte length
+func validHash(hash string, size int) bool {
+	if len(hash) != size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// CheckHash looks up a sha256, md5, or sha1 with the same queries as the upload
+// a md5 alone is only a compatibility lookup and a sha1 is matched through the posted images
+// files that are cleaned of metadata are stored under a new hash and may not be found
+func CheckHash(ib uint, sha256, md5, sha string) (status HashStatus, err error) {
+
+	sha256 = strings.ToLower(sha256)
+	md5 = strings.ToLower(md5)
+	sha = strings.ToLower(sha)
+
+	if ib == 0 {
+		return status, e.ErrNoIb
+	}
+
+	if sha256 != "" && !validHash(sha256, 32) {
+		return status, e.ErrInvalidParam
+	}
+
+	if md5 != "" && !validHash(md5, 16) {
+		return status, e.ErrInvalidParam
+	}
+
+	switch {
+	case sha256 != "", md5 != "":
+		// looked up as they are
+	case sha != "":
+		if !validHash(sha, 20) {
+			return status, e.ErrInvalidParam
+		}
+
+		sha256, md5, err = shaToHashes(sha)
+		if err == sql.ErrNoRows {
+			// nothing has been posted with this file
+			return status, nil
+		} else if err != nil {
+			return
+		}
+	default:
+		return status, e.ErrInvalidParam
+	}
+
+	status.Banned, err = bannedHash(sha256, md5)
+	if err != nil {
+		return
+	}
+
+	status.Duplicate, status.Thread, status.Post, err = duplicateHash(sha256, md5, ib)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// shaToHashes gets the sha256 and md5 of a posted file from its sha1
+func shaToHashes(sha string) (sha256, md5 string, err error) {
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	err = dbase.QueryRow(`SELECT COALESCE(image_sha256, ''),image_hash FROM images WHERE image_sha = ? LIMIT 1`, sha).Scan(&sha256, &md5)
+	if err != nil {
+		return
+	}
+
+	return
+}
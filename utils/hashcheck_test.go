@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/rafaeljusto/redigomock"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+)
+
+const (
+	testMD5    = "d41d8cd98f00b204e9800998ecf8427e"
+	testSHA    = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	testSHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+func TestHashCheckCounter(t *testing.T) {
+	redis.NewRedisMock()
+
+	redis.Cache.Mock.Command("INCR", "hashcheck:10.0.0.1").Expect([]byte("30"))
+	redis.Cache.Mock.Command("EXPIRE", "hashcheck:10.0.0.1", redigomock.NewAnyData())
+
+	assert.NoError(t, HashCheckCounter("10.0.0.1"), "An error was not expected")
+
+	redis.Cache.Mock.Command("INCR", "hashcheck:10.0.0.1").Expect([]byte("31"))
+
+	assert.Equal(t, ErrMaxHashChecks, HashCheckCounter("10.0.0.1"), "Error should match")
+
+	assert.Equal(t, e.ErrInternalError, HashCheckCounter(""), "Error should match")
+}
+
+func TestCheckHashMD5(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	banned := sqlmock.NewRows([]string{"count"}).AddRow(1)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files WHERE ban_hash = \?`).
+		WithArgs(testMD5).
+		WillReturnRows(banned)
+
+	match := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(1, 10, 2)
+	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).
+		WithArgs(testMD5, 1).
+		WillReturnRows(match)
+
+	status, err := CheckHash(1, "", "D41D8CD98F00B204E9800998ECF8427E", "")
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, HashStatus{Banned: true, Duplicate: true, Thread: 2, Post: 10}, status, "Status should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestCheckHashSHA256(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	banned := sqlmock.NewRows([]string{"count"}).AddRow(0)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files WHERE \(\(ban_sha256 = \?`).
+		WithArgs(testSHA256, testMD5, testSHA256).
+		WillReturnRows(banned)
+
+	match := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(1, 10, 2)
+	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).
+		WithArgs(testSHA256, testMD5, testSHA256, 1).
+		WillReturnRows(match)
+
+	status, err := CheckHash(1, testSHA256, testMD5, "")
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, HashStatus{Duplicate: true, Thread: 2, Post: 10}, status, "Status should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestCheckHashSHA(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	hash := sqlmock.NewRows([]string{"image_sha256", "image_hash"}).AddRow(testSHA256, testMD5)
+	mock.ExpectQuery(`SELECT COALESCE\(image_sha256, ''\),image_hash FROM images WHERE image_sha = \?`).
+		WithArgs(testSHA).
+		WillReturnRows(hash)
+
+	banned := sqlmock.NewRows([]string{"count"}).AddRow(0)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files`).
+		WithArgs(testSHA256, testMD5, testSHA256).
+		WillReturnRows(banned)
+
+	nomatch := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(0, nil, nil)
+	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).
+		WithArgs(testSHA256, testMD5, testSHA256, 1).
+		WillReturnRows(nomatch)
+
+	status, err := CheckHash(1, "", "", testSHA)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, HashStatus{}, status, "Status should match")
+	}
+
+	// an unknown sha has never been posted
+	mock.ExpectQuery(`SELECT COALESCE\(image_sha256, ''\),image_hash FROM images WHERE image_sha = \?`).
+		WithArgs(testSHA).
+		WillReturnError(sql.ErrNoRows)
+
+	status, err = CheckHash(1, "", "", testSHA)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, HashStatus{}, status, "Status should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestCheckHashInvalid(t *testing.T) {
+	_, err := CheckHash(0, "", testMD5, "")
+	assert.Equal(t, e.ErrNoIb, err, "Error should match")
+
+	for _, input := range [][3]string{
+		{"", "", ""},
+		{"", "test", ""},
+		{"", testSHA, ""},
+		{"", "zz1d8cd98f00b204e9800998ecf8427e", ""},
+		{"", "", testMD5},
+		{testMD5, "", ""},
+		{testSHA256, "test", ""},
+	} {
+		_, err = CheckHash(1, input[0], input[1], input[2])
+		assert.Equal(t, e.ErrInvalidParam, err, "Error should match")
+	}
+}
+
+func TestCheckHashError(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files WHERE ban_hash = \?`).
+		WillReturnError(errors.New("SQL error"))
+
+	_, err = CheckHash(1, "", testMD5, "")
+	assert.Error(t, err, "An error was expected")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
@@ -1,12 +1,8 @@
 package utils
 
 import (
-	"bytes"
 	"context"
-	"crypto/md5"
-	"crypto/sha1"
 	"database/sql"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"image"
@@ -22,12 +18,13 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	shortid "github.com/teris-io/shortid"
+	// webp support
+	_ "golang.org/x/image/webp"
 
 	"github.com/eirka/eirka-libs/config"
 	"github.com/eirka/eirka-libs/db"
@@ -37,7 +34,7 @@ import (
 
 // Timeout constants for external processes
 const (
-	initialCheckTimeout = 10 * time.Second // Timeout for initial version checks
+	initialCheckTimeout = 10 * time.Second // Timeout for version checks
 	processTimeout      = 60 * time.Second // Timeout for image processing operations
 )
 
@@ -47,7 +44,31 @@ var validExtAndMime = map[string][]string{
 	".jpeg": {"image/jpeg"},
 	".png":  {"image/png"},
 	".gif":  {"image/gif"},
+	".webp": {"image/webp"},
+	".avif": {"image/avif"},
 	".webm": {"video/webm"},
+	".mp4":  {"video/mp4"},
+	".mov":  {"video/quicktime", "video/mp4"},
+	".mp3":  {"audio/mpeg"},
+	".ogg":  {"audio/ogg"},
+	".oga":  {"audio/ogg"},
+	".opus": {"audio/ogg"},
+	".flac": {"audio/flac"},
+}
+
+// the extension used for each detected type when the upload has none
+var mimeExt = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/avif":      ".avif",
+	"video/webm":      ".webm",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+	"audio/mpeg":      ".mp3",
+	"audio/ogg":       ".ogg",
+	"audio/flac":      ".flac",
 }
 
 // valid file extensions
@@ -56,25 +77,16 @@ var validExt = map[string]bool{
 	".jpeg": true,
 	".png":  true,
 	".gif":  true,
+	".webp": true,
+	".avif": true,
 	".webm": true,
-}
-
-func init() {
-	var err error
-
-	// Create context with timeout for testing ImageMagick
-	ctx, cancel := context.WithTimeout(context.Background(), initialCheckTimeout)
-	defer cancel()
-
-	// Test for ImageMagick
-	cmd := exec.CommandContext(ctx, "convert", "--version")
-	_, err = cmd.Output()
-	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			panic(fmt.Sprintf("ImageMagick check timed out after %v", initialCheckTimeout))
-		}
-		panic("ImageMagick not found")
-	}
+	".mp4":  true,
+	".mov":  true,
+	".mp3":  true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+	".flac": true,
 }
 
 // FileUploader defines the file processing functions
@@ -85,47 +97,79 @@ type FileUploader interface {
 
 	// image processing
 	SaveImage() (err error)
+	loadPolicy() (err error)
 	checkReqExt() (err error)
-	copyBytes() (err error)
-	getHash() (err error)
+	copyFile() (err error)
 	checkBanned() (err error)
 	checkDuplicate() (err error)
+	getPHash() (err error)
+	checkSimilar() (err error)
 	checkMagic() (err error)
+	checkLimits() (err error)
+	sanitize() (err error)
 	getStats() (err error)
 	saveFile() (err error)
 	makeFilenames()
 	createThumbnail(maxwidth, maxheight int) (err error)
+	createProfileThumbnails() (err error)
+	createAnimatedThumbnail() (err error)
+	createSpoilerThumbnail() (err error)
 	cleanupFiles() // cleanup files on error
 
 	// webm specific functions
 	checkWebM() (err error)
 	createWebMThumbnail() (err error)
 
+	// audio specific functions
+	checkAudio() (err error)
+	createAudioThumbnail() (err error)
+
 	// avatar functions
 	SaveAvatar() (err error)
 }
 
 // ImageType defines an image and its metadata for processing
 type ImageType struct {
-	File        multipart.File
-	Header      *multipart.FileHeader
-	Ib          uint
-	Filename    string
-	Thumbnail   string
-	Filepath    string
-	Thumbpath   string
-	Ext         string
-	MD5         string
-	SHA         string
+	File   multipart.File
+	Header *multipart.FileHeader
+	URL    string
+	// Context cancels the download of a file url
+	Context   context.Context
+	UploadID  string
+	Ib        uint
+	Filename  string
+	Thumbnail string
+	Filepath  string
+	Thumbpath string
+	Ext       string
+	MD5       string
+	SHA       string
+	SHA256    string
+	// SourceSHA256 is the hash of an upload before it was converted
+	SourceSHA256 string
+	// PHash is null for files that are not hashed like audio
+	PHash       sql.NullInt64
 	OrigWidth   int
 	OrigHeight  int
 	ThumbWidth  int
 	ThumbHeight int
-	image       *bytes.Buffer
+	Thumbnails  []ThumbnailFile
+	Spoiler     bool
+	Processor   ImageProcessor
+	remote      io.ReadCloser
+	upload      *Upload
+	tempName    string
+	header      []byte
+	size        int64
 	mime        string
 	duration    int
+	frames      int
 	video       bool
+	audio       bool
+	coverArt    bool
+	policy      *UploadPolicy
 	avatar      bool
+	sanitized   bool
 }
 
 var _ = FileUploader(&ImageType{})
@@ -165,6 +209,10 @@ func (i *ImageType) IsValid() bool {
 		return false
 	}
 
+	if i.SHA256 == "" {
+		return false
+	}
+
 	if i.mime == "" {
 		return false
 	}
@@ -205,20 +253,36 @@ func (i *ImageType) SaveImage() (err error) {
 		}
 	}()
 
-	// check given file ext
-	err = i.checkReqExt()
+	// get the allowed formats and limits for the board
+	err = i.loadPolicy()
 	if err != nil {
 		return
 	}
 
-	// copy the multipart file into a buffer
-	err = i.copyBytes()
+	// download a file url instead of an upload
+	if i.File == nil && i.URL != "" {
+		err = i.openURL()
+		if err != nil {
+			return
+		}
+	}
+
+	// or use a finished resumable upload
+	if i.File == nil && i.UploadID != "" {
+		err = i.openUpload()
+		if err != nil {
+			return
+		}
+	}
+
+	// check given file ext
+	err = i.checkReqExt()
 	if err != nil {
 		return
 	}
 
-	// get file md5
-	err = i.getHash()
+	// stream the multipart file to a temp file and hash it
+	err = i.copyFile()
 	if err != nil {
 		return
 	}
@@ -229,14 +293,34 @@ func (i *ImageType) SaveImage() (err error) {
 		return
 	}
 
-	// check to see if the file already exists
-	err = i.checkDuplicate()
+	// check file magic sig
+	err = i.checkMagic()
 	if err != nil {
 		return
 	}
 
-	// check file magic sig
-	err = i.checkMagic()
+	// reject decompression bombs before anything decodes the pixels
+	err = i.checkLimits()
+	if err != nil {
+		return
+	}
+
+	// strip metadata and apply the orientation
+	err = i.sanitize()
+	if err != nil {
+		return
+	}
+
+	// the cleaned file has new hashes to check
+	if i.sanitized {
+		err = i.checkBanned()
+		if err != nil {
+			return
+		}
+	}
+
+	// check to see if the file already exists
+	err = i.checkDuplicate()
 	if err != nil {
 		return
 	}
@@ -247,6 +331,20 @@ func (i *ImageType) SaveImage() (err error) {
 		return
 	}
 
+	// hash images now, webms and avifs are hashed from their thumbnail frame
+	if !i.needsFrame() {
+		err = i.getPHash()
+		if err != nil {
+			return
+		}
+
+		// check for banned or duplicate images that were altered
+		err = i.checkSimilar()
+		if err != nil {
+			return
+		}
+	}
+
 	// save the file to disk
 	err = i.saveFile()
 	if err != nil {
@@ -261,6 +359,12 @@ func (i *ImageType) SaveImage() (err error) {
 			return
 		}
 
+		// optionally convert mp4 and mov files
+		err = i.convertVideo()
+		if err != nil {
+			return
+		}
+
 		// create thumbnail from webm
 		err = i.createWebMThumbnail()
 		if err != nil {
@@ -268,18 +372,74 @@ func (i *ImageType) SaveImage() (err error) {
 		}
 	}
 
+	// decode an avif with ffmpeg
+	if i.mime == "image/avif" {
+		err = i.createAVIFFrame()
+		if err != nil {
+			return
+		}
+	}
+
+	// process audio
+	if i.audio {
+		// check the audio info
+		err = i.checkAudio()
+		if err != nil {
+			return
+		}
+
+		// extract the cover art or draw a waveform
+		err = i.createAudioThumbnail()
+		if err != nil {
+			return
+		}
+	}
+
+	// cover art and waveforms are shared between files so audio is not hashed
+	if i.needsFrame() && !i.audio {
+		// hash the extracted frame
+		err = i.getPHash()
+		if err != nil {
+			return
+		}
+
+		// check for banned or duplicate files that were altered
+		err = i.checkSimilar()
+		if err != nil {
+			return
+		}
+	}
+
+	// the extra sizes go first since the main thumbnail replaces an extracted frame
+	err = i.createProfileThumbnails()
+	if err != nil {
+		return
+	}
+
 	// create a thumbnail
 	err = i.createThumbnail(config.Settings.Limits.ThumbnailMaxWidth, config.Settings.Limits.ThumbnailMaxHeight)
 	if err != nil {
 		return
 	}
 
+	// a looping preview for gifs and videos, the thumbnail is the fallback
+	err = i.createAnimatedThumbnail()
+	if err != nil {
+		return
+	}
+
 	// check final state
 	if !i.IsValidPost() {
 		err = errors.New("ImageType is not valid")
 		return
 	}
 
+	// move the files to storage
+	err = i.publishFiles()
+	if err != nil {
+		return
+	}
+
 	// Mark as successful to prevent cleanup
 	success = true
 	return
@@ -328,6 +488,11 @@ func (i *ImageType) checkReqExt() (err error) {
 		return errors.New("format not supported")
 	}
 
+	// and allowed on this board
+	if !i.allowsExt(ext) {
+		return errors.New("format not allowed on this board")
+	}
+
 	i.Ext = ext
 	return
 }
@@ -342,52 +507,72 @@ func isAllowedExt(ext string) bool {
 	return validExt[ext]
 }
 
-// Copy the multipart file into a bytes buffer
-func (i *ImageType) copyBytes() (err error) {
-	defer i.File.Close()
+// copyFile streams the multipart file, resumable upload or url download to a temp file in the image dir
+// so only the header is held in memory
+func (i *ImageType) copyFile() (err error) {
+	var src io.ReadCloser
+
+	switch {
+	case i.File != nil:
+		src = i.File
+	case i.upload != nil:
+		src, err = i.upload.Open()
+		if err != nil {
+			return
+		}
+	default:
+		src = i.remote
+		i.remote = nil
+	}
+
+	if src == nil {
+		return errors.New("no file provided")
+	}
+	// the source is only read here so this is the one place it is closed
+	defer src.Close()
+
+	var r io.Reader = src
 
-	i.image = new(bytes.Buffer)
+	// read one byte past the limit to tell if the file is too large
+	maxSize := int64(i.maxSize())
+	if maxSize > 0 {
+		r = io.LimitReader(src, maxSize+1)
+	}
 
-	// Save file and also read into hasher for md5
-	_, err = io.Copy(i.image, i.File)
+	err = i.writeTemp(r)
 	if err != nil {
-		return errors.New("problem copying file to buffer")
+		return
+	}
+
+	if maxSize > 0 && i.size > maxSize {
+		return fmt.Errorf("image filesize too large. Max: %dMB", (maxSize/1024)/1024)
 	}
 
 	return
 }
 
-// Get image MD5 and write file into buffer
-func (i *ImageType) getHash() (err error) {
-
-	hasher := md5.New()
-
-	sha := sha1.New()
+// check if the file is banned
+func (i *ImageType) checkBanned() (err error) {
 
-	// read into hasher for md5
-	_, err = io.Copy(hasher, bytes.NewReader(i.image.Bytes()))
-	if err != nil {
-		return errors.New("problem creating MD5 hash")
+	if i.SHA256 == "" || i.MD5 == "" {
+		return errors.New("no hash set on file banned check")
 	}
 
-	// Set md5sum from hasher
-	i.MD5 = hex.EncodeToString(hasher.Sum(nil))
-
-	// read into hasher for md5
-	_, err = io.Copy(sha, bytes.NewReader(i.image.Bytes()))
+	banned, err := bannedHash(i.SHA256, i.MD5)
 	if err != nil {
-		return errors.New("problem creating SHA1 hash")
+		return
 	}
 
-	// Set sha1
-	i.SHA = hex.EncodeToString(sha.Sum(nil))
+	// return error if it exists
+	if banned {
+		return fmt.Errorf("file is banned")
+	}
 
 	return
-
 }
 
-// check if the md5 is a banned file
-func (i *ImageType) checkBanned() (err error) {
+// bannedHash checks the banned files for a sha256, or only a md5 for older clients
+func bannedHash(sha256, md5 string) (banned bool, err error) {
 
 	// Get Database handle
 	dbase, err := db.GetDb()
@@ -395,27 +580,49 @@ func (i *ImageType) checkBanned() (err error) {
 		return
 	}
 
-	if i.MD5 == "" {
-		return errors.New("no hash set on file banned check")
+	clause, args := hashClause("ban_sha256", "ban_hash", sha256, md5)
+
+	// bans of a converted video also cover the file it was converted from
+	if sha256 != "" {
+		clause = "(" + clause + ` OR EXISTS (SELECT 1 FROM images WHERE image_source_sha256 = ?
+		AND (image_sha256 = ban_sha256 OR image_hash = ban_hash)))`
+		args = append(args, sha256)
+	}
+
+	err = dbase.QueryRow(`SELECT count(*) FROM banned_files WHERE `+clause, args...).Scan(&banned)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// check if the file is already in the database
+func (i *ImageType) checkDuplicate() (err error) {
+
+	if i.SHA256 == "" || i.MD5 == "" {
+		return errors.New("no hash set on duplicate check")
 	}
 
-	var check bool
+	if i.Ib == 0 {
+		return errors.New("no imageboard set on duplicate check")
+	}
 
-	err = dbase.QueryRow(`SELECT count(*) FROM banned_files WHERE ban_hash = ?`, i.MD5).Scan(&check)
+	found, thread, post, err := duplicateHash(i.SHA256, i.MD5, i.Ib)
 	if err != nil {
 		return
 	}
 
 	// return error if it exists
-	if check {
-		return fmt.Errorf("file is banned")
+	if found {
+		return fmt.Errorf("image has already been posted. Thread: %d Post: %d", thread, post)
 	}
 
 	return
 }
 
-// check if the md5 is already in the database
-func (i *ImageType) checkDuplicate() (err error) {
+// duplicateHash finds where a sha256, or only a md5 for older clients, was posted on the board
+func duplicateHash(sha256, md5 string, ib uint) (found bool, thread, post uint, err error) {
 
 	// Get Database handle
 	dbase, err := db.GetDb()
@@ -423,43 +630,59 @@ func (i *ImageType) checkDuplicate() (err error) {
 		return
 	}
 
-	if i.MD5 == "" {
-		return errors.New("no hash set on duplicate check")
-	}
+	var threadID, postNum sql.NullInt64
 
-	if i.Ib == 0 {
-		return errors.New("no imageboard set on duplicate check")
-	}
+	clause, args := hashClause("image_sha256", "image_hash", sha256, md5)
 
-	var check bool
-	var thread, post sql.NullInt64
+	// converted videos are also found by the file they were converted from
+	if sha256 != "" {
+		clause = "(" + clause + " OR image_source_sha256 = ?)"
+		args = append(args, sha256)
+	}
 
 	err = dbase.QueryRow(`select count(1),posts.post_num,threads.thread_id from threads
 	LEFT JOIN posts on threads.thread_id = posts.thread_id
 	LEFT JOIN images on posts.post_id = images.post_id
-	WHERE image_hash = ? AND ib_id = ? AND post_deleted = 0`, i.MD5, i.Ib).Scan(&check, &post, &thread)
+	WHERE `+clause+` AND ib_id = ? AND post_deleted = 0 AND image_deleted = 0`, append(args, ib)...).Scan(&found, &postNum, &threadID)
 	if err != nil {
 		return
 	}
 
-	// return error if it exists
-	if check {
-		return fmt.Errorf("image has already been posted. Thread: %d Post: %d", thread.Int64, post.Int64)
+	return found, uint(threadID.Int64), uint(postNum.Int64), nil
+}
+
+// needsFrame is true for files that are thumbnailed from a frame extracted by ffmpeg
+func (i *ImageType) needsFrame() bool {
+	return i.video || i.audio || i.mime == "image/avif"
+}
+
+// detectContentType adds the formats the http sniffer does not know about
+func detectContentType(data []byte) string {
+	if isAVIF(data) {
+		return "image/avif"
 	}
 
-	return
+	if mime := isoVideoType(data); mime != "" {
+		return mime
+	}
+
+	if mime := audioType(data); mime != "" {
+		return mime
+	}
+
+	return http.DetectContentType(data)
 }
 
 func (i *ImageType) checkMagic() (err error) {
-	if i.image == nil || i.image.Len() == 0 {
+	if len(i.header) == 0 {
 		return errors.New("no image data to analyze")
 	}
 
-	// Get the file's content for analysis
-	fileBytes := i.image.Bytes()
+	// only the header is needed to detect the type
+	fileBytes := i.header
 
 	// Detect the MIME type from file content signatures
-	i.mime = http.DetectContentType(fileBytes)
+	i.mime = detectContentType(fileBytes)
 
 	// If an extension was provided earlier, verify it matches the detected type
 	if i.Ext != "" {
@@ -475,19 +698,21 @@ func (i *ImageType) checkMagic() (err error) {
 		}
 	} else {
 		// Set extension based on detected MIME type if not provided
-		switch i.mime {
-		case "image/png":
-			i.Ext = ".png"
-		case "image/jpeg":
-			i.Ext = ".jpg"
-		case "image/gif":
-			i.Ext = ".gif"
-		case "video/webm":
-			i.Ext = ".webm"
-			i.video = true
-		default:
+		ext, ok := mimeExt[i.mime]
+		if !ok {
 			return errors.New("unknown or unsupported file type")
 		}
+
+		i.Ext = ext
+	}
+
+	// videos are probed and thumbnailed with ffmpeg
+	i.video = strings.HasPrefix(i.mime, "video/")
+	// so is audio
+	i.audio = strings.HasPrefix(i.mime, "audio/")
+
+	if i.audio && !i.allowsAudio() {
+		return errors.New("audio is not allowed on this board")
 	}
 
 	// Perform additional validation based on file type
@@ -502,11 +727,6 @@ func (i *ImageType) checkMagic() (err error) {
 		if len(fileBytes) < 2 || fileBytes[0] != 0xFF || fileBytes[1] != 0xD8 {
 			return errors.New("invalid JPEG file signature")
 		}
-		// Check for JPEG end marker (less reliable due to large files, but helpful)
-		if len(fileBytes) >= 2 && !(fileBytes[len(fileBytes)-2] == 0xFF && fileBytes[len(fileBytes)-1] == 0xD9) {
-			// This is just a warning, not a hard error, as some valid JPEGs might not have proper EOF markers
-			// Log here if needed
-		}
 	case "image/gif":
 		// Check GIF header (GIF87a or GIF89a)
 		if len(fileBytes) < 6 {
@@ -516,16 +736,36 @@ func (i *ImageType) checkMagic() (err error) {
 		if header != "GIF87a" && header != "GIF89a" {
 			return errors.New("invalid GIF file signature")
 		}
+	case "image/webp":
+		// RIFF container with a WEBP form type
+		if !isWebP(fileBytes) {
+			return errors.New("invalid WebP file signature")
+		}
+	case "image/avif":
+		// ftyp box with an avif brand
+		if !isAVIF(fileBytes) {
+			return errors.New("invalid AVIF file signature")
+		}
 	case "video/webm":
 		// Basic WebM check - validate EBML header
 		// WebM files start with an EBML header (0x1A 0x45 0xDF 0xA3)
 		if len(fileBytes) < 4 || fileBytes[0] != 0x1A || fileBytes[1] != 0x45 || fileBytes[2] != 0xDF || fileBytes[3] != 0xA3 {
 			return errors.New("invalid WebM file signature")
 		}
+	case "video/mp4", "video/quicktime":
+		// MP4 and MOV files start with an ftyp box
+		if len(fileBytes) < 12 || string(fileBytes[4:8]) != "ftyp" {
+			return errors.New("invalid MP4 file signature")
+		}
+	case "audio/mpeg", "audio/ogg", "audio/flac":
+		// id3 tag or frame sync, ogg page, or flac stream marker
+		if audioType(fileBytes) != i.mime {
+			return errors.New("invalid audio file signature")
+		}
 	}
 
 	// Check for suspiciously small files that might be trying to bypass checks
-	if !i.video && len(fileBytes) < 100 {
+	if !i.video && i.size < 100 {
 		return errors.New("file is suspiciously small")
 	}
 
@@ -534,44 +774,69 @@ func (i *ImageType) checkMagic() (err error) {
 
 func (i *ImageType) getStats() (err error) {
 
-	// skip if its a video since we cant decode it
-	if i.video {
+	// skip if its a video or audio since we cant decode it
+	if i.video || i.audio {
 		return
 	}
 
-	// decode image config
-	img, _, err := image.DecodeConfig(bytes.NewReader(i.image.Bytes()))
+	i.OrigWidth, i.OrigHeight, err = i.dimensions()
 	if err != nil {
-		return errors.New("problem decoding image")
+		return
 	}
 
-	// set original width
-	i.OrigWidth = img.Width
-	// set original height
-	i.OrigHeight = img.Height
-
 	// Check against maximum sizes
 	switch {
-	case i.OrigWidth > config.Settings.Limits.ImageMaxWidth:
-		return fmt.Errorf("image width too large. Max: %dpx", config.Settings.Limits.ImageMaxWidth)
-	case img.Width < config.Settings.Limits.ImageMinWidth:
+	case i.OrigWidth > i.maxWidth():
+		return fmt.Errorf("image width too large. Max: %dpx", i.maxWidth())
+	case i.OrigWidth < config.Settings.Limits.ImageMinWidth:
 		return fmt.Errorf("image width too small. Min: %dpx", config.Settings.Limits.ImageMinWidth)
-	case i.OrigHeight > config.Settings.Limits.ImageMaxHeight:
-		return fmt.Errorf("image height too large. Max: %dpx", config.Settings.Limits.ImageMaxHeight)
-	case img.Height < config.Settings.Limits.ImageMinHeight:
+	case i.OrigHeight > i.maxHeight():
+		return fmt.Errorf("image height too large. Max: %dpx", i.maxHeight())
+	case i.OrigHeight < config.Settings.Limits.ImageMinHeight:
 		return fmt.Errorf("image height too small. Min: %dpx", config.Settings.Limits.ImageMinHeight)
-	case i.image.Len() > config.Settings.Limits.ImageMaxSize:
-		return fmt.Errorf("image filesize too large. Max: %dMB", (config.Settings.Limits.ImageMaxSize/1024)/1024)
+	case i.size > int64(i.maxSize()):
+		return fmt.Errorf("image filesize too large. Max: %dMB", (i.maxSize()/1024)/1024)
 	}
 
-	return
+	return checkPixels(i.OrigWidth, i.OrigHeight)
 
 }
 
-func (i *ImageType) saveFile() (err error) {
-	// Reset the image buffer when done regardless of success or failure
-	defer i.image.Reset()
+// dimensions reads the width and height from the image header
+func (i *ImageType) dimensions() (width, height int, err error) {
+
+	// avif is not supported by the image package
+	if i.mime == "image/avif" {
+		var data []byte
+		data, err = i.readTemp()
+		if err != nil {
+			return 0, 0, errors.New("problem reading image")
+		}
+
+		width, height, err = avifDimensions(data)
+		if err != nil {
+			return 0, 0, errors.New("problem decoding image")
+		}
+
+		return
+	}
+
+	file, err := i.openTemp()
+	if err != nil {
+		return 0, 0, errors.New("problem reading image")
+	}
+	defer file.Close()
+
+	// decode image config, this only reads the header
+	img, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, errors.New("problem decoding image")
+	}
 
+	return img.Width, img.Height, nil
+}
+
+func (i *ImageType) saveFile() (err error) {
 	// Generate filenames and paths
 	i.makeFilenames()
 
@@ -586,27 +851,8 @@ func (i *ImageType) saveFile() (err error) {
 		return errors.New("ImageType is not valid")
 	}
 
-	// Open the image dir with traversal-resistant root
-	root, err := os.OpenRoot(local.Settings.Directories.ImageDir)
-	if err != nil {
-		return fmt.Errorf("failed to open directory: %v", err)
-	}
-	defer root.Close()
-
-	// Create the file
-	image, err := root.Create(i.Filename)
-	if err != nil {
-		return fmt.Errorf("problem creating file: %v", err)
-	}
-	defer image.Close()
-
-	// Write the image data to the file
-	_, err = io.Copy(image, bytes.NewReader(i.image.Bytes()))
-	if err != nil {
-		return fmt.Errorf("problem writing to file: %v", err)
-	}
-
-	return nil
+	// move the checked upload into place
+	return i.renameTemp()
 }
 
 // Make a random unix time filename
@@ -634,136 +880,99 @@ func (i *ImageType) makeFilenames() {
 
 func (i *ImageType) createThumbnail(maxwidth, maxheight int) (err error) {
 
-	var imagef string
+	thumbpath := local.Settings.Directories.ThumbnailDir
 
-	if i.video {
-		imagef = fmt.Sprintf("%s[0]", i.Thumbpath)
-	} else {
-		imagef = fmt.Sprintf("%s[0]", i.Filepath)
+	if i.avatar {
+		thumbpath = local.Settings.Directories.AvatarDir
 	}
 
-	originalDimensions := fmt.Sprintf("%dx%d", i.OrigWidth, i.OrigHeight)
-
-	var args []string
-
-	// different options for avatars
-	if i.avatar {
-		args = []string{
-			"-size",
-			originalDimensions,
-			imagef,
-			"-background",
-			"none",
-			"-thumbnail",
-			fmt.Sprintf("%dx%d^", maxwidth, maxheight),
-			"-gravity",
-			"center",
-			"-extent",
-			fmt.Sprintf("%dx%d", maxwidth, maxheight),
-			i.Thumbpath,
-		}
-	} else {
-		args = []string{
-			"-background",
-			"white",
-			"-flatten",
-			"-size",
-			originalDimensions,
-			"-resize",
-			fmt.Sprintf("%dx%d>", maxwidth, maxheight),
-			"-quality",
-			"90",
-			imagef,
-			i.Thumbpath,
-		}
+	i.ThumbWidth, i.ThumbHeight, err = i.makeThumbnail(i.Thumbpath, thumbpath, i.Thumbnail, ThumbnailOptions{
+		MaxWidth:   maxwidth,
+		MaxHeight:  maxheight,
+		OrigWidth:  i.OrigWidth,
+		OrigHeight: i.OrigHeight,
+		Quality:    90,
+		Crop:       i.avatar,
+	})
+	if err != nil {
+		return
 	}
 
-	// Create context with timeout for ImageMagick operations
-	ctx, cancel := context.WithTimeout(context.Background(), processTimeout)
-	defer cancel()
+	// spoilered images also get a blurred copy to show until clicked
+	return i.createSpoilerThumbnail()
+}
+
+// makeThumbnail runs the processor and reads back the size of the thumbnail
+func (i *ImageType) makeThumbnail(dst, dir, name string, opts ThumbnailOptions) (width, height int, err error) {
 
-	cmd := exec.CommandContext(ctx, "convert", args...)
-	_, err = cmd.Output()
+	processor, err := i.processor()
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("thumbnail creation timed out after %v", processTimeout)
-		}
-		return errors.New("problem making thumbnail")
+		return
 	}
 
-	thumbpath := local.Settings.Directories.ThumbnailDir
+	// webm and avif thumbnails are made from the extracted frame
+	src := i.Filepath
+	if i.needsFrame() {
+		src = i.Thumbpath
+	}
 
-	if i.avatar {
-		thumbpath = local.Settings.Directories.AvatarDir
+	err = processor.Thumbnail(src, dst, opts)
+	if err != nil {
+		return
 	}
 
-	thumb, err := os.OpenInRoot(thumbpath, i.Thumbnail)
+	thumb, err := os.OpenInRoot(dir, name)
 	if err != nil {
-		return errors.New("problem creating thumbnail file")
+		return 0, 0, errors.New("problem creating thumbnail file")
 	}
 	defer thumb.Close()
 
 	img, _, err := image.DecodeConfig(thumb)
 	if err != nil {
-		return errors.New("problem decoding thumbnail")
+		return 0, 0, errors.New("problem decoding thumbnail")
 	}
 
-	i.ThumbWidth = img.Width
-	i.ThumbHeight = img.Height
-
-	return
+	return img.Width, img.Height, nil
 }
 
 // cleanupFiles removes any files that were created during the image processing
 // to ensure we don't leave orphaned files on disk after failed operations
 func (i *ImageType) cleanupFiles() {
-	// Clean up the main image file if it exists and has a valid path
-	if i.Filepath != "" && i.Filename != "" {
-		// Determine which root directory to use
-		rootDir := local.Settings.Directories.ImageDir
-		if i.avatar {
-			rootDir = local.Settings.Directories.AvatarDir
-		}
+	// the upload may not have been renamed yet
+	i.removeTemp()
 
-		// Open the root directory
-		root, err := os.OpenRoot(rootDir)
-		if err == nil {
-			defer root.Close()
-
-			// Extra validation to ensure we're only dealing with files
-			fileInfo, err := root.Stat(i.Filename)
-			if err == nil && !fileInfo.IsDir() {
-				// Validate that the path ends with the filename
-				if strings.HasSuffix(i.Filepath, i.Filename) {
-					// Safe to remove using the root-based approach
-					_ = root.Remove(i.Filename)
-				}
-			}
-		}
+	// or a url download may not have been read
+	i.closeRemote()
+
+	// Determine which root directories to use
+	rootDir := local.Settings.Directories.ImageDir
+	thumbRootDir := local.Settings.Directories.ThumbnailDir
+	if i.avatar {
+		rootDir = local.Settings.Directories.AvatarDir
+		thumbRootDir = local.Settings.Directories.AvatarDir
 	}
 
-	// Clean up the thumbnail if it exists and has a valid path
-	if i.Thumbpath != "" && i.Thumbnail != "" {
-		// Determine which root directory to use
-		thumbRootDir := local.Settings.Directories.ThumbnailDir
-		if i.avatar {
-			thumbRootDir = local.Settings.Directories.AvatarDir
-		}
+	// Clean up the main image file if it exists and has a valid path
+	// Validate that the path ends with the filename
+	if i.Filepath != "" && i.Filename != "" && strings.HasSuffix(i.Filepath, i.Filename) {
+		store := LocalStorage{Dir: rootDir}
+		// Safe to remove using the root-based approach
+		_ = store.Delete(i.Filename)
+	}
 
-		// Open the root directory
-		thumbRoot, err := os.OpenRoot(thumbRootDir)
-		if err == nil {
-			defer thumbRoot.Close()
-
-			// Extra validation to ensure we're only dealing with files
-			fileInfo, err := thumbRoot.Stat(i.Thumbnail)
-			if err == nil && !fileInfo.IsDir() {
-				// Validate that the path ends with the thumbnail name
-				if strings.HasSuffix(i.Thumbpath, i.Thumbnail) {
-					// Safe to remove using the root-based approach
-					_ = thumbRoot.Remove(i.Thumbnail)
-				}
-			}
+	// Clean up the thumbnail if it exists and has a valid path
+	// Validate that the path ends with the thumbnail name
+	if i.Thumbpath != "" && i.Thumbnail != "" && strings.HasSuffix(i.Thumbpath, i.Thumbnail) {
+		store := LocalStorage{Dir: thumbRootDir}
+		// Safe to remove using the root-based approach
+		_ = store.Delete(i.Thumbnail)
+	}
+
+	// Clean up the extra thumbnail sizes
+	for _, thumb := range i.Thumbnails {
+		if thumb.Filepath != "" && thumb.Filename != "" && strings.HasSuffix(thumb.Filepath, thumb.Filename) {
+			store := LocalStorage{Dir: thumbRootDir}
+			_ = store.Delete(thumb.Filename)
 		}
 	}
 }
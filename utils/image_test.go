@@ -261,12 +261,13 @@ func TestCopyBytes(t *testing.T) {
 
 	img.File, img.Header, _ = req.FormFile("file")
 
-	err := img.copyBytes()
+	err := img.copyFile()
+	defer img.removeTemp()
 	if assert.NoError(t, err, "An error was not expected") {
-		assert.NotEmpty(t, img.image, "File bytes should be returned")
+		assert.NotEmpty(t, img.tempName, "File should be copied")
 	}
 
-	assert.Equal(t, img.image.Len(), filesize, "File sizes should match")
+	assert.Equal(t, int(img.size), filesize, "File sizes should match")
 
 }
 
@@ -278,13 +279,10 @@ func TestGetMD5(t *testing.T) {
 
 	img1.File, img1.Header, _ = req.FormFile("file")
 
-	err := img1.copyBytes()
-	if assert.NoError(t, err, "An error was not expected") {
-		assert.NotEmpty(t, img1.image, "File bytes should be returned")
-	}
-
-	err = img1.getHash()
+	err := img1.copyFile()
+	defer img1.removeTemp()
 	if assert.NoError(t, err, "An error was not expected") {
+		assert.NotEmpty(t, img1.tempName, "File should be copied")
 		assert.NotEmpty(t, img1.MD5, "MD5 should be returned")
 		assert.NotEmpty(t, img1.SHA, "SHA should be returned")
 	}
@@ -295,13 +293,10 @@ func TestGetMD5(t *testing.T) {
 
 	img2.File, img2.Header, _ = req2.FormFile("file")
 
-	err = img2.copyBytes()
-	if assert.NoError(t, err, "An error was not expected") {
-		assert.NotEmpty(t, img2.image, "File bytes should be returned")
-	}
-
-	err = img2.getHash()
+	err = img2.copyFile()
+	defer img2.removeTemp()
 	if assert.NoError(t, err, "An error was not expected") {
+		assert.NotEmpty(t, img2.tempName, "File should be copied")
 		assert.NotEmpty(t, img2.MD5, "MD5 should be returned")
 		assert.NotEmpty(t, img2.SHA, "SHA should be returned")
 	}
@@ -333,13 +328,10 @@ func TestGetMD5Duplicate(t *testing.T) {
 
 	img1.File, img1.Header, _ = req1.FormFile("file")
 
-	err := img1.copyBytes()
-	if assert.NoError(t, err, "An error was not expected") {
-		assert.NotEmpty(t, img1.image, "File bytes should be returned")
-	}
-
-	err = img1.getHash()
+	err := img1.copyFile()
+	defer img1.removeTemp()
 	if assert.NoError(t, err, "An error was not expected") {
+		assert.NotEmpty(t, img1.tempName, "File should be copied")
 		assert.NotEmpty(t, img1.MD5, "MD5 should be returned")
 		assert.NotEmpty(t, img1.SHA, "SHA should be returned")
 	}
@@ -361,20 +353,17 @@ func TestGetMD5Duplicate(t *testing.T) {
 
 	img2.File, img2.Header, _ = req2.FormFile("file")
 
-	err = img2.copyBytes()
-	if assert.NoError(t, err, "An error was not expected") {
-		assert.NotEmpty(t, img2.image, "File bytes should be returned")
-	}
-
-	err = img2.getHash()
+	err = img2.copyFile()
+	defer img2.removeTemp()
 	if assert.NoError(t, err, "An error was not expected") {
+		assert.NotEmpty(t, img2.tempName, "File should be copied")
 		assert.NotEmpty(t, img2.MD5, "MD5 should be returned")
 		assert.NotEmpty(t, img2.SHA, "SHA should be returned")
 	}
 
 	assert.Equal(t, img1.MD5, img2.MD5, "MD5 should be the same")
 	assert.Equal(t, img1.SHA, img2.SHA, "SHA should be the same")
-	assert.Equal(t, img1.image.Len(), img2.image.Len(), "Size should be the same")
+	assert.Equal(t, img1.size, img2.size, "Size should be the same")
 }
 
 func TestCheckBanned(t *testing.T) {
@@ -386,17 +375,20 @@ func TestCheckBanned(t *testing.T) {
 	defer db.CloseDb()
 
 	nomatch := sqlmock.NewRows([]string{"count"}).AddRow(0)
-	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files WHERE ban_hash`).WillReturnRows(nomatch)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files WHERE \(\(ban_sha256 = \? OR \(ban_sha256 IS NULL AND ban_hash = \?\)\) OR EXISTS \(SELECT 1 FROM images WHERE image_source_sha256 = \?`).
+		WithArgs("bannedsha256", "banned", "bannedsha256").
+		WillReturnRows(nomatch)
 
 	img := ImageType{
-		MD5: "banned",
+		MD5:    "banned",
+		SHA256: "bannedsha256",
 	}
 
 	err = img.checkBanned()
 	assert.NoError(t, err, "An error was not expected")
 
 	match := sqlmock.NewRows([]string{"count"}).AddRow(1)
-	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files WHERE ban_hash`).WillReturnRows(match)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files`).WillReturnRows(match)
 
 	err = img.checkBanned()
 	if assert.Error(t, err, "An error was expected") {
@@ -416,11 +408,14 @@ func TestCheckDuplicate(t *testing.T) {
 	defer db.CloseDb()
 
 	nomatch := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(0, 0, 0)
-	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).WillReturnRows(nomatch)
+	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).
+		WithArgs("testsha256", "test", "testsha256", 1).
+		WillReturnRows(nomatch)
 
 	img := ImageType{
-		Ib:  1,
-		MD5: "test",
+		Ib:     1,
+		MD5:    "test",
+		SHA256: "testsha256",
 	}
 
 	err = img.checkDuplicate()
@@ -438,6 +433,19 @@ func TestCheckDuplicate(t *testing.T) {
 
 }
 
+func TestCheckHashMissing(t *testing.T) {
+
+	// only a md5 is not enough for new uploads
+	img := ImageType{
+		Ib:  1,
+		MD5: "test",
+	}
+
+	assert.Error(t, img.checkBanned(), "An error was expected")
+	assert.Error(t, img.checkDuplicate(), "An error was expected")
+
+}
+
 func TestCheckMagicGood(t *testing.T) {
 	testCases := []struct {
 		filename string
@@ -455,13 +463,10 @@ func TestCheckMagicGood(t *testing.T) {
 
 			img.File, img.Header, _ = req.FormFile("file")
 
-			err := img.copyBytes()
-			if assert.NoError(t, err, "An error was not expected") {
-				assert.NotEmpty(t, img.image, "File bytes should be returned")
-			}
-
-			err = img.getHash()
+			err := img.copyFile()
+			defer img.removeTemp()
 			if assert.NoError(t, err, "An error was not expected") {
+				assert.NotEmpty(t, img.tempName, "File should be copied")
 				assert.NotEmpty(t, img.MD5, "MD5 should be returned")
 				assert.NotEmpty(t, img.SHA, "SHA should be returned")
 			}
@@ -488,13 +493,10 @@ func TestCheckMagicBad(t *testing.T) {
 
 	img.File, img.Header, _ = req.FormFile("file")
 
-	err := img.copyBytes()
-	if assert.NoError(t, err, "An error was not expected") {
-		assert.NotEmpty(t, img.image, "File bytes should be returned")
-	}
-
-	err = img.getHash()
+	err := img.copyFile()
+	defer img.removeTemp()
 	if assert.NoError(t, err, "An error was not expected") {
+		assert.NotEmpty(t, img.tempName, "File should be copied")
 		assert.NotEmpty(t, img.MD5, "MD5 should be returned")
 		assert.NotEmpty(t, img.SHA, "SHA should be returned")
 	}
@@ -512,9 +514,9 @@ func TestTooSmallFile(t *testing.T) {
 	b.Write([]byte("\x89PNG\r\n\x1a\n")) // Valid PNG header
 	b.Write(make([]byte, 50))            // Add some padding to make it 58 bytes
 
-	img := ImageType{
-		image: &b,
-	}
+	img := ImageType{}
+
+	testUpload(t, &img, b.Bytes())
 
 	err := img.checkMagic()
 	if assert.Error(t, err, "An error was expected") {
@@ -530,7 +532,8 @@ func TestExtensionMimeMismatch(t *testing.T) {
 	req := formJpegRequest(300, "test.jpeg")
 	img.File, img.Header, _ = req.FormFile("file")
 
-	err := img.copyBytes()
+	err := img.copyFile()
+	defer img.removeTemp()
 	assert.NoError(t, err, "An error was not expected")
 
 	// Manually set extension to PNG, which will conflict with JPEG content
@@ -550,9 +553,9 @@ func TestCorruptedFileHeaders(t *testing.T) {
 	b.Write([]byte("This is not a real image file but has enough text to analyze"))
 	b.Write(make([]byte, 200))
 
-	img := ImageType{
-		image: &b,
-	}
+	img := ImageType{}
+
+	testUpload(t, &img, b.Bytes())
 
 	// This should detect that the content doesn't match any known file type
 	err := img.checkMagic()
@@ -571,7 +574,7 @@ func TestGetStatsGoodPng(t *testing.T) {
 
 	img := ImageType{}
 
-	img.image = testPng(400)
+	testUpload(t, &img, testPng(400).Bytes())
 
 	err := img.getStats()
 	assert.NoError(t, err, "An error was not expected")
@@ -588,7 +591,7 @@ func TestGetStatsGoodJpeg(t *testing.T) {
 
 	img := ImageType{}
 
-	img.image = testJpeg(400)
+	testUpload(t, &img, testJpeg(400).Bytes())
 
 	err := img.getStats()
 	assert.NoError(t, err, "An error was not expected")
@@ -605,7 +608,7 @@ func TestGetStatsBadSize(t *testing.T) {
 
 	img := ImageType{}
 
-	img.image = testPng(400)
+	testUpload(t, &img, testPng(400).Bytes())
 
 	err := img.getStats()
 	if assert.Error(t, err, "An error was expected") {
@@ -624,7 +627,7 @@ func TestGetStatsBadMin(t *testing.T) {
 
 	img := ImageType{}
 
-	img.image = testPng(50)
+	testUpload(t, &img, testPng(50).Bytes())
 
 	err := img.getStats()
 	if assert.Error(t, err, "An error was expected") {
@@ -643,7 +646,7 @@ func TestGetStatsBadMax(t *testing.T) {
 
 	img := ImageType{}
 
-	img.image = testPng(1200)
+	testUpload(t, &img, testPng(1200).Bytes())
 
 	err := img.getStats()
 	if assert.Error(t, err, "An error was expected") {
@@ -675,7 +678,7 @@ func TestSaveFile(t *testing.T) {
 	noban := sqlmock.NewRows([]string{"count"}).AddRow(0)
 	nodupe := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(0, 0, 0)
 
-	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files WHERE ban_hash`).WillReturnRows(noban)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files`).WillReturnRows(noban)
 	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).WillReturnRows(nodupe)
 
 	config.Settings.Limits.ImageMaxWidth = 1000
@@ -692,12 +695,13 @@ func TestSaveFile(t *testing.T) {
 
 	// Set up a proper ImageType with all required fields
 	img := ImageType{
-		Ib:    1,
-		image: jpegFile,
-		Ext:   ".jpg",
-		mime:  "image/jpeg",
+		Ib:   1,
+		Ext:  ".jpg",
+		mime: "image/jpeg",
 	}
 
+	testUpload(t, &img, jpegFile.Bytes())
+
 	// Set hash values
 	hasher := md5.New()
 	io.Copy(hasher, bytes.NewReader(jpegFile.Bytes()))
@@ -764,7 +768,7 @@ func TestSaveFileNoIb(t *testing.T) {
 	noban := sqlmock.NewRows([]string{"count"}).AddRow(0)
 	nodupe := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(0, 0, 0)
 
-	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files WHERE ban_hash`).WillReturnRows(noban)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files`).WillReturnRows(noban)
 	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).WillReturnRows(nodupe)
 
 	req := formJpegRequest(300, "test.jpeg")
@@ -839,7 +843,6 @@ func TestPartialFailureCleanup(t *testing.T) {
 	img := ImageType{
 		// Set up just enough fields for validation to pass
 		Ib:         1,
-		image:      jpegFile,
 		Ext:        ".jpg",
 		mime:       "image/jpeg",
 		OrigWidth:  500,
@@ -969,9 +972,9 @@ func TestTimeouts(t *testing.T) {
 	// A real timeout test would be slow and potentially flaky
 
 	// Test with a fake command that will be rejected by checkMagic before we get to the timeout
-	img := ImageType{
-		image: bytes.NewBuffer([]byte("not a real image")),
-	}
+	img := ImageType{}
+
+	testUpload(t, &img, []byte("not a real image"))
 
 	// This should fail with a format error, not a timeout
 	err := img.checkMagic()
@@ -980,7 +983,7 @@ func TestTimeouts(t *testing.T) {
 
 	// Create a minimal but valid test image
 	validImg := testPng(200)
-	img.image = validImg
+	testUpload(t, &img, validImg.Bytes())
 	img.Ext = ".png"
 	img.mime = "image/png"
 
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ImageMagickProcessor creates thumbnails with the ImageMagick convert binary
+type ImageMagickProcessor struct{}
+
+var _ = ImageProcessor(&ImageMagickProcessor{})
+
+// Name returns the backend name
+func (p *ImageMagickProcessor) Name() string {
+	return ProcessorImageMagick
+}
+
+// Check will make sure convert is installed
+func (p *ImageMagickProcessor) Check() (err error) {
+	return checkCommand("ImageMagick", initialCheckTimeout, "convert", "--version")
+}
+
+// Thumbnail runs convert on the first frame of src
+func (p *ImageMagickProcessor) Thumbnail(src, dst string, opts ThumbnailOptions) (err error) {
+
+	imagef := fmt.Sprintf("%s[0]", src)
+
+	originalDimensions := fmt.Sprintf("%dx%d", opts.OrigWidth, opts.OrigHeight)
+
+	var args []string
+
+	// different options for avatars
+	if opts.Crop {
+		args = []string{
+			"-size",
+			originalDimensions,
+			imagef,
+			"-background",
+			"none",
+			"-thumbnail",
+			fmt.Sprintf("%dx%d^", opts.MaxWidth, opts.MaxHeight),
+			"-gravity",
+			"center",
+			"-extent",
+			fmt.Sprintf("%dx%d", opts.MaxWidth, opts.MaxHeight),
+			dst,
+		}
+	} else {
+		args = []string{
+			"-background",
+			"white",
+			"-flatten",
+			"-size",
+			originalDimensions,
+			"-resize",
+			fmt.Sprintf("%dx%d>", opts.MaxWidth, opts.MaxHeight),
+			"-quality",
+			fmt.Sprintf("%d", opts.Quality),
+			imagef,
+			dst,
+		}
+	}
+
+	// resource limits have to come before the input file
+	args = append(magickLimits(), args...)
+
+	_, err = runMedia(processTimeout, "convert", args...)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			return
+		case errors.Is(err, errMediaTimeout):
+			return fmt.Errorf("thumbnail creation timed out after %v", processTimeout)
+		}
+		return errors.New("problem making thumbnail")
+	}
+
+	return
+}
+
+// checkCommand runs a version command to see if a binary is available
+func checkCommand(name string, timeout time.Duration, command string, args ...string) (err error) {
+	// Create context with timeout for the version check
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	_, err = cmd.Output()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s check timed out after %v", name, timeout)
+		}
+		return fmt.Errorf("%s not found", name)
+	}
+
+	return
+}
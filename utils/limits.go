@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// defaults for the decode limits when the config is empty
+const (
+	defaultMaxMegapixels  = 50
+	defaultGIFMaxFrames   = 1000
+	defaultGIFMaxDuration = 300
+	defaultPNGMaxChunk    = 1 << 20
+)
+
+// resource limits passed to convert so one file cant exhaust the host
+const (
+	magickMemoryLimit = "256MiB"
+	magickMapLimit    = "512MiB"
+	magickDiskLimit   = "1GiB"
+)
+
+// maxPixels is the most pixels an image can decode to
+func maxPixels() int {
+	if local.Settings.Uploads.MaxMegapixels > 0 {
+		return local.Settings.Uploads.MaxMegapixels * 1000000
+	}
+	return defaultMaxMegapixels * 1000000
+}
+
+// gifMaxFrames is the most frames an animated gif can have
+func gifMaxFrames() int {
+	if local.Settings.Uploads.GIFMaxFrames > 0 {
+		return local.Settings.Uploads.GIFMaxFrames
+	}
+	return defaultGIFMaxFrames
+}
+
+// gifMaxDuration is the longest an animated gif can play
+func gifMaxDuration() time.Duration {
+	if local.Settings.Uploads.GIFMaxDuration > 0 {
+		return time.Duration(local.Settings.Uploads.GIFMaxDuration) * time.Second
+	}
+	return defaultGIFMaxDuration * time.Second
+}
+
+// pngMaxChunk is the largest ancillary png chunk
+func pngMaxChunk() int {
+	if local.Settings.Uploads.PNGMaxChunk > 0 {
+		return local.Settings.Uploads.PNGMaxChunk
+	}
+	return defaultPNGMaxChunk
+}
+
+// magickLimits are the resource arguments for convert
+func magickLimits() []string {
+	return []string{
+		"-limit", "memory", magickMemoryLimit,
+		"-limit", "map", magickMapLimit,
+		"-limit", "disk", magickDiskLimit,
+		"-limit", "area", fmt.Sprintf("%d", maxPixels()),
+		"-limit", "time", fmt.Sprintf("%d", int(processTimeout.Seconds())),
+	}
+}
+
+// checkLimits rejects images that would be too expensive to decode
+// this runs before anything decodes the pixels
+func (i *ImageType) checkLimits() (err error) {
+
+	// videos and audio are never decoded here
+	if i.video || i.audio {
+		return
+	}
+
+	width, height, err := i.dimensions()
+	if err != nil {
+		return
+	}
+
+	err = checkPixels(width, height)
+	if err != nil {
+		return
+	}
+
+	switch i.mime {
+	case "image/gif":
+		return i.checkGIF()
+	case "image/png":
+		return i.checkPNG()
+	}
+
+	return
+}
+
+// checkPixels limits the total pixel count
+func checkPixels(width, height int) error {
+	if width*height > maxPixels() {
+		return fmt.Errorf("image resolution too large. Max: %dMP", maxPixels()/1000000)
+	}
+
+	return nil
+}
+
+// checkGIF limits the frame count and play time of animated gifs
+func (i *ImageType) checkGIF() (err error) {
+	data, err := i.readTemp()
+	if err != nil {
+		return errors.New("problem reading image")
+	}
+
+	frames, duration, err := gifAnimation(data)
+	if err != nil {
+		return
+	}
+
+	// used to decide on an animated preview
+	i.frames = frames
+
+	switch {
+	case frames > gifMaxFrames():
+		return fmt.Errorf("gif has too many frames. Max: %d", gifMaxFrames())
+	case duration > gifMaxDuration():
+		return fmt.Errorf("gif is too long. Max: %v", gifMaxDuration())
+	}
+
+	return
+}
+
+// checkPNG limits the size of ancillary chunks
+func (i *ImageType) checkPNG() (err error) {
+	data, err := i.readTemp()
+	if err != nil {
+		return errors.New("problem reading image")
+	}
+
+	return pngChunks(data, pngMaxChunk())
+}
+
+// gifAnimation counts the frames and adds up the frame delays
+func gifAnimation(data []byte) (frames int, duration time.Duration, err error) {
+	if len(data) < 13 {
+		return 0, 0, errors.New("invalid GIF file (too small)")
+	}
+
+	// header and logical screen descriptor
+	pos := 13
+
+	// global color table
+	if data[10]&0x80 != 0 {
+		pos += 3 << ((data[10] & 0x07) + 1)
+	}
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x3B:
+			// trailer
+			return
+
+		case 0x21:
+			if pos+2 > len(data) {
+				return 0, 0, errors.New("problem reading gif")
+			}
+
+			// graphic control extension has the frame delay in hundredths of a second
+			if data[pos+1] == 0xF9 && pos+6 <= len(data) && data[pos+2] == 4 {
+				duration += time.Duration(binary.LittleEndian.Uint16(data[pos+4:pos+6])) * 10 * time.Millisecond
+			}
+
+			pos, err = gifSubBlocks(data, pos+2)
+			if err != nil {
+				return 0, 0, errors.New("problem reading gif")
+			}
+
+		case 0x2C:
+			// image descriptor
+			if pos+10 > len(data) {
+				return 0, 0, errors.New("problem reading gif")
+			}
+
+			frames++
+
+			packed := data[pos+9]
+			pos += 10
+
+			// local color table
+			if packed&0x80 != 0 {
+				pos += 3 << ((packed & 0x07) + 1)
+			}
+
+			// lzw minimum code size
+			pos++
+
+			pos, err = gifSubBlocks(data, pos)
+			if err != nil {
+				return 0, 0, errors.New("problem reading gif")
+			}
+
+		default:
+			return 0, 0, errors.New("problem reading gif")
+		}
+	}
+
+	// a missing trailer is common and decoders accept it
+	return
+}
+
+// pngChunks walks the chunks and rejects oversized ancillary chunks
+// image data is bounded by the pixel limit instead
+func pngChunks(data []byte, limit int) error {
+	if len(data) < 8 || string(data[:8]) != "\x89PNG\r\n\x1a\n" {
+		return errors.New("invalid PNG file signature")
+	}
+
+	pos := 8
+
+	for pos+8 <= len(data) {
+		length := binary.BigEndian.Uint32(data[pos : pos+4])
+		chunkType := data[pos+4 : pos+8]
+
+		// the spec caps chunks at 2^31-1
+		if length > 0x7FFFFFFF || pos+12+int(length) > len(data) {
+			return errors.New("invalid png chunk size")
+		}
+
+		// a lowercase first letter marks an ancillary chunk
+		if chunkType[0]&0x20 != 0 && int(length) > limit {
+			return fmt.Errorf("png %s chunk too large. Max: %dKB", chunkType, limit/1024)
+		}
+
+		if string(chunkType) == "IEND" {
+			return nil
+		}
+
+		pos += 12 + int(length)
+	}
+
+	return errors.New("problem reading png")
+}
@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// testGIF makes an animated gif with the given frames and delay
+func testGIF(t *testing.T, frames, delay int) []byte {
+	t.Helper()
+
+	palette := color.Palette{color.Black, color.White}
+
+	anim := &gif.GIF{}
+
+	for range frames {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), palette))
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	var b bytes.Buffer
+	assert.NoError(t, gif.EncodeAll(&b, anim), "An error was not expected")
+
+	return b.Bytes()
+}
+
+// testPNGChunk builds a png chunk with a valid crc
+func testPNGChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+}
+
+func TestCheckPixels(t *testing.T) {
+	original := local.Settings.Uploads.MaxMegapixels
+	defer func() { local.Settings.Uploads.MaxMegapixels = original }()
+
+	local.Settings.Uploads.MaxMegapixels = 0
+
+	assert.NoError(t, checkPixels(5000, 5000), "An error was not expected")
+	assert.Error(t, checkPixels(10000, 10000), "An error was expected")
+
+	local.Settings.Uploads.MaxMegapixels = 1
+
+	assert.NoError(t, checkPixels(1000, 1000), "An error was not expected")
+	assert.EqualError(t, checkPixels(1001, 1000), "image resolution too large. Max: 1MP", "Error should match")
+}
+
+func TestGifAnimation(t *testing.T) {
+	frames, duration, err := gifAnimation(testGIF(t, 5, 10))
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, 5, frames, "Frames should match")
+		assert.Equal(t, 500*time.Millisecond, duration, "Duration should match")
+	}
+
+	frames, duration, err = gifAnimation(testGIF(t, 1, 0))
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, 1, frames, "Frames should match")
+		assert.Equal(t, time.Duration(0), duration, "Duration should match")
+	}
+
+	_, _, err = gifAnimation([]byte("GIF89a"))
+	assert.Error(t, err, "An error was expected")
+
+	data := testGIF(t, 2, 10)
+	_, _, err = gifAnimation(data[:len(data)-8])
+	assert.Error(t, err, "An error was expected")
+}
+
+func TestCheckLimitsGif(t *testing.T) {
+	frames := local.Settings.Uploads.GIFMaxFrames
+	duration := local.Settings.Uploads.GIFMaxDuration
+	defer func() {
+		local.Settings.Uploads.GIFMaxFrames = frames
+		local.Settings.Uploads.GIFMaxDuration = duration
+	}()
+
+	local.Settings.Uploads.GIFMaxFrames = 10
+	local.Settings.Uploads.GIFMaxDuration = 2
+
+	good := ImageType{mime: "image/gif"}
+	testUpload(t, &good, testGIF(t, 10, 20))
+	assert.NoError(t, good.checkLimits(), "An error was not expected")
+
+	many := ImageType{mime: "image/gif"}
+	testUpload(t, &many, testGIF(t, 11, 1))
+	assert.EqualError(t, many.checkLimits(), "gif has too many frames. Max: 10", "Error should match")
+
+	long := ImageType{mime: "image/gif"}
+	testUpload(t, &long, testGIF(t, 3, 100))
+	assert.EqualError(t, long.checkLimits(), "gif is too long. Max: 2s", "Error should match")
+}
+
+func TestCheckLimitsPng(t *testing.T) {
+	original := local.Settings.Uploads.PNGMaxChunk
+	defer func() { local.Settings.Uploads.PNGMaxChunk = original }()
+
+	local.Settings.Uploads.PNGMaxChunk = 1024
+
+	var b bytes.Buffer
+	assert.NoError(t, png.Encode(&b, testHalves(16, 16)))
+
+	good := ImageType{mime: "image/png"}
+	testUpload(t, &good, b.Bytes())
+	assert.NoError(t, good.checkLimits(), "An error was not expected")
+
+	// after the signature and the IHDR chunk
+	bloated := insertAfter(b.Bytes(), 33, testPNGChunk("zTXt", make([]byte, 2048)))
+
+	big := ImageType{mime: "image/png"}
+	testUpload(t, &big, bloated)
+	assert.EqualError(t, big.checkLimits(), "png zTXt chunk too large. Max: 1KB", "Error should match")
+
+	// a chunk claiming more data than the file has
+	broken := binary.BigEndian.AppendUint32(nil, 0xFFFFFFF0)
+	broken = append(broken, "tEXt"...)
+	assert.Error(t, pngChunks(insertAfter(b.Bytes(), 33, broken), 1024), "An error was expected")
+}
+
+func TestCheckLimitsPixels(t *testing.T) {
+	original := local.Settings.Uploads.MaxMegapixels
+	defer func() { local.Settings.Uploads.MaxMegapixels = original }()
+
+	local.Settings.Uploads.MaxMegapixels = 1
+
+	var b bytes.Buffer
+	assert.NoError(t, png.Encode(&b, image.NewGray(image.Rect(0, 0, 1200, 1000))))
+
+	img := ImageType{mime: "image/png"}
+	testUpload(t, &img, b.Bytes())
+	assert.EqualError(t, img.checkLimits(), "image resolution too large. Max: 1MP", "Error should match")
+
+	// videos are checked by ffprobe instead
+	video := ImageType{mime: "video/webm", video: true}
+	assert.NoError(t, video.checkLimits(), "An error was not expected")
+}
+
+func TestMagickLimits(t *testing.T) {
+	args := magickLimits()
+
+	assert.Equal(t, 0, len(args)%3, "Each limit should have a resource and value")
+
+	for n := 0; n < len(args); n += 3 {
+		assert.Equal(t, "-limit", args[n], "Limit flag should match")
+	}
+
+	assert.Contains(t, args, "memory", "Memory should be limited")
+	assert.Contains(t, args, "map", "Map should be limited")
+	assert.Contains(t, args, "time", "Time should be limited")
+}
@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// video conversion options
+const (
+	VideoConvertRemux = "remux"
+	VideoConvertWebM  = "webm"
+	// VideoConvertStrip copies the streams into the same container without metadata
+	VideoConvertStrip = "strip"
+)
+
+// ffmpegConvertTimeout bounds a remux or transcode
+const ffmpegConvertTimeout = 5 * time.Minute
+
+// ftyp brands used by mp4 files
+var mp4Brands = map[string]bool{
+	"isom": true,
+	"iso2": true,
+	"iso4": true,
+	"iso5": true,
+	"iso6": true,
+	"mp41": true,
+	"mp42": true,
+	"avc1": true,
+	"M4V ": true,
+	"MSNV": true,
+}
+
+// isoVideoType returns the mime type of an mp4 or mov from its ftyp box
+func isoVideoType(data []byte) string {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return ""
+	}
+
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	if size < 16 || size > len(data) {
+		return ""
+	}
+
+	// major brand then the compatible brands after the minor version
+	brands := []string{string(data[8:12])}
+	for pos := 16; pos+4 <= size; pos += 4 {
+		brands = append(brands, string(data[pos:pos+4]))
+	}
+
+	mime := ""
+
+	for _, brand := range brands {
+		switch {
+		case brand == "qt  ":
+			return "video/quicktime"
+		case mp4Brands[brand]:
+			mime = "video/mp4"
+		}
+	}
+
+	return mime
+}
+
+// convertArgs returns the ffmpeg output options, extension, and mime for a conversion
+func convertArgs(mode, source string) (args []string, ext, mime string, err error) {
+	switch mode {
+	case VideoConvertStrip:
+		if source == "video/quicktime" {
+			return []string{"-c", "copy", "-f", "mov"}, ".mov", "video/quicktime", nil
+		}
+		return []string{"-c", "copy", "-f", "mp4"}, ".mp4", "video/mp4", nil
+	case VideoConvertRemux:
+		args = []string{
+			"-c",
+			"copy",
+			"-movflags",
+			"+faststart",
+			"-f",
+			"mp4",
+		}
+		return args, ".mp4", "video/mp4", nil
+	case VideoConvertWebM:
+		args = []string{
+			"-c:v",
+			"libvpx-vp9",
+			"-b:v",
+			"0",
+			"-crf",
+			"32",
+			"-deadline",
+			"good",
+			"-cpu-used",
+			"4",
+			"-row-mt",
+			"1",
+			"-c:a",
+			"libopus",
+			"-b:a",
+			"96k",
+			"-f",
+			"webm",
+		}
+		return args, ".webm", "video/webm", nil
+	}
+
+	return nil, "", "", fmt.Errorf("unknown video conversion: %s", mode)
+}
+
+// videoConvertMode is the conversion for an upload
+// without one set the metadata is still stripped unless the board keeps originals
+func (i *ImageType) videoConvertMode() string {
+	mode := local.Settings.Uploads.VideoConvert
+	if mode != "" {
+		return mode
+	}
+
+	if slices.Contains(local.Settings.Uploads.KeepOriginal, i.Ib) {
+		return ""
+	}
+
+	return VideoConvertStrip
+}
+
+// convertVideo remuxes or transcodes an mp4 or mov depending on the config
+// the file is probed again and rehashed since the stored bytes change
+func (i *ImageType) convertVideo() (err error) {
+
+	mode := i.videoConvertMode()
+
+	// webms are always kept as is
+	if mode == "" || i.mime == "video/webm" {
+		return
+	}
+
+	outputArgs, ext, mime, err := convertArgs(mode, i.mime)
+	if err != nil {
+		return
+	}
+
+	base := strings.TrimSuffix(i.Filename, i.Ext)
+	if base == "" || base != filepath.Base(base) {
+		return errors.New("invalid filename for conversion")
+	}
+
+	tempName := base + ".convert" + ext
+	tempPath := filepath.Join(local.Settings.Directories.ImageDir, tempName)
+
+	// only the first video and audio stream, without metadata
+	ffmpegArgs := []string{
+		"-i",
+		i.Filepath,
+		"-v",
+		"quiet",
+		"-y",
+		"-map",
+		"0:v:0",
+		"-map",
+		"0:a:0?",
+		"-map_metadata",
+		"-1",
+		"-map_chapters",
+		"-1",
+	}
+	ffmpegArgs = append(ffmpegArgs, outputArgs...)
+	ffmpegArgs = append(ffmpegArgs, tempPath)
+
+	store := LocalStorage{Dir: local.Settings.Directories.ImageDir}
+
+	_, err = runMedia(ffmpegConvertTimeout, "ffmpeg", ffmpegArgs...)
+	if err != nil {
+		_ = store.Delete(tempName)
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			return
+		case errors.Is(err, errMediaTimeout):
+			return fmt.Errorf("ffmpeg conversion timed out after %v", ffmpegConvertTimeout)
+		}
+		return fmt.Errorf("problem converting %s", mediaName(i.mime))
+	}
+
+	// swap the original for the converted file
+	_ = store.Delete(i.Filename)
+
+	i.Filename = base + ext
+	i.Filepath = filepath.Join(local.Settings.Directories.ImageDir, i.Filename)
+	i.Ext = ext
+	i.mime = mime
+
+	err = os.Rename(tempPath, i.Filepath)
+	if err != nil {
+		_ = store.Delete(tempName)
+		return errors.New("problem saving converted video")
+	}
+
+	// the upload is still matched by its original bytes
+	i.SourceSHA256 = i.SHA256
+
+	// hashes have to match the bytes we store
+	err = i.hashFile()
+	if err != nil {
+		return
+	}
+
+	// the converted file has new hashes to check
+	err = i.checkBanned()
+	if err != nil {
+		return
+	}
+
+	err = i.checkDuplicate()
+	if err != nil {
+		return
+	}
+
+	// make sure the output still fits the limits
+	return i.checkWebM()
+}
+
+// hashFile sets the hashes from the saved file
+func (i *ImageType) hashFile() (err error) {
+
+	file, err := os.OpenInRoot(local.Settings.Directories.ImageDir, i.Filename)
+	if err != nil {
+		return errors.New("problem opening file")
+	}
+	defer file.Close()
+
+	hasher := newFileHasher()
+
+	_, err = io.Copy(hasher.writer(), file)
+	if err != nil {
+		return errors.New("problem creating file hash")
+	}
+
+	hasher.set(i)
+
+	return
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/config"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// mockMP4FFProbeData is a phone video with a timecode track
+func mockMP4FFProbeData() ffprobe {
+	f := mockGoodFFProbeData()
+	f.Format.FormatName = "mov,mp4,m4a,3gp,3g2,mj2"
+	f.Streams[0].CodecName = "h264"
+	f.Streams[1].CodecName = "aac"
+	f.Streams = append(f.Streams, ffprobeStream{Index: 2, CodecType: "data", CodecTagString: "tmcd"})
+	return f
+}
+
+func TestIsoVideoType(t *testing.T) {
+	mp4 := testBox("ftyp", []byte("isom"), []byte{0, 0, 2, 0}, []byte("isomiso2avc1mp41"))
+	assert.Equal(t, "video/mp4", isoVideoType(mp4), "Mime should match")
+
+	mov := testBox("ftyp", []byte("qt  "), []byte{0, 0, 2, 0}, []byte("qt  "))
+	assert.Equal(t, "video/quicktime", isoVideoType(mov), "Mime should match")
+
+	assert.Equal(t, "", isoVideoType(testAVIF("avif")), "Avif should not match")
+	assert.Equal(t, "", isoVideoType(testJpeg(10).Bytes()), "Jpeg should not match")
+
+	assert.Equal(t, "video/quicktime", detectContentType(mov), "Mime should match")
+	assert.Equal(t, "image/avif", detectContentType(testAVIF("avif")), "Avif should still be detected")
+}
+
+func TestCheckMagicMP4(t *testing.T) {
+	mov := testBox("ftyp", []byte("qt  "), []byte{0, 0, 2, 0}, []byte("qt  "))
+	mov = append(mov, testBox("mdat", make([]byte, 100))...)
+
+	img := ImageType{
+		Ext: ".mov",
+	}
+
+	testUpload(t, &img, mov)
+
+	err := img.checkMagic()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, "video/quicktime", img.mime, "Mime should match")
+		assert.True(t, img.video, "Mov should be a video")
+	}
+
+	wrong := ImageType{
+		Ext: ".mp4",
+	}
+
+	testUpload(t, &wrong, mov)
+
+	err = wrong.checkMagic()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "file extension doesn't match content type", err.Error(), "Error should match")
+	}
+}
+
+func TestValidateVideoMP4(t *testing.T) {
+	config.Settings.Limits.ImageMaxWidth = 1920
+	config.Settings.Limits.ImageMinWidth = 100
+	config.Settings.Limits.ImageMaxHeight = 1920
+	config.Settings.Limits.ImageMinHeight = 100
+	config.Settings.Limits.ImageMaxSize = 10000000
+	config.Settings.Limits.WebmMaxLength = 30
+
+	img := ImageType{mime: "video/mp4"}
+
+	err := img.validateVideo(mockMP4FFProbeData())
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, 1280, img.OrigWidth, "Width should match")
+		assert.Equal(t, 10, img.duration, "Duration should match")
+	}
+
+	// portrait phone videos are stored sideways
+	data := mockMP4FFProbeData()
+	assert.NoError(t, json.Unmarshal([]byte(`[{"rotation":-90}]`), &data.Streams[0].SideDataList))
+
+	err = img.validateVideo(data)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, 720, img.OrigWidth, "Width should be swapped")
+		assert.Equal(t, 1280, img.OrigHeight, "Height should be swapped")
+	}
+
+	data = mockMP4FFProbeData()
+	data.Streams[0].CodecName = "vp9"
+
+	err = img.validateVideo(data)
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "video codec 'vp9' is not allowed, must be H.264 or H.265", err.Error(), "Error should match")
+	}
+
+	data = mockMP4FFProbeData()
+	data.Streams[1].CodecName = "mp3"
+
+	err = img.validateVideo(data)
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "audio codec 'mp3' is not allowed, must be AAC", err.Error(), "Error should match")
+	}
+
+	data = mockMP4FFProbeData()
+	data.Format.Duration = "45"
+
+	err = img.validateVideo(data)
+	if assert.Error(t, err, "An error was expected") {
+		assert.Contains(t, err.Error(), "mp4 duration 45 sec is too long", "Error should match")
+	}
+
+	// the container has to match the detected type
+	img.mime = "video/webm"
+
+	err = img.validateVideo(mockMP4FFProbeData())
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "file is not a webm", err.Error(), "Error should match")
+	}
+}
+
+func TestConvertVideo(t *testing.T) {
+	original := local.Settings.Uploads.VideoConvert
+	defer func() { local.Settings.Uploads.VideoConvert = original }()
+
+	img := ImageType{
+		Filename: "test.mp4",
+		Ext:      ".mp4",
+		mime:     "video/mp4",
+	}
+
+	// metadata is stripped without a conversion set
+	local.Settings.Uploads.VideoConvert = ""
+	assert.Equal(t, VideoConvertStrip, img.videoConvertMode(), "Mode should match")
+
+	// boards that keep originals are left alone
+	originalKeep := local.Settings.Uploads.KeepOriginal
+	defer func() { local.Settings.Uploads.KeepOriginal = originalKeep }()
+
+	local.Settings.Uploads.KeepOriginal = []uint{2}
+
+	keep := ImageType{Ib: 2, Filename: "test.mp4", Ext: ".mp4", mime: "video/mp4"}
+	assert.Equal(t, "", keep.videoConvertMode(), "Mode should match")
+	assert.NoError(t, keep.convertVideo(), "An error was not expected")
+	assert.Equal(t, "test.mp4", keep.Filename, "Filename should not change")
+
+	// a set conversion is used everywhere
+	local.Settings.Uploads.VideoConvert = VideoConvertRemux
+	assert.Equal(t, VideoConvertRemux, keep.videoConvertMode(), "Mode should match")
+
+	// webms are never converted
+	local.Settings.Uploads.VideoConvert = VideoConvertWebM
+	webm := ImageType{Filename: "test.webm", Ext: ".webm", mime: "video/webm"}
+	assert.NoError(t, webm.convertVideo(), "An error was not expected")
+
+	local.Settings.Uploads.VideoConvert = "avi"
+
+	err := img.convertVideo()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "unknown video conversion: avi", err.Error(), "Error should match")
+	}
+
+	_, ext, mime, err := convertArgs(VideoConvertWebM, "video/mp4")
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, ".webm", ext, "Ext should match")
+		assert.Equal(t, "video/webm", mime, "Mime should match")
+	}
+
+	// stripping keeps the container
+	args, ext, mime, err := convertArgs(VideoConvertStrip, "video/quicktime")
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, []string{"-c", "copy", "-f", "mov"}, args, "Args should match")
+		assert.Equal(t, ".mov", ext, "Ext should match")
+		assert.Equal(t, "video/quicktime", mime, "Mime should match")
+	}
+
+	_, ext, mime, err = convertArgs(VideoConvertStrip, "video/mp4")
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, ".mp4", ext, "Ext should match")
+		assert.Equal(t, "video/mp4", mime, "Mime should match")
+	}
+}
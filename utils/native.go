@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// NativeProcessor creates thumbnails in pure Go without external binaries
+type NativeProcessor struct{}
+
+var _ = ImageProcessor(&NativeProcessor{})
+
+// Name returns the backend name
+func (p *NativeProcessor) Name() string {
+	return ProcessorNative
+}
+
+// Check always succeeds since there is nothing external to find
+func (p *NativeProcessor) Check() (err error) {
+	return
+}
+
+// Thumbnail decodes the first frame of src, resizes it, and encodes it to dst
+func (p *NativeProcessor) Thumbnail(src, dst string, opts ThumbnailOptions) (err error) {
+
+	if opts.MaxWidth <= 0 || opts.MaxHeight <= 0 {
+		return errors.New("invalid thumbnail size")
+	}
+
+	// decoding and resizing is as heavy as running convert
+	return mediaPool.Do(func() error {
+		return nativeThumbnail(src, dst, opts)
+	})
+}
+
+// nativeThumbnail decodes, resizes, and encodes the thumbnail
+func nativeThumbnail(src, dst string, opts ThumbnailOptions) (err error) {
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return errors.New("problem opening image")
+	}
+
+	img, err := decodeFrame(data)
+	if err != nil {
+		return errors.New("problem decoding image")
+	}
+
+	var thumb image.Image
+
+	if opts.Crop {
+		thumb = cropImage(img, opts.MaxWidth, opts.MaxHeight)
+	} else {
+		thumb = resizeImage(img, opts.MaxWidth, opts.MaxHeight)
+	}
+
+	// Open the destination dir with traversal-resistant root
+	root, err := os.OpenRoot(filepath.Dir(dst))
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer root.Close()
+
+	out, err := root.Create(filepath.Base(dst))
+	if err != nil {
+		return errors.New("problem creating thumbnail file")
+	}
+	defer out.Close()
+
+	// the output format follows the thumbnail extension
+	if strings.ToLower(filepath.Ext(dst)) == ".png" {
+		err = png.Encode(out, thumb)
+	} else {
+		err = jpeg.Encode(out, thumb, &jpeg.Options{Quality: opts.Quality})
+	}
+	if err != nil {
+		return errors.New("problem making thumbnail")
+	}
+
+	return
+}
+
+// fitDimensions scales width and height down to fit in the box, never up
+func fitDimensions(width, height, maxwidth, maxheight int) (int, int) {
+	if width <= maxwidth && height <= maxheight {
+		return width, height
+	}
+
+	scale := min(float64(maxwidth)/float64(width), float64(maxheight)/float64(height))
+
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
+
+// resizeImage shrinks the image to fit the box and flattens it onto white
+func resizeImage(img image.Image, maxwidth, maxheight int) image.Image {
+	bounds := img.Bounds()
+
+	width, height := fitDimensions(bounds.Dx(), bounds.Dy(), maxwidth, maxheight)
+
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// white background for transparent images
+	draw.Draw(thumb, thumb.Bounds(), image.White, image.Point{}, draw.Src)
+
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Over, nil)
+
+	return thumb
+}
+
+// decodeFrame decodes the first frame of an image
+// gifs are handled by the decoder, animated webps are rewritten to their first frame
+func decodeFrame(data []byte) (img image.Image, err error) {
+	data, err = webpFirstFrame(data)
+	if err != nil {
+		return
+	}
+
+	img, _, err = image.Decode(bytes.NewReader(data))
+	return
+}
+
+// cropImage fills the box with the center of the image keeping transparency
+func cropImage(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+
+	// find the largest centered region with the same aspect as the box
+	crop := bounds
+	if bounds.Dx()*height > bounds.Dy()*width {
+		w := bounds.Dy() * width / height
+		crop.Min.X += (bounds.Dx() - w) / 2
+		crop.Max.X = crop.Min.X + w
+	} else {
+		h := bounds.Dx() * height / width
+		crop.Min.Y += (bounds.Dy() - h) / 2
+		crop.Max.Y = crop.Min.Y + h
+	}
+
+	thumb := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, crop, draw.Src, nil)
+
+	return thumb
+}
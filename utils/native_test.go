@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFitDimensions(t *testing.T) {
+	testCases := []struct {
+		width, height       int
+		maxwidth, maxheight int
+		expectW, expectH    int
+	}{
+		{100, 100, 200, 300, 100, 100}, // never enlarged
+		{400, 400, 200, 300, 200, 200},
+		{1000, 500, 200, 300, 200, 100},
+		{500, 1000, 200, 300, 150, 300},
+		{10000, 1, 200, 300, 200, 1}, // never zero
+	}
+
+	for _, tc := range testCases {
+		w, h := fitDimensions(tc.width, tc.height, tc.maxwidth, tc.maxheight)
+		assert.Equal(t, tc.expectW, w, "Width should match")
+		assert.Equal(t, tc.expectH, h, "Height should match")
+	}
+}
+
+func TestNativeThumbnail(t *testing.T) {
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "source.png")
+	dst := filepath.Join(dir, "sources.jpg")
+
+	err := os.WriteFile(src, testPng(400).Bytes(), 0644)
+	assert.NoError(t, err, "An error was not expected")
+
+	processor := &NativeProcessor{}
+
+	err = processor.Thumbnail(src, dst, ThumbnailOptions{MaxWidth: 200, MaxHeight: 300, Quality: 90})
+	if assert.NoError(t, err, "An error was not expected") {
+		file, err := os.Open(dst)
+		assert.NoError(t, err, "Thumbnail should exist")
+		defer file.Close()
+
+		img, format, err := image.DecodeConfig(file)
+		assert.NoError(t, err, "Thumbnail should decode")
+		assert.Equal(t, "jpeg", format, "Thumbnail should be a jpeg")
+		assert.Equal(t, 200, img.Width, "Width should match")
+		assert.Equal(t, 200, img.Height, "Height should match")
+	}
+}
+
+func TestNativeThumbnailFlatten(t *testing.T) {
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "clear.png")
+	dst := filepath.Join(dir, "clears.jpg")
+
+	// a fully transparent image should come out white
+	clear := image.NewNRGBA(image.Rect(0, 0, 50, 50))
+	file, err := os.Create(src)
+	assert.NoError(t, err, "An error was not expected")
+	png.Encode(file, clear)
+	file.Close()
+
+	processor := &NativeProcessor{}
+
+	err = processor.Thumbnail(src, dst, ThumbnailOptions{MaxWidth: 200, MaxHeight: 300, Quality: 90})
+	if assert.NoError(t, err, "An error was not expected") {
+		file, err := os.Open(dst)
+		assert.NoError(t, err, "Thumbnail should exist")
+		defer file.Close()
+
+		img, _, err := image.Decode(file)
+		assert.NoError(t, err, "Thumbnail should decode")
+
+		r, g, b, _ := img.At(25, 25).RGBA()
+		assert.True(t, r > 0xf000 && g > 0xf000 && b > 0xf000, "Background should be white")
+	}
+}
+
+func TestNativeThumbnailCrop(t *testing.T) {
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "wide.png")
+	dst := filepath.Join(dir, "avatar.png")
+
+	// a wide image with a red center
+	wide := image.NewNRGBA(image.Rect(0, 0, 300, 100))
+	for x := 100; x < 200; x++ {
+		for y := range 100 {
+			wide.Set(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+	file, err := os.Create(src)
+	assert.NoError(t, err, "An error was not expected")
+	png.Encode(file, wide)
+	file.Close()
+
+	processor := &NativeProcessor{}
+
+	err = processor.Thumbnail(src, dst, ThumbnailOptions{MaxWidth: 128, MaxHeight: 128, Crop: true})
+	if assert.NoError(t, err, "An error was not expected") {
+		file, err := os.Open(dst)
+		assert.NoError(t, err, "Thumbnail should exist")
+		defer file.Close()
+
+		img, format, err := image.Decode(file)
+		assert.NoError(t, err, "Thumbnail should decode")
+		assert.Equal(t, "png", format, "Avatar should be a png")
+		assert.Equal(t, 128, img.Bounds().Dx(), "Width should match")
+		assert.Equal(t, 128, img.Bounds().Dy(), "Height should match")
+
+		// the corners should come from the red center
+		_, _, _, a := img.At(0, 0).RGBA()
+		assert.NotZero(t, a, "Corner should not be transparent")
+		r, g, _, _ := img.At(0, 0).RGBA()
+		assert.True(t, r > 0xf000 && g < 0x1000, "Corner should be red")
+	}
+}
+
+func TestNativeThumbnailBadSource(t *testing.T) {
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "bad.png")
+
+	err := os.WriteFile(src, testRandom(), 0644)
+	assert.NoError(t, err, "An error was not expected")
+
+	processor := &NativeProcessor{}
+
+	err = processor.Thumbnail(src, filepath.Join(dir, "bads.jpg"), ThumbnailOptions{MaxWidth: 200, MaxHeight: 300, Quality: 90})
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "problem decoding image", err.Error(), "Error should match")
+	}
+
+	err = processor.Thumbnail(filepath.Join(dir, "missing.png"), filepath.Join(dir, "missings.jpg"), ThumbnailOptions{MaxWidth: 200, MaxHeight: 300})
+	assert.Error(t, err, "An error was expected")
+}
+
+func TestNewImageProcessor(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"", defaultImageProcessor()},
+		{ProcessorImageMagick, ProcessorImageMagick},
+		{ProcessorNative, ProcessorNative},
+	}
+
+	for _, tc := range testCases {
+		processor, err := NewImageProcessor(tc.name)
+		if assert.NoError(t, err, "An error was not expected") {
+			assert.Equal(t, tc.expected, processor.Name(), "Processor should match")
+		}
+	}
+
+	_, err := NewImageProcessor("gimp")
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, "unknown image processor: gimp", err.Error(), "Error should match")
+	}
+
+	assert.NoError(t, (&NativeProcessor{}).Check(), "Native processor needs no binaries")
+}
+
+func TestImageTypeProcessor(t *testing.T) {
+	img := ImageType{Processor: &ImageMagickProcessor{}}
+
+	processor, err := img.processor()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, ProcessorImageMagick, processor.Name(), "Explicit processor should be used")
+	}
+}
+
+func TestRefreshHealth(t *testing.T) {
+	// without any tools on the path the check fails
+	t.Setenv("PATH", "")
+
+	err := RefreshHealth()
+	assert.Error(t, err, "An error was expected")
+	assert.Equal(t, err, Health(), "The stored result should be reported")
+}
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"github.com/eirka/eirka-libs/db"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// phashBands are the slices of the hash stored in indexed columns
+// two hashes that differ in four bits or less have at least one slice in common
+var phashBands = []struct {
+	shift uint
+	mask  uint64
+}{
+	{51, 0x1FFF},
+	{38, 0x1FFF},
+	{25, 0x1FFF},
+	{12, 0x1FFF},
+	{0, 0xFFF},
+}
+
+// hamming distances for a similar file
+const (
+	// maxPHashDistance is the most the bands can find without scanning every hash
+	maxPHashDistance = 4
+	// defaultPHashDistance is used when the config leaves it out
+	defaultPHashDistance = 4
+)
+
+// phashDistance is the max hamming distance for a similar file
+func phashDistance() int {
+	distance := local.Settings.Uploads.PHashDistance
+	if distance == nil || *distance < 0 {
+		return defaultPHashDistance
+	}
+	return min(*distance, maxPHashDistance)
+}
+
+// phashBandClause matches the rows that share a band with the hash
+// the band indexes narrow the rows before the distance is counted
+func phashBandClause(column string, phash int64) (clause string, args []any) {
+	var bands []string
+
+	for n, band := range phashBands {
+		bands = append(bands, fmt.Sprintf("%s_band%d = ?", column, n))
+		args = append(args, (uint64(phash)>>band.shift)&band.mask)
+	}
+
+	return "(" + strings.Join(bands, " OR ") + ")", args
+}
+
+// dHash computes a 64 bit difference hash of an image
+// the image is shrunk to 9x8 grayscale and each bit records
+// if a pixel is brighter than its right neighbor
+func dHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+
+	// flatten transparency onto white so it hashes the same as the thumbnail
+	draw.Draw(small, small.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(small, small.Bounds(), img, img.Bounds(), draw.Over, nil)
+
+	var hash uint64
+
+	for y := range 8 {
+		for x := range 8 {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// hammingDistance counts the differing bits between two hashes
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// getPHash computes the perceptual hash of the image or the webm thumbnail frame
+func (i *ImageType) getPHash() (err error) {
+
+	var img image.Image
+
+	if i.needsFrame() {
+		// use the frame extracted by ffmpeg
+		thumb, err := os.OpenInRoot(local.Settings.Directories.ThumbnailDir, i.Thumbnail)
+		if err != nil {
+			return errors.New("problem opening thumbnail")
+		}
+		defer thumb.Close()
+
+		img, _, err = image.Decode(thumb)
+		if err != nil {
+			return errors.New("problem decoding thumbnail")
+		}
+	} else {
+		data, err := i.readTemp()
+		if err != nil {
+			return errors.New("no image data to hash")
+		}
+
+		img, err = decodeFrame(data)
+		if err != nil {
+			return errors.New("problem decoding image")
+		}
+	}
+
+	// stored as a signed bigint, mysql compares the bits the same
+	i.PHash = sql.NullInt64{Int64: int64(dHash(img)), Valid: true}
+
+	return
+}
+
+// checkSimilar checks the perceptual hash against banned files and existing images
+func (i *ImageType) checkSimilar() (err error) {
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	if i.Ib == 0 {
+		return errors.New("no imageboard set on similar check")
+	}
+
+	// files without a hash would match every other unhashed file
+	if !i.PHash.Valid {
+		return
+	}
+
+	distance := phashDistance()
+
+	var check bool
+
+	clause, args := phashBandClause("ban_phash", i.PHash.Int64)
+
+	err = dbase.QueryRow(`SELECT count(*) FROM banned_files
+	WHERE `+clause+` AND BIT_COUNT(ban_phash ^ ?) <= ?`, append(args, i.PHash.Int64, distance)...).Scan(&check)
+	if err != nil {
+		return
+	}
+
+	// return error if it looks like a banned file
+	if check {
+		return fmt.Errorf("file is banned")
+	}
+
+	var thread, post sql.NullInt64
+
+	clause, args = phashBandClause("image_phash", i.PHash.Int64)
+
+	err = dbase.QueryRow(`select count(1),posts.post_num,threads.thread_id from threads
+	LEFT JOIN posts on threads.thread_id = posts.thread_id
+	LEFT JOIN images on posts.post_id = images.post_id
+	WHERE image_phash IS NOT NULL AND `+clause+` AND BIT_COUNT(image_phash ^ ?) <= ? AND ib_id = ? AND post_deleted = 0 AND image_deleted = 0`,
+		append(args, i.PHash.Int64, distance, i.Ib)...).Scan(&check, &post, &thread)
+	if err != nil {
+		return
+	}
+
+	// return error if a similar image exists
+	if check {
+		return fmt.Errorf("a similar image has already been posted. Thread: %d Post: %d", thread.Int64, post.Int64)
+	}
+
+	return
+}
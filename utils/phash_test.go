@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/db"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// testPattern makes an image with some large scale structure to hash
+func testPattern(width, height int, flip bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for x := range width {
+		for y := range height {
+			v := uint8((x * 255 / width) ^ (y * 255 / height))
+			if (x*4/width+y*4/height)%2 == 0 {
+				v = 255 - v/2
+			}
+			if flip {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+
+	return img
+}
+
+func TestHammingDistance(t *testing.T) {
+	assert.Equal(t, 0, hammingDistance(0, 0), "Distance should match")
+	assert.Equal(t, 64, hammingDistance(0, ^uint64(0)), "Distance should match")
+	assert.Equal(t, 2, hammingDistance(0b1010, 0b0000), "Distance should match")
+}
+
+func TestDHashSimilar(t *testing.T) {
+	original := testPattern(400, 300, false)
+
+	// shrink and reencode the image like someone dodging a ban would
+	resized := resizeImage(original, 397, 297)
+
+	var b bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&b, resized, &jpeg.Options{Quality: 70}))
+
+	reencoded, _, err := image.Decode(&b)
+	assert.NoError(t, err, "An error was not expected")
+
+	distance := hammingDistance(dHash(original), dHash(reencoded))
+	assert.LessOrEqual(t, distance, 4, "Altered image should be within the distance")
+
+	// a different image should be far away
+	different := testPattern(400, 300, true)
+
+	distance = hammingDistance(dHash(original), dHash(different))
+	assert.Greater(t, distance, 16, "Different image should not match")
+}
+
+func TestGetPHash(t *testing.T) {
+	img := ImageType{}
+
+	var b bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&b, testPattern(200, 200, false), nil))
+	testUpload(t, &img, b.Bytes())
+
+	err := img.getPHash()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.NotZero(t, img.PHash, "Hash should be set")
+	}
+
+	testUpload(t, &img, testRandom())
+
+	err = img.getPHash()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, errors.New("problem decoding image"), err, "Error should match")
+	}
+}
+
+func TestCheckSimilar(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	original := local.Settings.Uploads.PHashDistance
+	defer func() { local.Settings.Uploads.PHashDistance = original }()
+
+	local.Settings.Uploads.PHashDistance = nil
+
+	img := ImageType{
+		Ib:    1,
+		PHash: sql.NullInt64{Int64: 1234, Valid: true},
+	}
+
+	noban := sqlmock.NewRows([]string{"count"}).AddRow(0)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files`).WithArgs(0, 0, 0, 0, 1234, 1234, 4).WillReturnRows(noban)
+	nomatch := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(0, 0, 0)
+	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).WithArgs(0, 0, 0, 0, 1234, 1234, 4, 1).WillReturnRows(nomatch)
+
+	err = img.checkSimilar()
+	assert.NoError(t, err, "An error was not expected")
+
+	ban := sqlmock.NewRows([]string{"count"}).AddRow(1)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files`).WillReturnRows(ban)
+
+	err = img.checkSimilar()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, errors.New("file is banned"), err, "Error should match")
+	}
+
+	noban = sqlmock.NewRows([]string{"count"}).AddRow(0)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files`).WillReturnRows(noban)
+	match := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(1, 10, 2)
+	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads`).WillReturnRows(match)
+
+	err = img.checkSimilar()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, errors.New("a similar image has already been posted. Thread: 2 Post: 10"), err, "Error should match")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+
+	img.Ib = 0
+
+	err = img.checkSimilar()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Equal(t, errors.New("no imageboard set on similar check"), err, "Error should match")
+	}
+}
+
+func TestPHashDistance(t *testing.T) {
+	original := local.Settings.Uploads.PHashDistance
+	defer func() { local.Settings.Uploads.PHashDistance = original }()
+
+	local.Settings.Uploads.PHashDistance = nil
+	assert.Equal(t, defaultPHashDistance, phashDistance(), "Empty config should use the default")
+
+	exact := 0
+	local.Settings.Uploads.PHashDistance = &exact
+	assert.Equal(t, 0, phashDistance(), "Zero should only match identical hashes")
+
+	distance := 3
+	local.Settings.Uploads.PHashDistance = &distance
+	assert.Equal(t, 3, phashDistance(), "Distance should match")
+
+	wide := 8
+	local.Settings.Uploads.PHashDistance = &wide
+	assert.Equal(t, maxPHashDistance, phashDistance(), "Distance should be capped at what the bands can find")
+}
+
+func TestPHashBandClause(t *testing.T) {
+	clause, args := phashBandClause("image_phash", 1234)
+
+	assert.Equal(t, "(image_phash_band0 = ? OR image_phash_band1 = ? OR image_phash_band2 = ? OR image_phash_band3 = ? OR image_phash_band4 = ?)", clause, "Clause should match")
+	assert.Equal(t, []any{uint64(0), uint64(0), uint64(0), uint64(0), uint64(1234)}, args, "Bands should match")
+
+	// any hash within the max distance shares a band with the original
+	hash := int64(-0x0123456789ABCDEF)
+
+	for _, flips := range [][]uint{{0}, {63}, {1, 20, 40, 60}, {12, 13, 25, 26}, {50, 51, 52, 53}} {
+		similar := hash
+		for _, bit := range flips {
+			similar ^= 1 << bit
+		}
+
+		_, original := phashBandClause("image_phash", hash)
+		_, other := phashBandClause("image_phash", similar)
+
+		shared := false
+		for n := range original {
+			if original[n] == other[n] {
+				shared = true
+			}
+		}
+		assert.True(t, shared, "Similar hashes should share a band")
+	}
+}
+
+func TestCheckSimilarAudioThenSolid(t *testing.T) {
+
+	var err error
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	original := local.Settings.Uploads.PHashDistance
+	defer func() { local.Settings.Uploads.PHashDistance = original }()
+
+	local.Settings.Uploads.PHashDistance = nil
+
+	// audio is never hashed so it has nothing to compare
+	audio := ImageType{
+		Ib:    1,
+		audio: true,
+	}
+
+	err = audio.checkSimilar()
+	assert.NoError(t, err, "An error was not expected")
+	assert.False(t, audio.PHash.Valid, "Audio should not have a hash")
+
+	// a flat image hashes to zero
+	solid := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	for y := range 200 {
+		for x := range 200 {
+			solid.Set(x, y, color.RGBA{200, 50, 50, 255})
+		}
+	}
+
+	img := ImageType{
+		Ib: 1,
+	}
+
+	var b bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&b, solid, nil))
+	testUpload(t, &img, b.Bytes())
+
+	err = img.getPHash()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.True(t, img.PHash.Valid, "Hash should be set")
+		assert.Zero(t, img.PHash.Int64, "Solid image should hash to zero")
+	}
+
+	// the audio rows without a hash are left out of the search
+	noban := sqlmock.NewRows([]string{"count"}).AddRow(0)
+	mock.ExpectQuery(`SELECT count\(\*\) FROM banned_files`).WithArgs(0, 0, 0, 0, 0, 0, 4).WillReturnRows(noban)
+	nomatch := sqlmock.NewRows([]string{"count", "post", "thread"}).AddRow(0, 0, 0)
+	mock.ExpectQuery(`select count\(1\),posts.post_num,threads.thread_id from threads.*WHERE image_phash IS NOT NULL AND`).WithArgs(0, 0, 0, 0, 0, 0, 4, 1).WillReturnRows(nomatch)
+
+	err = img.checkSimilar()
+	assert.NoError(t, err, "An error was not expected")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
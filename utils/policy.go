@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/eirka/eirka-libs/config"
+	"github.com/eirka/eirka-libs/db"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// defaultMaxFiles keeps posts to one file when nothing is configured
+const defaultMaxFiles = 1
+
+// UploadPolicy limits what a board accepts, zero values fall back to the global limits
+type UploadPolicy struct {
+	// Formats are the allowed extensions, empty allows every supported format
+	Formats     []string
+	MaxSize     int
+	MaxWidth    int
+	MaxHeight   int
+	VideoLength int
+	Audio       bool
+	// MaxFiles is how many files a post can have
+	MaxFiles int
+	// fallback is set when the board has no policy row
+	fallback bool
+}
+
+// DefaultPolicy is used for boards without a policy, it keeps the global limits and has no audio
+var DefaultPolicy = UploadPolicy{fallback: true}
+
+// GetUploadPolicy loads the upload policy for a board
+func GetUploadPolicy(ib uint) (policy UploadPolicy, err error) {
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	var formats string
+
+	err = dbase.QueryRow(`SELECT policy_formats,policy_max_size,policy_max_width,policy_max_height,policy_video_length,policy_audio,policy_max_files
+	FROM upload_policies WHERE ib_id = ?`, ib).Scan(&formats, &policy.MaxSize, &policy.MaxWidth, &policy.MaxHeight, &policy.VideoLength, &policy.Audio, &policy.MaxFiles)
+	if err == sql.ErrNoRows {
+		return DefaultPolicy, nil
+	} else if err != nil {
+		return
+	}
+
+	for _, format := range strings.Split(formats, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(format, ".") {
+			format = "." + format
+		}
+
+		policy.Formats = append(policy.Formats, format)
+	}
+
+	return
+}
+
+// GetMaxFiles is how many files a post on the board can have
+func GetMaxFiles(ib uint) (limit int, err error) {
+
+	policy, err := GetUploadPolicy(ib)
+	if err != nil {
+		return
+	}
+
+	if policy.MaxFiles > 0 {
+		return policy.MaxFiles, nil
+	}
+
+	if local.Settings.Uploads.MaxFiles > 0 {
+		return local.Settings.Uploads.MaxFiles, nil
+	}
+
+	return defaultMaxFiles, nil
+}
+
+// loadPolicy sets the policy for the board being posted to
+func (i *ImageType) loadPolicy() (err error) {
+
+	// avatars use the uid as the ib
+	if i.avatar || i.Ib == 0 {
+		return
+	}
+
+	policy, err := GetUploadPolicy(i.Ib)
+	if err != nil {
+		return errors.New("problem loading upload policy")
+	}
+
+	i.policy = &policy
+
+	return
+}
+
+// allowsExt checks the extension against the board formats
+func (i *ImageType) allowsExt(ext string) bool {
+	return i.policy == nil || len(i.policy.Formats) == 0 || slices.Contains(i.policy.Formats, strings.ToLower(ext))
+}
+
+// allowsAudio checks if the board takes audio uploads, audio is off unless a board turns it on
+// a policy row decides for its board and the config list is only used for boards without one
+func (i *ImageType) allowsAudio() bool {
+	if i.avatar {
+		return false
+	}
+
+	if i.policy != nil && !i.policy.fallback {
+		return i.policy.Audio
+	}
+
+	return slices.Contains(local.Settings.Uploads.AudioBoards, i.Ib)
+}
+
+// maxSize is the largest file the board accepts
+func (i *ImageType) maxSize() int {
+	if i.policy != nil && i.policy.MaxSize > 0 {
+		return i.policy.MaxSize
+	}
+	return config.Settings.Limits.ImageMaxSize
+}
+
+// maxWidth is the widest file the board accepts
+func (i *ImageType) maxWidth() int {
+	if i.policy != nil && i.policy.MaxWidth > 0 {
+		return i.policy.MaxWidth
+	}
+	return config.Settings.Limits.ImageMaxWidth
+}
+
+// maxHeight is the tallest file the board accepts
+func (i *ImageType) maxHeight() int {
+	if i.policy != nil && i.policy.MaxHeight > 0 {
+		return i.policy.MaxHeight
+	}
+	return config.Settings.Limits.ImageMaxHeight
+}
+
+// maxVideoLength is the longest video the board accepts in seconds
+func (i *ImageType) maxVideoLength() int {
+	if i.policy != nil && i.policy.VideoLength > 0 {
+		return i.policy.VideoLength
+	}
+	return config.Settings.Limits.WebmMaxLength
+}
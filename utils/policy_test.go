@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"mime/multipart"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/eirka/eirka-libs/config"
+	"github.com/eirka/eirka-libs/db"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+func TestGetUploadPolicy(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	rows := sqlmock.NewRows([]string{"formats", "size", "width", "height", "length", "audio", "files"}).
+		AddRow("mp3, .FLAC,,ogg", 20000000, 0, 0, 0, true, 4)
+
+	mock.ExpectQuery(`SELECT policy_formats,policy_max_size,policy_max_width,policy_max_height,policy_video_length,policy_audio,policy_max_files FROM upload_policies WHERE ib_id`).
+		WithArgs(2).
+		WillReturnRows(rows)
+
+	policy, err := GetUploadPolicy(2)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, []string{".mp3", ".flac", ".ogg"}, policy.Formats, "Formats should match")
+		assert.Equal(t, 20000000, policy.MaxSize, "Max size should match")
+		assert.True(t, policy.Audio, "Audio should be allowed")
+		assert.Equal(t, 4, policy.MaxFiles, "Max files should match")
+	}
+
+	mock.ExpectQuery(`SELECT policy_formats`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"formats", "size", "width", "height", "length", "audio", "files"}))
+
+	policy, err = GetUploadPolicy(3)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, DefaultPolicy, policy, "Boards without a policy should get the default")
+		assert.False(t, policy.Audio, "Boards without a policy should not take audio")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestLoadPolicy(t *testing.T) {
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	// avatars and missing boards never query
+	avatar := ImageType{Ib: 2, avatar: true}
+	assert.NoError(t, avatar.loadPolicy(), "An error was not expected")
+	assert.Nil(t, avatar.policy, "Avatars should not have a policy")
+
+	rows := sqlmock.NewRows([]string{"formats", "size", "width", "height", "length", "audio", "files"}).
+		AddRow("", 0, 500, 400, 10, false, 0)
+
+	mock.ExpectQuery(`SELECT policy_formats`).
+		WithArgs(2).
+		WillReturnRows(rows)
+
+	img := ImageType{Ib: 2}
+	if assert.NoError(t, img.loadPolicy(), "An error was not expected") && assert.NotNil(t, img.policy) {
+		assert.Equal(t, 500, img.maxWidth(), "Width should come from the policy")
+		assert.Equal(t, 400, img.maxHeight(), "Height should come from the policy")
+		assert.Equal(t, 10, img.maxVideoLength(), "Length should come from the policy")
+		assert.Equal(t, config.Settings.Limits.ImageMaxSize, img.maxSize(), "Size should fall back to the global limit")
+		assert.False(t, img.allowsAudio(), "Audio should not be allowed")
+		assert.True(t, img.allowsExt(".png"), "Every format should be allowed")
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
+
+func TestPolicyFormats(t *testing.T) {
+	img := ImageType{
+		Header: &multipart.FileHeader{Filename: "test.png"},
+		policy: &UploadPolicy{Formats: []string{".mp3", ".flac"}, Audio: true},
+	}
+
+	assert.EqualError(t, img.checkReqExt(), "format not allowed on this board", "Error should match")
+
+	img.Header.Filename = "test.MP3"
+	assert.NoError(t, img.checkReqExt(), "An error was not expected")
+
+	// no policy allows everything
+	open := ImageType{Header: &multipart.FileHeader{Filename: "test.png"}}
+	assert.NoError(t, open.checkReqExt(), "An error was not expected")
+}
+
+func TestPolicyAudio(t *testing.T) {
+	img := ImageType{
+		Ext:    ".mp3",
+		size:   4096,
+		header: []byte("ID3\x04\x00\x00\x00\x00"),
+		policy: &UploadPolicy{},
+	}
+
+	assert.EqualError(t, img.checkMagic(), "audio is not allowed on this board", "Error should match")
+
+	img.policy.Audio = true
+	assert.NoError(t, img.checkMagic(), "An error was not expected")
+
+	original := local.Settings.Uploads.AudioBoards
+	defer func() {
+		local.Settings.Uploads.AudioBoards = original
+	}()
+
+	local.Settings.Uploads.AudioBoards = []uint{3}
+
+	fallback := DefaultPolicy
+
+	assert.True(t, (&ImageType{Ib: 3, policy: &fallback}).allowsAudio(), "Audio should be allowed by the setting")
+	assert.False(t, (&ImageType{Ib: 4, policy: &fallback}).allowsAudio(), "Audio should not be allowed on other boards")
+	assert.False(t, (&ImageType{Ib: 3, policy: &UploadPolicy{}}).allowsAudio(), "A policy should turn off audio from the setting")
+	assert.True(t, (&ImageType{Ib: 4, policy: &UploadPolicy{Audio: true}}).allowsAudio(), "A policy should turn on audio")
+	assert.False(t, (&ImageType{Ib: 3, avatar: true}).allowsAudio(), "Avatars should never be audio")
+	assert.False(t, (&ImageType{}).allowsAudio(), "Audio should be off without a policy")
+}
+
+func TestPolicyVideoLimits(t *testing.T) {
+	config.Settings.Limits.ImageMaxWidth = 1920
+	config.Settings.Limits.ImageMinWidth = 100
+	config.Settings.Limits.ImageMaxHeight = 1080
+	config.Settings.Limits.ImageMinHeight = 100
+	config.Settings.Limits.ImageMaxSize = 10000000
+	config.Settings.Limits.WebmMaxLength = 30
+
+	img := ImageType{
+		mime:   "video/webm",
+		video:  true,
+		policy: &UploadPolicy{VideoLength: 5},
+	}
+
+	assert.EqualError(t, img.validateVideo(mockGoodFFProbeData()), "webm duration 10 sec is too long (max: 5 sec)", "Error should match")
+
+	small := ImageType{
+		mime:   "video/webm",
+		video:  true,
+		policy: &UploadPolicy{MaxWidth: 640},
+	}
+
+	assert.EqualError(t, small.validateVideo(mockGoodFFProbeData()), "webm width 1280 px is too large (max: 640 px)", "Error should match")
+}
+
+func TestGetMaxFiles(t *testing.T) {
+	original := local.Settings.Uploads.MaxFiles
+	defer func() { local.Settings.Uploads.MaxFiles = original }()
+
+	mock, err := db.NewTestDb()
+	assert.NoError(t, err, "An error was not expected")
+	defer db.CloseDb()
+
+	columns := []string{"formats", "size", "width", "height", "length", "audio", "files"}
+
+	mock.ExpectQuery(`SELECT policy_formats`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("", 0, 0, 0, 0, true, 3))
+
+	limit, err := GetMaxFiles(2)
+	assert.NoError(t, err, "An error was not expected")
+	assert.Equal(t, 3, limit, "Limit should come from the policy")
+
+	// boards without a limit use the config
+	local.Settings.Uploads.MaxFiles = 0
+
+	mock.ExpectQuery(`SELECT policy_formats`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	limit, err = GetMaxFiles(3)
+	assert.NoError(t, err, "An error was not expected")
+	assert.Equal(t, defaultMaxFiles, limit, "Limit should fall back to one file")
+
+	local.Settings.Uploads.MaxFiles = 2
+
+	mock.ExpectQuery(`SELECT policy_formats`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("", 0, 0, 0, 0, true, 0))
+
+	limit, err = GetMaxFiles(3)
+	assert.NoError(t, err, "An error was not expected")
+	assert.Equal(t, 2, limit, "Limit should come from the config")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "An error was not expected")
+}
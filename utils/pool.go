@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// MediaRetryAfter is the seconds a client should wait when the queue is full
+const MediaRetryAfter = 5
+
+// ErrQueueFull is returned when too many media jobs are already waiting
+var ErrQueueFull = errors.New("server is busy processing uploads, try again later")
+
+// errMediaTimeout is returned when a media command runs past its timeout
+var errMediaTimeout = errors.New("media command timed out")
+
+// mediaPool limits the convert, ffprobe, and ffmpeg processes and native thumbnailing
+var mediaPool = NewWorkerPool(local.Settings.Uploads.Workers, local.Settings.Uploads.QueueSize)
+
+// WorkerPool runs jobs with bounded concurrency and a bounded wait queue
+type WorkerPool struct {
+	workers   int
+	queueSize int
+	// slots limits running jobs
+	slots chan struct{}
+	// tickets limits running and waiting jobs
+	tickets chan struct{}
+
+	running   atomic.Int64
+	waiting   atomic.Int64
+	completed atomic.Uint64
+	rejected  atomic.Uint64
+	waitTime  atomic.Int64
+	runTime   atomic.Int64
+	maxRun    atomic.Int64
+}
+
+// PoolStats is a snapshot of the pool for monitoring
+type PoolStats struct {
+	Workers   int    `json:"workers"`
+	QueueSize int    `json:"queue_size"`
+	Running   int64  `json:"running"`
+	Waiting   int64  `json:"waiting"`
+	Completed uint64 `json:"completed"`
+	Rejected  uint64 `json:"rejected"`
+	AvgWaitMs int64  `json:"avg_wait_ms"`
+	AvgRunMs  int64  `json:"avg_run_ms"`
+	MaxRunMs  int64  `json:"max_run_ms"`
+}
+
+// NewWorkerPool creates a pool, zero workers uses the cpu count and a zero queue is four per worker
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	if queueSize <= 0 {
+		queueSize = workers * 4
+	}
+
+	return &WorkerPool{
+		workers:   workers,
+		queueSize: queueSize,
+		slots:     make(chan struct{}, workers),
+		tickets:   make(chan struct{}, workers+queueSize),
+	}
+}
+
+// Do runs fn when a worker is free or returns ErrQueueFull if the queue is full
+func (p *WorkerPool) Do(fn func() error) (err error) {
+
+	// take a place in line or give up right away
+	select {
+	case p.tickets <- struct{}{}:
+	default:
+		p.rejected.Add(1)
+		return ErrQueueFull
+	}
+	defer func() { <-p.tickets }()
+
+	p.waiting.Add(1)
+	queued := time.Now()
+
+	p.slots <- struct{}{}
+
+	p.waiting.Add(-1)
+	p.waitTime.Add(int64(time.Since(queued)))
+
+	p.running.Add(1)
+	started := time.Now()
+
+	defer func() {
+		elapsed := int64(time.Since(started))
+
+		p.runTime.Add(elapsed)
+
+		for {
+			current := p.maxRun.Load()
+			if elapsed <= current || p.maxRun.CompareAndSwap(current, elapsed) {
+				break
+			}
+		}
+
+		p.completed.Add(1)
+		p.running.Add(-1)
+
+		<-p.slots
+	}()
+
+	return fn()
+}
+
+// Stats returns the current queue depth and timings
+func (p *WorkerPool) Stats() (stats PoolStats) {
+	stats = PoolStats{
+		Workers:   p.workers,
+		QueueSize: p.queueSize,
+		Running:   p.running.Load(),
+		Waiting:   p.waiting.Load(),
+		Completed: p.completed.Load(),
+		Rejected:  p.rejected.Load(),
+		MaxRunMs:  time.Duration(p.maxRun.Load()).Milliseconds(),
+	}
+
+	if stats.Completed > 0 {
+		stats.AvgWaitMs = time.Duration(p.waitTime.Load() / int64(stats.Completed)).Milliseconds()
+		stats.AvgRunMs = time.Duration(p.runTime.Load() / int64(stats.Completed)).Milliseconds()
+	}
+
+	return
+}
+
+// MediaStats returns the stats for the media processing pool
+func MediaStats() PoolStats {
+	return mediaPool.Stats()
+}
+
+// runMedia runs a media command in the pool, the timeout starts once a worker is free
+func runMedia(timeout time.Duration, name string, args ...string) (output []byte, err error) {
+	err = mediaPool.Do(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var cmdErr error
+
+		output, cmdErr = exec.CommandContext(ctx, name, args...).Output()
+		if cmdErr != nil && ctx.Err() == context.DeadlineExceeded {
+			return errMediaTimeout
+		}
+
+		return cmdErr
+	})
+
+	return
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPoolDefaults(t *testing.T) {
+	pool := NewWorkerPool(0, 0)
+
+	stats := pool.Stats()
+
+	assert.Greater(t, stats.Workers, 0, "Workers should default to the cpu count")
+	assert.Equal(t, stats.Workers*4, stats.QueueSize, "Queue should default to four per worker")
+}
+
+func TestWorkerPoolQueueFull(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	// one running job
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = pool.Do(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+
+	<-started
+
+	// one waiting job
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = pool.Do(func() error { return nil })
+	}()
+
+	assert.Eventually(t, func() bool { return pool.Stats().Waiting == 1 }, time.Second, time.Millisecond, "Job should be waiting")
+
+	stats := pool.Stats()
+	assert.Equal(t, int64(1), stats.Running, "One job should be running")
+
+	err := pool.Do(func() error { return nil })
+	assert.ErrorIs(t, err, ErrQueueFull, "Queue should be full")
+
+	close(release)
+	wg.Wait()
+
+	stats = pool.Stats()
+	assert.Equal(t, uint64(2), stats.Completed, "Completed should match")
+	assert.Equal(t, uint64(1), stats.Rejected, "Rejected should match")
+	assert.Equal(t, int64(0), stats.Running, "Nothing should be running")
+	assert.Equal(t, int64(0), stats.Waiting, "Nothing should be waiting")
+
+	// there is room again
+	assert.NoError(t, pool.Do(func() error { return nil }), "An error was not expected")
+}
+
+func TestWorkerPoolError(t *testing.T) {
+	pool := NewWorkerPool(1, 1)
+
+	fail := errors.New("failed")
+
+	assert.ErrorIs(t, pool.Do(func() error { return fail }), fail, "Job error should be returned")
+	assert.Equal(t, uint64(1), pool.Stats().Completed, "Failed jobs should be counted")
+}
+
+func TestWorkerPoolConcurrency(t *testing.T) {
+	pool := NewWorkerPool(2, 10)
+
+	var mu sync.Mutex
+	var current, peak int
+
+	var wg sync.WaitGroup
+
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = pool.Do(func() error {
+				mu.Lock()
+				current++
+				peak = max(peak, current)
+				mu.Unlock()
+
+				time.Sleep(5 * time.Millisecond)
+
+				mu.Lock()
+				current--
+				mu.Unlock()
+				return nil
+			})
+		}()
+	}
+
+	wg.Wait()
+
+	assert.LessOrEqual(t, peak, 2, "No more than two jobs should run at once")
+	assert.Equal(t, uint64(8), pool.Stats().Completed, "Completed should match")
+	assert.Greater(t, pool.Stats().MaxRunMs, int64(0), "Max run time should be recorded")
+}
+
+func TestRunMedia(t *testing.T) {
+	original := mediaPool
+	defer func() { mediaPool = original }()
+
+	mediaPool = NewWorkerPool(1, 1)
+
+	output, err := runMedia(time.Second, "echo", "hello")
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, "hello\n", string(output), "Output should match")
+	}
+
+	_, err = runMedia(10*time.Millisecond, "sleep", "1")
+	assert.ErrorIs(t, err, errMediaTimeout, "Command should time out")
+
+	_, err = runMedia(time.Second, "false")
+	assert.Error(t, err, "An error was expected")
+	assert.NotErrorIs(t, err, errMediaTimeout, "Failure should not be a timeout")
+}
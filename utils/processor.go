@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// Image processor backend names
+const (
+	ProcessorImageMagick = "imagemagick"
+	ProcessorNative      = "native"
+)
+
+// the backend used when the config does not pick one, found once
+var (
+	defaultProcessor     string
+	defaultProcessorOnce sync.Once
+)
+
+// defaultImageProcessor is used when the config does not pick a backend
+// imagemagick is kept when convert is installed so existing installs make the same thumbnails
+func defaultImageProcessor() string {
+	defaultProcessorOnce.Do(func() {
+		defaultProcessor = ProcessorNative
+
+		_, err := exec.LookPath("convert")
+		if err == nil {
+			defaultProcessor = ProcessorImageMagick
+		}
+	})
+
+	return defaultProcessor
+}
+
+// errHealthUnchecked is reported until the tools have been checked once
+var errHealthUnchecked = errors.New("media tools have not been checked yet")
+
+// the result of the last tool check, the check starts processes so it is not run per request
+var (
+	healthErr = errHealthUnchecked
+	healthMu  sync.RWMutex
+)
+
+// ImageProcessor defines a backend that can create thumbnails
+type ImageProcessor interface {
+	// Name returns the backend name
+	Name() string
+	// Thumbnail reads the image at src and writes a thumbnail to dst
+	Thumbnail(src, dst string, opts ThumbnailOptions) (err error)
+	// Check reports if the backend is usable on this host
+	Check() (err error)
+}
+
+// ThumbnailOptions holds the parameters for a thumbnail
+type ThumbnailOptions struct {
+	// bounding box of the thumbnail
+	MaxWidth  int
+	MaxHeight int
+	// dimensions of the source image, used as a decoding hint
+	OrigWidth  int
+	OrigHeight int
+	// jpeg quality
+	Quality int
+	// fill and center crop the box keeping transparency (avatars)
+	Crop bool
+}
+
+// NewImageProcessor returns the image processor with the given name
+func NewImageProcessor(name string) (ImageProcessor, error) {
+	if name == "" {
+		name = defaultImageProcessor()
+	}
+
+	switch name {
+	case ProcessorImageMagick:
+		return &ImageMagickProcessor{}, nil
+	case ProcessorNative:
+		return &NativeProcessor{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown image processor: %s", name)
+}
+
+// processor returns the image processor set on the image or the configured default
+func (i *ImageType) processor() (ImageProcessor, error) {
+	if i.Processor != nil {
+		return i.Processor, nil
+	}
+
+	return NewImageProcessor(local.Settings.Post.ImageProcessor)
+}
+
+// CheckHealth reports any external tools that are missing or not responding
+func CheckHealth() (err error) {
+	var errs []error
+
+	processor, err := NewImageProcessor(local.Settings.Post.ImageProcessor)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, processor.Check())
+	}
+
+	// ffmpeg is always required for webm processing
+	errs = append(errs, checkFFprobe(), checkFFmpeg())
+
+	return errors.Join(errs...)
+}
+
+// RefreshHealth runs the tool check and stores the result for Health
+func RefreshHealth() (err error) {
+	err = CheckHealth()
+
+	healthMu.Lock()
+	healthErr = err
+	healthMu.Unlock()
+
+	return
+}
+
+// Health is the result of the last tool check
+func Health() error {
+	healthMu.RLock()
+	defer healthMu.RUnlock()
+
+	return healthErr
+}
+
+// StartHealthCheck refreshes the stored tool check on an interval
+func StartHealthCheck(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			err := RefreshHealth()
+			if err != nil {
+				log.Printf("media processing is degraded: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,482 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+
+	"github.com/eirka/eirka-libs/db"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// resumable uploads follow the tus 1.0.0 protocol
+const (
+	// TusVersion is the protocol version the upload endpoint speaks
+	TusVersion = "1.0.0"
+	// TusExtensions are the optional parts of the protocol that are supported
+	TusExtensions = "creation,expiration,termination"
+
+	defaultResumableExpiry = 1440
+	resumableIDBytes       = 16
+	resumableInfoExt       = ".info"
+)
+
+var (
+	// ErrUploadNotFound is returned for unknown or expired upload ids
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadOffset is returned when a chunk does not start where the upload ends
+	ErrUploadOffset = errors.New("upload offset does not match")
+	// ErrUploadLocked is returned when a chunk is already being written to the upload
+	ErrUploadLocked = errors.New("upload is busy")
+	// ErrUploadTooLarge is returned when the upload length is over the board limit
+	ErrUploadTooLarge = errors.New("image filesize too large")
+	// ErrUploadIncomplete is returned when a post uses an upload that is not finished
+	ErrUploadIncomplete = errors.New("upload is not finished")
+	// ErrUploadBoard is returned when a post uses an upload started for another board
+	ErrUploadBoard = errors.New("upload is for another board")
+	// ErrUploadNoBoard is returned when an upload is started for a board that does not exist
+	ErrUploadNoBoard = errors.New("upload board does not exist")
+	// ErrMaxUploads is returned when an ip has started too many uploads or bytes
+	ErrMaxUploads = errors.New("upload limit exceeded")
+)
+
+// limits on the resumable uploads one ip can start
+var (
+	maxResumableUploads           = 20
+	maxResumableBytes       int64 = 1 << 30
+	resumableCounterSeconds uint  = 3600
+)
+
+// uploads with a chunk being written
+var (
+	activeUploads   = make(map[string]bool)
+	activeUploadsMu sync.Mutex
+)
+
+// Upload is a resumable upload, its state is kept in a file next to the data
+type Upload struct {
+	ID       string    `json:"id"`
+	Ib       uint      `json:"ib"`
+	Length   int64     `json:"length"`
+	Filename string    `json:"filename"`
+	Expires  time.Time `json:"expires"`
+	// Offset is how much has been received, it is the size of the data file
+	Offset int64 `json:"-"`
+}
+
+// resumableExpiry is how long an unfinished upload is kept
+func resumableExpiry() time.Duration {
+	if local.Settings.Uploads.ResumableExpiry > 0 {
+		return time.Duration(local.Settings.Uploads.ResumableExpiry) * time.Minute
+	}
+	return defaultResumableExpiry * time.Minute
+}
+
+// resumableDir is where unfinished uploads are written
+func resumableDir() string {
+	if local.Settings.Directories.UploadDir != "" {
+		return local.Settings.Directories.UploadDir
+	}
+	return filepath.Join(os.TempDir(), "eirka-uploads")
+}
+
+// CheckUploadBoard makes sure the board an upload is started for exists
+// an upload for any other board could never be posted
+func CheckUploadBoard(ib uint) (err error) {
+
+	if ib == 0 {
+		return e.ErrNoIb
+	}
+
+	// Get Database handle
+	dbase, err := db.GetDb()
+	if err != nil {
+		return
+	}
+
+	var check bool
+
+	err = dbase.QueryRow("SELECT count(*) FROM imageboards WHERE ib_id = ?", ib).Scan(&check)
+	if err != nil {
+		return
+	}
+
+	if !check {
+		return ErrUploadNoBoard
+	}
+
+	return
+}
+
+// ResumableMaxSize is the largest upload the board accepts
+func ResumableMaxSize(ib uint) (size int64, err error) {
+	img := ImageType{Ib: ib}
+
+	err = img.loadPolicy()
+	if err != nil {
+		return
+	}
+
+	return int64(img.maxSize()), nil
+}
+
+// ResumableCounter will increment counters in redis to limit the uploads an ip starts
+// so the upload directory cant be filled from one address
+func ResumableCounter(ip string, length int64) error {
+	if ip == "" {
+		return e.ErrInternalError
+	}
+
+	// keys are like resumable:10.0.0.1 and resumablebytes:10.0.0.1
+	countKey := fmt.Sprintf("resumable:%s", ip)
+	bytesKey := fmt.Sprintf("resumablebytes:%s", ip)
+
+	count, err := redis.Cache.Incr(countKey)
+	if err != nil {
+		return e.ErrInternalError
+	}
+
+	err = redis.Cache.Expire(countKey, resumableCounterSeconds)
+	if err != nil {
+		return e.ErrInternalError
+	}
+
+	if count > maxResumableUploads {
+		return ErrMaxUploads
+	}
+
+	total, err := incrBy(bytesKey, length)
+	if err != nil {
+		return e.ErrInternalError
+	}
+
+	err = redis.Cache.Expire(bytesKey, resumableCounterSeconds)
+	if err != nil {
+		return e.ErrInternalError
+	}
+
+	if total > maxResumableBytes {
+		return ErrMaxUploads
+	}
+
+	return nil
+}
+
+// incrBy adds to a redis counter, the cache store only increments by one
+func incrBy(key string, value int64) (int64, error) {
+	conn := redis.Cache.Pool.Get()
+	defer conn.Close()
+
+	return redigo.Int64(conn.Do("INCRBY", key, value))
+}
+
+// validUploadID stops ids from being used as paths
+func validUploadID(id string) bool {
+	if len(id) != resumableIDBytes*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// NewUpload creates an empty upload for a file of the given length
+func NewUpload(ib uint, length int64, filename string) (upload *Upload, err error) {
+
+	maxSize, err := ResumableMaxSize(ib)
+	if err != nil {
+		return
+	}
+
+	if length <= 0 {
+		return nil, errors.New("upload length required")
+	}
+
+	if maxSize > 0 && length > maxSize {
+		return nil, fmt.Errorf("%w. Max: %dMB", ErrUploadTooLarge, (maxSize/1024)/1024)
+	}
+
+	id := make([]byte, resumableIDBytes)
+
+	_, err = rand.Read(id)
+	if err != nil {
+		return
+	}
+
+	upload = &Upload{
+		ID:       hex.EncodeToString(id),
+		Ib:       ib,
+		Length:   length,
+		Filename: path.Base(strings.ReplaceAll(filename, "\\", "/")),
+		Expires:  time.Now().Add(resumableExpiry()).UTC(),
+	}
+
+	err = os.MkdirAll(resumableDir(), 0700)
+	if err != nil {
+		return nil, errors.New("problem creating upload")
+	}
+
+	data, err := os.OpenFile(upload.dataPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, errors.New("problem creating upload")
+	}
+	data.Close()
+
+	err = upload.writeInfo()
+	if err != nil {
+		os.Remove(upload.dataPath())
+		return nil, errors.New("problem creating upload")
+	}
+
+	return
+}
+
+// GetUpload loads an upload and how much of it has been received
+func GetUpload(id string) (upload *Upload, err error) {
+
+	if !validUploadID(id) {
+		return nil, ErrUploadNotFound
+	}
+
+	info, err := os.ReadFile(filepath.Join(resumableDir(), id+resumableInfoExt))
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	upload = &Upload{}
+
+	err = json.Unmarshal(info, upload)
+	if err != nil || upload.ID != id {
+		return nil, ErrUploadNotFound
+	}
+
+	if upload.Expired() {
+		upload.Remove()
+		return nil, ErrUploadNotFound
+	}
+
+	stat, err := os.Stat(upload.dataPath())
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	upload.Offset = stat.Size()
+
+	return
+}
+
+// Expired is true when the upload was not finished in time
+func (u *Upload) Expired() bool {
+	return time.Now().After(u.Expires)
+}
+
+// Complete is true when every byte has been received
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Append writes a chunk that starts at offset to the end of the upload
+func (u *Upload) Append(offset int64, r io.Reader) (err error) {
+
+	if offset != u.Offset {
+		return ErrUploadOffset
+	}
+
+	if !u.lock() {
+		return ErrUploadLocked
+	}
+	defer u.unlock()
+
+	data, err := os.OpenFile(u.dataPath(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return ErrUploadNotFound
+	}
+	defer data.Close()
+
+	// another chunk may have finished since the upload was loaded
+	stat, err := data.Stat()
+	if err != nil {
+		return
+	}
+
+	if stat.Size() != offset {
+		return ErrUploadOffset
+	}
+
+	// a partial chunk is kept so the client can resume from it
+	written, err := io.Copy(data, io.LimitReader(r, u.Length-u.Offset))
+	u.Offset += written
+	if err != nil {
+		return errors.New("problem writing upload")
+	}
+
+	return
+}
+
+// Open returns the finished file for the post
+func (u *Upload) Open() (file *os.File, err error) {
+
+	if !u.Complete() {
+		return nil, ErrUploadIncomplete
+	}
+
+	file, err = os.Open(u.dataPath())
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	return
+}
+
+// Remove deletes the upload data and state
+func (u *Upload) Remove() (err error) {
+	os.Remove(u.dataPath())
+
+	err = os.Remove(u.infoPath())
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	return nil
+}
+
+func (u *Upload) dataPath() string {
+	return filepath.Join(resumableDir(), u.ID)
+}
+
+func (u *Upload) infoPath() string {
+	return filepath.Join(resumableDir(), u.ID+resumableInfoExt)
+}
+
+func (u *Upload) writeInfo() (err error) {
+	info, err := json.Marshal(u)
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(u.infoPath(), info, 0600)
+}
+
+func (u *Upload) lock() bool {
+	activeUploadsMu.Lock()
+	defer activeUploadsMu.Unlock()
+
+	if activeUploads[u.ID] {
+		return false
+	}
+
+	activeUploads[u.ID] = true
+
+	return true
+}
+
+func (u *Upload) unlock() {
+	activeUploadsMu.Lock()
+	defer activeUploadsMu.Unlock()
+
+	delete(activeUploads, u.ID)
+}
+
+// ExpireUploads removes abandoned uploads and returns how many were removed
+func ExpireUploads() (removed int, err error) {
+
+	infos, err := filepath.Glob(filepath.Join(resumableDir(), "*"+resumableInfoExt))
+	if err != nil {
+		return
+	}
+
+	for _, info := range infos {
+		id := strings.TrimSuffix(filepath.Base(info), resumableInfoExt)
+
+		upload := &Upload{ID: id}
+
+		data, readErr := os.ReadFile(info)
+		if readErr == nil && json.Unmarshal(data, upload) == nil && !upload.Expired() {
+			continue
+		}
+
+		// unreadable state is removed with the expired uploads
+		upload.ID = id
+
+		if upload.lock() {
+			upload.Remove()
+			upload.unlock()
+			removed++
+		}
+	}
+
+	return
+}
+
+// StartUploadExpiry removes abandoned uploads in the background
+func StartUploadExpiry(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			removed, err := ExpireUploads()
+			if err != nil {
+				log.Printf("problem expiring uploads: %v", err)
+				continue
+			}
+
+			if removed > 0 {
+				log.Printf("expired %d resumable uploads", removed)
+			}
+		}
+	}()
+}
+
+// openUpload uses a finished resumable upload as the file
+// the data is opened and closed by copyFile
+func (i *ImageType) openUpload() (err error) {
+
+	upload, err := GetUpload(i.UploadID)
+	if err != nil {
+		return
+	}
+
+	// the upload was checked against the limits of its own board
+	if upload.Ib != i.Ib {
+		return ErrUploadBoard
+	}
+
+	if !upload.Complete() {
+		return ErrUploadIncomplete
+	}
+
+	i.Header = &multipart.FileHeader{
+		Filename: upload.Filename,
+		Size:     upload.Length,
+	}
+
+	i.upload = upload
+
+	return
+}
+
+// RemoveUpload deletes the resumable upload the file came from
+// it is called once the post is made so a failed post can use the upload again
+func (i *ImageType) RemoveUpload() {
+	if i.upload != nil {
+		i.upload.Remove()
+		i.upload = nil
+	}
+}
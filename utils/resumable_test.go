@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/redigomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/config"
+	e "github.com/eirka/eirka-libs/errors"
+	"github.com/eirka/eirka-libs/redis"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// resumableTestDir keeps the uploads of a test in their own directory
+func resumableTestDir(t *testing.T) {
+	t.Helper()
+
+	originalDir := local.Settings.Directories.UploadDir
+	originalSize := config.Settings.Limits.ImageMaxSize
+
+	local.Settings.Directories.UploadDir = t.TempDir()
+	config.Settings.Limits.ImageMaxSize = 1024
+
+	t.Cleanup(func() {
+		local.Settings.Directories.UploadDir = originalDir
+		config.Settings.Limits.ImageMaxSize = originalSize
+	})
+}
+
+func TestResumableUpload(t *testing.T) {
+	resumableTestDir(t)
+
+	upload, err := NewUpload(0, 10, "C:\\files\\test.webm")
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Len(t, upload.ID, resumableIDBytes*2, "ID should be set")
+		assert.Equal(t, "test.webm", upload.Filename, "Only the base name should be kept")
+		assert.True(t, upload.Expires.After(time.Now()), "Expiry should be in the future")
+	}
+
+	loaded, err := GetUpload(upload.ID)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, int64(0), loaded.Offset, "Nothing should be received")
+		assert.Equal(t, int64(10), loaded.Length, "Length should match")
+	}
+
+	_, err = loaded.Open()
+	assert.ErrorIs(t, err, ErrUploadIncomplete, "Unfinished uploads cant be opened")
+
+	assert.NoError(t, loaded.Append(0, strings.NewReader("01234")), "An error was not expected")
+	assert.Equal(t, int64(5), loaded.Offset, "Offset should move")
+
+	assert.ErrorIs(t, loaded.Append(2, strings.NewReader("56789")), ErrUploadOffset, "Wrong offsets should be rejected")
+
+	// a stale copy of the upload does not know about the last chunk
+	stale := &Upload{ID: upload.ID, Length: 10, Offset: 0}
+	assert.ErrorIs(t, stale.Append(0, strings.NewReader("56789")), ErrUploadOffset, "Stale offsets should be rejected")
+
+	// extra bytes past the length are ignored
+	assert.NoError(t, loaded.Append(5, strings.NewReader("56789abc")), "An error was not expected")
+	assert.True(t, loaded.Complete(), "Upload should be complete")
+
+	file, err := loaded.Open()
+	if assert.NoError(t, err, "An error was not expected") {
+		data, _ := io.ReadAll(file)
+		file.Close()
+		assert.Equal(t, "0123456789", string(data), "Data should match")
+	}
+
+	assert.NoError(t, loaded.Remove(), "An error was not expected")
+
+	_, err = GetUpload(upload.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound, "Removed uploads should be gone")
+}
+
+func TestResumableUploadLocked(t *testing.T) {
+	resumableTestDir(t)
+
+	upload, err := NewUpload(0, 10, "test.jpg")
+	assert.NoError(t, err, "An error was not expected")
+
+	assert.True(t, upload.lock(), "Lock should be taken")
+	assert.ErrorIs(t, upload.Append(0, strings.NewReader("0123")), ErrUploadLocked, "Busy uploads should be rejected")
+	upload.unlock()
+
+	assert.NoError(t, upload.Append(0, strings.NewReader("0123")), "An error was not expected")
+}
+
+func TestNewUploadInvalid(t *testing.T) {
+	resumableTestDir(t)
+
+	_, err := NewUpload(0, 0, "test.jpg")
+	assert.Error(t, err, "An error was expected")
+
+	_, err = NewUpload(0, 2048, "test.jpg")
+	assert.ErrorIs(t, err, ErrUploadTooLarge, "Large uploads should be rejected")
+}
+
+func TestGetUploadInvalid(t *testing.T) {
+	resumableTestDir(t)
+
+	for _, id := range []string{
+		"",
+		"../../etc/passwd",
+		"0123456789abcdef",
+		"zz23456789abcdef0123456789abcdef",
+		"0123456789abcdef0123456789abcdef",
+	} {
+		_, err := GetUpload(id)
+		assert.ErrorIs(t, err, ErrUploadNotFound, id+" should not be found")
+	}
+}
+
+func TestExpireUploads(t *testing.T) {
+	resumableTestDir(t)
+
+	fresh, err := NewUpload(0, 10, "fresh.jpg")
+	assert.NoError(t, err, "An error was not expected")
+
+	expired, err := NewUpload(0, 10, "expired.jpg")
+	assert.NoError(t, err, "An error was not expected")
+
+	expired.Expires = time.Now().Add(-time.Minute)
+	assert.NoError(t, expired.writeInfo(), "An error was not expected")
+
+	// broken state is cleaned up too
+	broken := &Upload{ID: "0123456789abcdef0123456789abcdef"}
+	assert.NoError(t, os.WriteFile(broken.infoPath(), []byte("{"), 0600), "An error was not expected")
+
+	removed, err := ExpireUploads()
+	assert.NoError(t, err, "An error was not expected")
+	assert.Equal(t, 2, removed, "Expired uploads should be removed")
+
+	_, err = GetUpload(fresh.ID)
+	assert.NoError(t, err, "Fresh uploads should be kept")
+
+	_, err = GetUpload(expired.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound, "Expired uploads should be gone")
+
+	_, err = os.Stat(expired.dataPath())
+	assert.True(t, os.IsNotExist(err), "Expired data should be removed")
+}
+
+func TestOpenUpload(t *testing.T) {
+	resumableTestDir(t)
+
+	jpeg := testJpeg(100).Bytes()
+	config.Settings.Limits.ImageMaxSize = len(jpeg)
+
+	upload, err := NewUpload(0, int64(len(jpeg)), "test.jpg")
+	assert.NoError(t, err, "An error was not expected")
+	assert.NoError(t, upload.Append(0, bytes.NewReader(jpeg)), "An error was not expected")
+
+	img := ImageType{UploadID: upload.ID}
+
+	if assert.NoError(t, img.openUpload(), "An error was not expected") {
+		assert.Equal(t, "test.jpg", img.Header.Filename, "Filename should come from the upload")
+		assert.Equal(t, int64(len(jpeg)), img.Header.Size, "Size should come from the upload")
+		assert.Nil(t, img.File, "The data should only be opened by the copy")
+
+		assert.NoError(t, os.MkdirAll(local.Settings.Directories.ImageDir, 0755), "Failed to ensure image directory exists")
+		assert.NoError(t, img.copyFile(), "An error was not expected")
+		assert.Equal(t, int64(len(jpeg)), img.size, "The upload should be copied")
+		img.removeTemp()
+	}
+
+	// an upload can only be posted to the board it was started for
+	other := ImageType{UploadID: upload.ID, Ib: 2}
+	assert.ErrorIs(t, other.openUpload(), ErrUploadBoard, "Uploads for other boards should be rejected")
+	assert.Nil(t, other.File, "Nothing should be open")
+
+	unfinished, err := NewUpload(0, 10, "test.jpg")
+	assert.NoError(t, err, "An error was not expected")
+
+	partial := ImageType{UploadID: unfinished.ID}
+	assert.ErrorIs(t, partial.openUpload(), ErrUploadIncomplete, "Unfinished uploads should be rejected")
+
+	missing := ImageType{UploadID: "0123456789abcdef0123456789abcdef"}
+	assert.ErrorIs(t, missing.openUpload(), ErrUploadNotFound, "Missing uploads should be rejected")
+
+	// the upload stays until the post is made
+	_, err = GetUpload(upload.ID)
+	assert.NoError(t, err, "The upload should be kept")
+
+	img.RemoveUpload()
+
+	_, err = GetUpload(upload.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound, "The upload should be removed")
+}
+
+func TestResumableCounter(t *testing.T) {
+	redis.NewRedisMock()
+
+	redis.Cache.Mock.Command("INCR", "resumable:10.0.0.1").Expect([]byte("20"))
+	redis.Cache.Mock.Command("EXPIRE", "resumable:10.0.0.1", redigomock.NewAnyData())
+	redis.Cache.Mock.Command("INCRBY", "resumablebytes:10.0.0.1", int64(1024)).Expect(maxResumableBytes)
+	redis.Cache.Mock.Command("EXPIRE", "resumablebytes:10.0.0.1", redigomock.NewAnyData())
+
+	assert.NoError(t, ResumableCounter("10.0.0.1", 1024), "An error was not expected")
+
+	redis.Cache.Mock.Command("INCRBY", "resumablebytes:10.0.0.1", int64(1)).Expect(maxResumableBytes + 1)
+
+	assert.Equal(t, ErrMaxUploads, ResumableCounter("10.0.0.1", 1), "Too many bytes should be limited")
+
+	redis.Cache.Mock.Command("INCR", "resumable:10.0.0.1").Expect([]byte("21"))
+
+	assert.Equal(t, ErrMaxUploads, ResumableCounter("10.0.0.1", 1), "Too many uploads should be limited")
+
+	assert.Equal(t, e.ErrInternalError, ResumableCounter("", 1), "Error should match")
+}
@@ -0,0 +1,320 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// storageTimeout bounds a single request to the storage server, a var so tests can shorten it
+var storageTimeout = 60 * time.Second
+
+// storageClient is used when a storage has no client, the timeout backs up the request contexts
+var storageClient = &http.Client{Timeout: storageTimeout}
+
+// payload hash for requests that stream the body
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// hash of an empty body
+const emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Now is a var so tests can set the signing time
+var s3Now = time.Now
+
+// S3Storage keeps files in an S3 compatible bucket
+type S3Storage struct {
+	// Endpoint is the server url, empty for amazon
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prefix is added to every object key
+	Prefix string
+	// Client is used for requests, storageClient if nil
+	Client *http.Client
+}
+
+var _ = Storage(&S3Storage{})
+
+// Put uploads the contents of r to name
+func (s *S3Storage) Put(name, contentType string, r io.Reader, size int64) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	req, err := s.newRequest(ctx, http.MethodPut, name, r)
+	if err != nil {
+		return
+	}
+
+	req.ContentLength = size
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+
+	return
+}
+
+// Get downloads name, the caller must close the body
+// the timeout covers reading the body so it is cancelled when the body is closed
+func (s *S3Storage) Get(name string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+
+	req, err := s.newRequest(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp, err := s.do(req, emptyPayload)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer cancel()
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+
+	return &s3Body{ReadCloser: resp.Body, cancel: cancel}, nil
+}
+
+// s3Body releases the request timeout when the body is closed
+type s3Body struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and cancels its request
+func (b *s3Body) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// Delete removes name
+func (s *S3Storage) Delete(name string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	req, err := s.newRequest(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.do(req, emptyPayload)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+
+	return
+}
+
+// Stat returns the size and modification time of name
+func (s *S3Storage) Stat(name string) (info StorageInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	defer cancel()
+
+	req, err := s.newRequest(ctx, http.MethodHead, name, nil)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.do(req, emptyPayload)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return info, s3Error(resp)
+	}
+
+	info.Name = name
+	info.Size = resp.ContentLength
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return
+}
+
+// newRequest builds a path style request for an object
+func (s *S3Storage) newRequest(ctx context.Context, method, name string, body io.Reader) (*http.Request, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	}
+
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage endpoint: %v", err)
+	}
+
+	key := s.Prefix + name
+
+	base.RawPath = strings.TrimSuffix(base.EscapedPath(), "/") + "/" + s3Escape(s.Bucket) + "/" + s3Escape(key)
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/" + s.Bucket + "/" + key
+
+	return http.NewRequestWithContext(ctx, method, base.String(), body)
+}
+
+// do signs and sends the request
+func (s *S3Storage) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash, s3Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = storageClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("problem reaching storage: %v", err)
+	}
+
+	return resp, nil
+}
+
+// sign adds an AWS signature version 4 authorization header to the request
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// every header set on the request is signed along with the host
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.Region)
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey(s.SecretKey, date, s.Region, "s3"), []byte(stringToSign)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// signingKey derives the version 4 signing key
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalQuery sorts and escapes the query parameters
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var params []string
+	for _, key := range keys {
+		vals := values[key]
+		sort.Strings(vals)
+		for _, val := range vals {
+			params = append(params, queryEscape(key)+"="+queryEscape(val))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent encodes everything but unreserved characters and slashes
+func s3Escape(s string) string {
+	var builder strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/':
+			builder.WriteByte(b)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+// queryEscape is s3Escape that also encodes slashes
+func queryEscape(s string) string {
+	return strings.ReplaceAll(s3Escape(s), "/", "%2F")
+}
+
+// s3Error turns a failed response into an error
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fs.ErrNotExist
+	}
+
+	return fmt.Errorf("storage returned status %d", resp.StatusCode)
+}
@@ -0,0 +1,759 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"slices"
+
+	"golang.org/x/image/draw"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// exif orientation tag
+const exifOrientation = 0x0112
+
+// errJPEGSegment is returned for a jpeg segment that is too short or runs past the file
+var errJPEGSegment = errors.New("problem reading jpeg metadata")
+
+// png chunks that only carry metadata
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// vp8x flags for the webp metadata chunks
+const (
+	webpXMPBit  = 1 << 2
+	webpEXIFBit = 1 << 3
+)
+
+// content type of xmp items in an avif
+const avifXMPType = "application/rdf+xml"
+
+// gif application extensions that control playback
+var gifAllowedApplications = map[string]bool{
+	"NETSCAPE2.0": true,
+	"ANIMEXTS1.0": true,
+}
+
+// sanitize removes metadata from the image and applies the exif orientation
+// the temp file and hashes are replaced if the bytes changed
+func (i *ImageType) sanitize() (err error) {
+
+	// some boards want the untouched original
+	if !i.avatar && slices.Contains(local.Settings.Uploads.KeepOriginal, i.Ib) {
+		return
+	}
+
+	var strip func([]byte) ([]byte, error)
+
+	switch i.mime {
+	case "image/jpeg":
+		strip = sanitizeJPEG
+	case "image/png":
+		strip = stripPNG
+	case "image/gif":
+		strip = stripGIF
+	case "image/webp":
+		strip = stripWebP
+	case "image/avif":
+		strip = stripAVIF
+	default:
+		return
+	}
+
+	data, err := i.readTemp()
+	if err != nil {
+		return errors.New("no image data to sanitize")
+	}
+
+	clean, err := strip(data)
+	if err != nil {
+		return
+	}
+
+	// nothing was removed
+	if bytes.Equal(clean, data) {
+		return
+	}
+
+	i.sanitized = true
+
+	// the new temp file gets hashes that match the bytes we store
+	return i.writeTemp(bytes.NewReader(clean))
+}
+
+// sanitizeJPEG rotates the pixels if needed and strips the metadata segments
+func sanitizeJPEG(data []byte) ([]byte, error) {
+	orientation, err := jpegOrientation(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// anything but the default needs the pixels rotated
+	if orientation > 1 && orientation <= 8 {
+		var clean []byte
+
+		// decoding the whole image is limited like thumbnailing
+		err = mediaPool.Do(func() (err error) {
+			clean, err = reorientJPEG(data, orientation)
+			return
+		})
+
+		return clean, err
+	}
+
+	return stripJPEG(data)
+}
+
+// stripJPEG removes exif, xmp, comments and other app segments without reencoding
+// JFIF, ICC profiles and Adobe color transform segments are kept
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("invalid JPEG file signature")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	pos := 2
+
+	for pos < len(data) {
+		if data[pos] != 0xFF {
+			return nil, errJPEGSegment
+		}
+
+		// skip fill bytes
+		for pos+1 < len(data) && data[pos+1] == 0xFF {
+			pos++
+		}
+
+		if pos+1 >= len(data) {
+			return nil, errJPEGSegment
+		}
+
+		marker := data[pos+1]
+
+		// start of scan, the rest is image data
+		if marker == 0xDA {
+			out.Write(data[pos:])
+			return out.Bytes(), nil
+		}
+
+		// markers without a length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD9) {
+			out.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(data) {
+			return nil, errJPEGSegment
+		}
+
+		// the length counts its own two bytes
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errJPEGSegment
+		}
+
+		segment := data[pos:end]
+
+		if !jpegMetadataSegment(marker, segment[4:]) {
+			out.Write(segment)
+		}
+
+		pos = end
+	}
+
+	return out.Bytes(), nil
+}
+
+// jpegMetadataSegment is true for segments that only carry metadata
+func jpegMetadataSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xFE:
+		// comments
+		return true
+	case marker == 0xE0:
+		// JFIF
+		return false
+	case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+		// color profiles change how the image looks
+		return false
+	case marker == 0xEE && bytes.HasPrefix(payload, []byte("Adobe")):
+		// needed to decode CMYK and YCCK images
+		return false
+	case marker >= 0xE1 && marker <= 0xEF:
+		// exif, xmp, photoshop, maker notes
+		return true
+	}
+
+	return false
+}
+
+// jpegOrientation finds the exif orientation tag, 1 if there is none
+func jpegOrientation(data []byte) (int, error) {
+	pos := 2
+
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+
+		// exif is always before the image data
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		// the length counts its own two bytes
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return 0, errJPEGSegment
+		}
+
+		payload := data[pos+4 : end]
+
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return exifOrientationTag(payload[6:]), nil
+		}
+
+		pos = end
+	}
+
+	return 1, nil
+}
+
+// exifOrientationTag reads the orientation from the first IFD of a tiff header
+func exifOrientationTag(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) || offset < 8 {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[offset : offset+2]))
+
+	for n := range count {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:entry+2]) == exifOrientation {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+
+	return 1
+}
+
+// reorientJPEG decodes the image, applies the orientation and reencodes it
+// the encoder writes no metadata so this also strips everything
+func reorientJPEG(data []byte, orientation int) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("problem decoding image")
+	}
+
+	out := new(bytes.Buffer)
+
+	err = jpeg.Encode(out, orient(img, orientation), &jpeg.Options{Quality: 95})
+	if err != nil {
+		return nil, errors.New("problem encoding image")
+	}
+
+	return out.Bytes(), nil
+}
+
+// orient applies an exif orientation so the image displays upright
+func orient(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+
+	// get the pixels into a known format
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// orientations past 4 swap the axes
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+// stripPNG removes text, exif and time chunks
+func stripPNG(data []byte) ([]byte, error) {
+	if len(data) < 8 || string(data[:8]) != "\x89PNG\r\n\x1a\n" {
+		return nil, errors.New("invalid PNG file signature")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:8])
+
+	pos := 8
+
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errors.New("problem reading png metadata")
+		}
+
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		chunkType := string(data[pos+4 : pos+8])
+
+		// length, type, data, and crc
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("problem reading png metadata")
+		}
+
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[pos:end])
+		}
+
+		pos = end
+
+		// anything after the end chunk is dropped
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// stripGIF removes comments and unknown application extensions
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 {
+		return nil, errors.New("invalid GIF file (too small)")
+	}
+
+	// header and logical screen descriptor
+	pos := 13
+
+	// global color table
+	if data[10]&0x80 != 0 {
+		pos += 3 << ((data[10] & 0x07) + 1)
+	}
+
+	if pos > len(data) {
+		return nil, errors.New("problem reading gif metadata")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:pos])
+
+	for pos < len(data) {
+		start := pos
+
+		switch data[pos] {
+		case 0x3B:
+			// trailer, anything after it is dropped
+			out.WriteByte(0x3B)
+			return out.Bytes(), nil
+
+		case 0x21:
+			if pos+2 > len(data) {
+				return nil, errors.New("problem reading gif metadata")
+			}
+
+			label := data[pos+1]
+
+			end, err := gifSubBlocks(data, pos+2)
+			if err != nil {
+				return nil, err
+			}
+
+			keep := true
+
+			switch label {
+			case 0xFE:
+				// comment
+				keep = false
+			case 0xFF:
+				// application extension, the first sub block is the identifier
+				keep = pos+14 <= len(data) && data[pos+2] == 11 && gifAllowedApplications[string(data[pos+3:pos+14])]
+			}
+
+			if keep {
+				out.Write(data[start:end])
+			}
+
+			pos = end
+
+		case 0x2C:
+			// image descriptor
+			if pos+10 > len(data) {
+				return nil, errors.New("problem reading gif metadata")
+			}
+
+			packed := data[pos+9]
+			pos += 10
+
+			// local color table
+			if packed&0x80 != 0 {
+				pos += 3 << ((packed & 0x07) + 1)
+			}
+
+			// lzw minimum code size
+			pos++
+
+			end, err := gifSubBlocks(data, pos)
+			if err != nil {
+				return nil, err
+			}
+
+			out.Write(data[start:end])
+
+			pos = end
+
+		default:
+			return nil, errors.New("problem reading gif metadata")
+		}
+	}
+
+	return nil, errors.New("problem reading gif metadata")
+}
+
+// gifSubBlocks returns the position after a chain of data sub blocks
+func gifSubBlocks(data []byte, pos int) (int, error) {
+	for {
+		if pos >= len(data) {
+			return 0, errors.New("problem reading gif metadata")
+		}
+
+		size := int(data[pos])
+		pos++
+
+		if size == 0 {
+			return pos, nil
+		}
+
+		pos += size
+	}
+}
+
+// stripWebP removes the exif and xmp chunks and clears their vp8x flags
+func stripWebP(data []byte) ([]byte, error) {
+	if !isWebP(data) {
+		return nil, errors.New("invalid WebP file signature")
+	}
+
+	chunks, err := webpChunks(data[12:])
+	if err != nil {
+		return nil, err
+	}
+
+	body := bytes.NewBuffer(make([]byte, 0, len(data)))
+	body.WriteString("WEBP")
+
+	for _, chunk := range chunks {
+		switch chunk.id {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			// the flags say which chunks are in the file
+			raw := bytes.Clone(chunk.raw)
+			if len(raw) > 8 {
+				raw[8] &^= webpEXIFBit | webpXMPBit
+			}
+			body.Write(raw)
+		default:
+			body.Write(chunk.raw)
+		}
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, body.Len()+8))
+	out.WriteString("RIFF")
+	binary.Write(out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+
+	return out.Bytes(), nil
+}
+
+// stripAVIF blanks the data of the exif and xmp items, the file is not reencoded
+// the items are left in place so no offsets in the file change
+func stripAVIF(data []byte) ([]byte, error) {
+	clean := bytes.Clone(data)
+
+	boxes, err := isoBoxes(clean)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, ok := findBox(boxes, "meta")
+	// meta is a full box with a version and flags
+	if !ok || len(meta.data) < 4 {
+		return nil, errors.New("avif has no metadata")
+	}
+
+	children, err := isoBoxes(meta.data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	iinf, ok := findBox(children, "iinf")
+	if !ok {
+		return clean, nil
+	}
+
+	items, err := avifMetadataItems(iinf.data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return clean, nil
+	}
+
+	iloc, ok := findBox(children, "iloc")
+	if !ok {
+		return nil, errors.New("problem reading avif metadata")
+	}
+
+	// items stored in the meta box are offsets into idat
+	var idat []byte
+	if box, ok := findBox(children, "idat"); ok {
+		idat = box.data
+	}
+
+	err = avifBlankItems(iloc.data, items, clean, idat)
+	if err != nil {
+		return nil, err
+	}
+
+	return clean, nil
+}
+
+// avifMetadataItems finds the ids of the exif and xmp items in an iinf box
+func avifMetadataItems(iinf []byte) (items map[uint32]bool, err error) {
+	if len(iinf) < 4 {
+		return nil, errors.New("problem reading avif metadata")
+	}
+
+	// the entry count is 16 bits in version 0 and 32 bits after
+	pos := 6
+	if iinf[0] != 0 {
+		pos = 8
+	}
+
+	if pos > len(iinf) {
+		return nil, errors.New("problem reading avif metadata")
+	}
+
+	entries, err := isoBoxes(iinf[pos:])
+	if err != nil {
+		return
+	}
+
+	items = make(map[uint32]bool)
+
+	for _, entry := range entries {
+		// only versions 2 and 3 have an item type
+		if entry.kind != "infe" || len(entry.data) < 4 || entry.data[0] < 2 {
+			continue
+		}
+
+		var id uint32
+
+		at := 4
+
+		if entry.data[0] == 2 {
+			if at+2 > len(entry.data) {
+				return nil, errors.New("problem reading avif metadata")
+			}
+			id = uint32(binary.BigEndian.Uint16(entry.data[at:]))
+			at += 2
+		} else {
+			if at+4 > len(entry.data) {
+				return nil, errors.New("problem reading avif metadata")
+			}
+			id = binary.BigEndian.Uint32(entry.data[at:])
+			at += 4
+		}
+
+		// protection index then the type
+		at += 2
+		if at+4 > len(entry.data) {
+			return nil, errors.New("problem reading avif metadata")
+		}
+
+		kind := string(entry.data[at : at+4])
+		at += 4
+
+		switch kind {
+		case "Exif":
+			items[id] = true
+		case "mime":
+			// the item name comes before the content type
+			fields := bytes.SplitN(entry.data[at:], []byte{0}, 3)
+			if len(fields) > 1 && string(fields[1]) == avifXMPType {
+				items[id] = true
+			}
+		}
+	}
+
+	return
+}
+
+// avifBlankItems zeroes the extents of the items listed in an iloc box
+func avifBlankItems(iloc []byte, items map[uint32]bool, file, idat []byte) (err error) {
+	if len(iloc) < 6 {
+		return errors.New("problem reading avif metadata")
+	}
+
+	version := iloc[0]
+	offsetSize := int(iloc[4] >> 4)
+	lengthSize := int(iloc[4] & 0x0F)
+	baseOffsetSize := int(iloc[5] >> 4)
+
+	var indexSize int
+	if version == 1 || version == 2 {
+		indexSize = int(iloc[5] & 0x0F)
+	}
+
+	r := isoReader{data: iloc, pos: 6}
+
+	var count uint64
+	if version < 2 {
+		count = r.uint(2)
+	} else {
+		count = r.uint(4)
+	}
+
+	for range count {
+		var id uint64
+		if version < 2 {
+			id = r.uint(2)
+		} else {
+			id = r.uint(4)
+		}
+
+		var method uint64
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0x0F
+		}
+
+		// data reference index
+		r.uint(2)
+
+		base := r.uint(baseOffsetSize)
+		extents := r.uint(2)
+
+		for range extents {
+			r.uint(indexSize)
+			offset := base + r.uint(offsetSize)
+			length := r.uint(lengthSize)
+
+			if r.err != nil {
+				return r.err
+			}
+
+			if !items[uint32(id)] {
+				continue
+			}
+
+			var target []byte
+			switch method {
+			case 0:
+				target = file
+			case 1:
+				target = idat
+			default:
+				return errors.New("problem reading avif metadata")
+			}
+
+			// a zero length runs to the end
+			if offset > uint64(len(target)) || length > uint64(len(target))-offset {
+				return errors.New("problem reading avif metadata")
+			}
+			if length == 0 {
+				length = uint64(len(target)) - offset
+			}
+
+			clear(target[offset : offset+length])
+		}
+	}
+
+	return r.err
+}
+
+// isoReader reads the variable sized fields of a box
+type isoReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// uint reads a big endian value of 0, 2, 4 or 8 bytes
+func (r *isoReader) uint(size int) (value uint64) {
+	if r.err != nil {
+		return
+	}
+
+	if r.pos+size > len(r.data) {
+		r.err = errors.New("problem reading avif metadata")
+		return
+	}
+
+	for _, b := range r.data[r.pos : r.pos+size] {
+		value = value<<8 | uint64(b)
+	}
+
+	r.pos += size
+
+	return
+}
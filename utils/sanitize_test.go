@@ -0,0 +1,424 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// testExif builds an app1 segment with an orientation tag
+func testExif(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+// testHalves makes an image with a red left half and a blue right half
+func testHalves(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for x := range width {
+		for y := range height {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	return img
+}
+
+// insertAfter splices extra bytes into data at pos
+func insertAfter(data []byte, pos int, extra []byte) []byte {
+	out := append([]byte{}, data[:pos]...)
+	out = append(out, extra...)
+	return append(out, data[pos:]...)
+}
+
+func TestJpegOrientation(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&b, testHalves(40, 20), nil))
+
+	orientation, err := jpegOrientation(b.Bytes())
+	assert.NoError(t, err, "An error was not expected")
+	assert.Equal(t, 1, orientation, "No exif should be the default")
+
+	tagged := insertAfter(b.Bytes(), 2, testExif(6))
+	orientation, err = jpegOrientation(tagged)
+	assert.NoError(t, err, "An error was not expected")
+	assert.Equal(t, 6, orientation, "Orientation should match")
+}
+
+func TestJpegShortSegment(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&b, testHalves(40, 20), nil))
+
+	// segment lengths count their own two bytes so 0 and 1 are malformed
+	for _, length := range []byte{0, 1} {
+		bad := insertAfter(b.Bytes(), 2, []byte{0xFF, 0xE1, 0x00, length})
+
+		_, err := jpegOrientation(bad)
+		assert.Equal(t, errJPEGSegment, err, "Error should match")
+
+		_, err = stripJPEG(bad)
+		assert.Equal(t, errJPEGSegment, err, "Error should match")
+
+		_, err = sanitizeJPEG(bad)
+		assert.Equal(t, errJPEGSegment, err, "Error should match")
+	}
+}
+
+func TestSanitizeJpegOrientation(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&b, testHalves(40, 20), &jpeg.Options{Quality: 100}))
+
+	img := ImageType{
+		Ib:   1,
+		mime: "image/jpeg",
+	}
+
+	testUpload(t, &img, insertAfter(b.Bytes(), 2, testExif(6)))
+
+	err := img.sanitize()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.True(t, img.sanitized, "Image should be sanitized")
+		clean := testTempBytes(t, &img)
+
+		assert.False(t, bytes.Contains(clean, []byte("Exif")), "Exif should be removed")
+
+		sum := md5.Sum(clean)
+		assert.Equal(t, hex.EncodeToString(sum[:]), img.MD5, "Hash should match the sanitized bytes")
+
+		rotated, err := jpeg.Decode(bytes.NewReader(clean))
+		if assert.NoError(t, err, "An error was not expected") {
+			assert.Equal(t, 20, rotated.Bounds().Dx(), "Width should be swapped")
+			assert.Equal(t, 40, rotated.Bounds().Dy(), "Height should be swapped")
+
+			// rotating clockwise puts the left side on top
+			r, _, bl, _ := rotated.At(10, 5).RGBA()
+			assert.Greater(t, r, bl, "Top should be red")
+			r, _, bl, _ = rotated.At(10, 35).RGBA()
+			assert.Greater(t, bl, r, "Bottom should be blue")
+		}
+	}
+}
+
+func TestSanitizeJpegStrip(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&b, testHalves(40, 20), nil))
+
+	original := b.Bytes()
+
+	comment := []byte{0xFF, 0xFE, 0x00, 0x07, 'h', 'e', 'l', 'l', 'o'}
+	dirty := insertAfter(original, 2, append(testExif(1), comment...))
+
+	clean, err := sanitizeJPEG(dirty)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, original, clean, "Image data should not be reencoded")
+	}
+
+	// icc profiles are kept
+	icc := append([]byte{0xFF, 0xE2, 0x00, 0x10}, []byte("ICC_PROFILE\x00\x01\x01")...)
+	withProfile := insertAfter(original, 2, icc)
+
+	clean, err = sanitizeJPEG(withProfile)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, withProfile, clean, "Color profile should be kept")
+	}
+
+	_, err = sanitizeJPEG([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF})
+	assert.Error(t, err, "An error was expected")
+}
+
+func TestSanitizePng(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, png.Encode(&b, testHalves(16, 16)))
+
+	original := b.Bytes()
+
+	text := []byte("Comment\x00secret location")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(chunk, "tEXt"...)
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(append([]byte("tEXt"), text...)))
+
+	// after the signature and the IHDR chunk
+	dirty := insertAfter(original, 33, chunk)
+	// trailing data after IEND
+	dirty = append(dirty, "payload"...)
+
+	img := ImageType{
+		Ib:   1,
+		mime: "image/png",
+	}
+
+	testUpload(t, &img, dirty)
+
+	err := img.sanitize()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.True(t, img.sanitized, "Image should be sanitized")
+		assert.Equal(t, original, testTempBytes(t, &img), "Metadata should be removed")
+	}
+
+	_, err = png.Decode(bytes.NewReader(testTempBytes(t, &img)))
+	assert.NoError(t, err, "An error was not expected")
+}
+
+func TestSanitizeGif(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, gif.Encode(&b, testHalves(16, 16), nil))
+
+	original := b.Bytes()
+
+	comment := []byte{0x21, 0xFE, 0x05, 'h', 'e', 'l', 'l', 'o', 0x00}
+	dirty := insertAfter(original, len(original)-1, comment)
+
+	clean, err := stripGIF(dirty)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, original, clean, "Comment should be removed")
+	}
+
+	_, err = gif.Decode(bytes.NewReader(clean))
+	assert.NoError(t, err, "An error was not expected")
+
+	// loop extensions are kept
+	loop := append([]byte{0x21, 0xFF, 0x0B}, "NETSCAPE2.0"...)
+	loop = append(loop, 0x03, 0x01, 0x00, 0x00, 0x00)
+	looped := insertAfter(original, len(original)-1, loop)
+
+	clean, err = stripGIF(looped)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, looped, clean, "Loop extension should be kept")
+	}
+
+	_, err = stripGIF(original[:len(original)-10])
+	assert.Error(t, err, "An error was expected")
+}
+
+// testWebPMetadata wraps the still image with exif and xmp chunks
+func testWebPMetadata(flags byte, extra ...[]byte) []byte {
+	still, _ := base64.StdEncoding.DecodeString(testWebPStill)
+
+	body := []byte("WEBP")
+	body = append(body, testChunk("VP8X", []byte{flags, 0, 0, 0, 0, 0, 0, 0, 0, 0})...)
+	body = append(body, still[12:]...)
+	for _, chunk := range extra {
+		body = append(body, chunk...)
+	}
+
+	out := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(out, body...)
+}
+
+func TestSanitizeWebP(t *testing.T) {
+	exif := testChunk("EXIF", []byte("II*\x00secret location"))
+	xmp := testChunk("XMP ", []byte("<x:xmpmeta>secret</x:xmpmeta>"))
+
+	dirty := testWebPMetadata(webpEXIFBit|webpXMPBit, exif, xmp)
+
+	img := ImageType{
+		Ib:   1,
+		mime: "image/webp",
+	}
+
+	testUpload(t, &img, dirty)
+
+	err := img.sanitize()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.True(t, img.sanitized, "Image should be sanitized")
+
+		clean := testTempBytes(t, &img)
+		assert.Equal(t, testWebPMetadata(0), clean, "Metadata chunks and flags should be removed")
+		assert.False(t, bytes.Contains(clean, []byte("secret")), "Metadata should be removed")
+
+		sum := md5.Sum(clean)
+		assert.Equal(t, hex.EncodeToString(sum[:]), img.MD5, "Hash should match the sanitized bytes")
+		sha := sha256.Sum256(clean)
+		assert.Equal(t, hex.EncodeToString(sha[:]), img.SHA256, "Hash should match the sanitized bytes")
+
+		_, err = webp.Decode(bytes.NewReader(clean))
+		assert.NoError(t, err, "An error was not expected")
+	}
+
+	// files without metadata are left alone
+	still, _ := base64.StdEncoding.DecodeString(testWebPStill)
+
+	clean, err := stripWebP(still)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, still, clean, "Clean file should not change")
+	}
+
+	_, err = stripWebP(testJpeg(10).Bytes())
+	assert.Error(t, err, "An error was expected")
+}
+
+// testAVIFMetadata builds an avif with an image, exif and xmp item in its mdat
+func testAVIFMetadata(ilocVersion byte) []byte {
+	infe := func(id uint16, kind string, extra ...byte) []byte {
+		data := []byte{2, 0, 0, 0}
+		data = binary.BigEndian.AppendUint16(data, id)
+		data = append(data, 0, 0)
+		data = append(data, kind...)
+		data = append(data, 0)
+		return testBox("infe", data, extra)
+	}
+
+	iinf := testBox("iinf", []byte{0, 0, 0, 0, 0, 3},
+		infe(1, "av01"),
+		infe(2, "Exif"),
+		infe(3, "mime", append([]byte(avifXMPType), 0)...))
+
+	payloads := [][]byte{[]byte("image data"), []byte("exif secret"), []byte("xmp secret")}
+
+	build := func(mdatStart uint32) []byte {
+		// four byte offsets and lengths
+		data := []byte{ilocVersion, 0, 0, 0, 0x44, 0x00}
+		data = binary.BigEndian.AppendUint16(data, 3)
+
+		offset := mdatStart
+		for n, payload := range payloads {
+			data = binary.BigEndian.AppendUint16(data, uint16(n+1))
+			if ilocVersion == 1 {
+				// file offset construction
+				data = append(data, 0, 0)
+			}
+			data = append(data, 0, 0, 0, 1)
+			data = binary.BigEndian.AppendUint32(data, offset)
+			data = binary.BigEndian.AppendUint32(data, uint32(len(payload)))
+			offset += uint32(len(payload))
+		}
+
+		ftyp := testBox("ftyp", []byte("avif"), []byte{0, 0, 0, 0}, []byte("mif1miaf"))
+		meta := testBox("meta", []byte{0, 0, 0, 0}, testBox("hdlr", make([]byte, 24)), iinf, testBox("iloc", data), testBox("iprp", testBox("ipco", testISPE(100, 100))))
+		return append(append(ftyp, meta...), testBox("mdat", payloads...)...)
+	}
+
+	// the mdat data starts after its header
+	return build(uint32(len(build(0)) - len(bytes.Join(payloads, nil))))
+}
+
+func TestSanitizeAVIF(t *testing.T) {
+	for _, version := range []byte{0, 1} {
+		dirty := testAVIFMetadata(version)
+
+		img := ImageType{
+			Ib:   1,
+			mime: "image/avif",
+		}
+
+		testUpload(t, &img, dirty)
+
+		err := img.sanitize()
+		if assert.NoError(t, err, "An error was not expected") {
+			assert.True(t, img.sanitized, "Image should be sanitized")
+
+			clean := testTempBytes(t, &img)
+			assert.Len(t, clean, len(dirty), "Offsets should not change")
+			assert.False(t, bytes.Contains(clean, []byte("secret")), "Metadata should be removed")
+			assert.True(t, bytes.Contains(clean, []byte("image data")), "Image data should be kept")
+
+			sha := sha256.Sum256(clean)
+			assert.Equal(t, hex.EncodeToString(sha[:]), img.SHA256, "Hash should match the sanitized bytes")
+
+			width, height, err := avifDimensions(clean)
+			assert.NoError(t, err, "An error was not expected")
+			assert.Equal(t, 100, width, "Width should match")
+			assert.Equal(t, 100, height, "Height should match")
+		}
+	}
+
+	// files without metadata items are left alone
+	plain := testAVIF("avif", testISPE(100, 100))
+
+	clean, err := stripAVIF(plain)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, plain, clean, "Clean file should not change")
+	}
+
+	// truncated files are rejected
+	bad := testAVIFMetadata(0)
+	_, err = stripAVIF(bad[:len(bad)-4])
+	assert.Error(t, err, "An error was expected")
+}
+
+func TestSanitizeKeepOriginal(t *testing.T) {
+	original := local.Settings.Uploads.KeepOriginal
+	defer func() { local.Settings.Uploads.KeepOriginal = original }()
+
+	local.Settings.Uploads.KeepOriginal = []uint{2}
+
+	var b bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&b, testHalves(40, 20), nil))
+
+	dirty := insertAfter(b.Bytes(), 2, testExif(6))
+
+	img := ImageType{
+		Ib:   2,
+		mime: "image/jpeg",
+	}
+
+	testUpload(t, &img, dirty)
+
+	err := img.sanitize()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.False(t, img.sanitized, "Image should not be sanitized")
+		assert.Equal(t, dirty, testTempBytes(t, &img), "Original bytes should be kept")
+	}
+
+	// avatars are always cleaned
+	img.avatar = true
+
+	err = img.sanitize()
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.True(t, img.sanitized, "Avatar should be sanitized")
+	}
+}
+
+func TestSanitizeJpegOrientationQueueFull(t *testing.T) {
+	original := mediaPool
+	defer func() { mediaPool = original }()
+
+	mediaPool = NewWorkerPool(1, 1)
+
+	// every place in line is taken
+	mediaPool.tickets <- struct{}{}
+	mediaPool.tickets <- struct{}{}
+
+	var b bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&b, testHalves(40, 20), nil))
+
+	_, err := sanitizeJPEG(insertAfter(b.Bytes(), 2, testExif(6)))
+	assert.ErrorIs(t, err, ErrQueueFull, "Reorienting should wait for a worker")
+
+	// stripping does not decode so it does not need one
+	_, err = sanitizeJPEG(b.Bytes())
+	assert.NoError(t, err, "An error was not expected")
+}
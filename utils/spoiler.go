@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// SpoilerProfile is the profile recorded for blurred spoiler thumbnails
+const SpoilerProfile = "spoiler"
+
+// how far the thumbnail is shrunk before scaling back up, bigger is blurrier
+const spoilerBlurFactor = 16
+
+// createSpoilerThumbnail makes a blurred copy of the thumbnail for spoilered images
+func (i *ImageType) createSpoilerThumbnail() (err error) {
+
+	if !i.Spoiler || i.avatar {
+		return
+	}
+
+	thumbDir := local.Settings.Directories.ThumbnailDir
+
+	filename := fmt.Sprintf("%s-%s.jpg", strings.TrimSuffix(i.Filename, i.Ext), SpoilerProfile)
+
+	// record it first so a partial file is cleaned up
+	i.Thumbnails = append(i.Thumbnails, ThumbnailFile{
+		Profile:  SpoilerProfile,
+		Filename: filename,
+		Filepath: filepath.Join(thumbDir, filename),
+		Width:    i.ThumbWidth,
+		Height:   i.ThumbHeight,
+	})
+
+	return mediaPool.Do(func() error {
+		return blurThumbnail(thumbDir, i.Thumbnail, filename)
+	})
+}
+
+// createSpoilerProfile makes a blurred copy of a profile thumbnail
+func (i *ImageType) createSpoilerProfile(thumb ThumbnailFile, base, ext string) (err error) {
+
+	filename := fmt.Sprintf("%s-%s-%s%s", base, thumb.Profile, SpoilerProfile, ext)
+
+	// record it first so a partial file is cleaned up
+	i.Thumbnails = append(i.Thumbnails, ThumbnailFile{
+		Profile:  fmt.Sprintf("%s-%s", thumb.Profile, SpoilerProfile),
+		Filename: filename,
+		Filepath: filepath.Join(local.Settings.Directories.ThumbnailDir, filename),
+		Width:    thumb.Width,
+		Height:   thumb.Height,
+	})
+
+	return mediaPool.Do(func() error {
+		return blurThumbnail(local.Settings.Directories.ThumbnailDir, thumb.Filename, filename)
+	})
+}
+
+// blurThumbnail shrinks the thumbnail down and scales it back up to wash out the detail
+// dst keeps the format of its extension
+func blurThumbnail(dir, src, dst string) (err error) {
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer root.Close()
+
+	in, err := root.Open(src)
+	if err != nil {
+		return errors.New("problem opening thumbnail")
+	}
+	defer in.Close()
+
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return errors.New("problem decoding thumbnail")
+	}
+
+	bounds := img.Bounds()
+
+	small := image.NewRGBA(image.Rect(0, 0, max(1, bounds.Dx()/spoilerBlurFactor), max(1, bounds.Dy()/spoilerBlurFactor)))
+
+	// white background for transparent thumbnails
+	draw.Draw(small, small.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, bounds, draw.Over, nil)
+
+	blurred := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.BiLinear.Scale(blurred, blurred.Bounds(), small, small.Bounds(), draw.Src, nil)
+
+	out, err := root.Create(dst)
+	if err != nil {
+		return errors.New("problem creating spoiler thumbnail file")
+	}
+	defer out.Close()
+
+	if strings.ToLower(filepath.Ext(dst)) == ".png" {
+		err = png.Encode(out, blurred)
+	} else {
+		err = jpeg.Encode(out, blurred, &jpeg.Options{Quality: 75})
+	}
+	if err != nil {
+		return errors.New("problem making spoiler thumbnail")
+	}
+
+	return
+}
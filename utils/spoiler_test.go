@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+func TestCreateSpoilerThumbnail(t *testing.T) {
+	img := testProfileImage(t)
+	img.Spoiler = true
+
+	err := img.createThumbnail(200, 200)
+	if assert.NoError(t, err, "An error was not expected") && assert.Len(t, img.Thumbnails, 1, "The spoiler should be made") {
+		base := img.Filename[:len(img.Filename)-len(img.Ext)]
+
+		spoiler := img.Thumbnails[0]
+		assert.Equal(t, SpoilerProfile, spoiler.Profile, "Profile should match")
+		assert.Equal(t, base+"-spoiler.jpg", spoiler.Filename, "Filename should match")
+		assert.Equal(t, img.ThumbWidth, spoiler.Width, "Width should match the thumbnail")
+		assert.Equal(t, img.ThumbHeight, spoiler.Height, "Height should match the thumbnail")
+
+		file, err := os.Open(spoiler.Filepath)
+		if assert.NoError(t, err, "Spoiler should exist") {
+			config, err := jpeg.DecodeConfig(file)
+			file.Close()
+			assert.NoError(t, err, "An error was not expected")
+			assert.Equal(t, img.ThumbWidth, config.Width, "Width should match")
+		}
+	}
+
+	// failed uploads remove the spoiler too
+	img.cleanupFiles()
+
+	for _, thumb := range img.Thumbnails {
+		_, err := os.Stat(thumb.Filepath)
+		assert.True(t, os.IsNotExist(err), "Spoiler should be removed")
+	}
+}
+
+func TestCreateSpoilerProfileThumbnails(t *testing.T) {
+	original := local.Settings.Uploads.Thumbnails
+	defer func() { local.Settings.Uploads.Thumbnails = original }()
+
+	local.Settings.Uploads.Thumbnails = []local.ThumbnailProfile{
+		{Name: "catalog", MaxWidth: 128, MaxHeight: 128, Quality: 90},
+		{Name: "preview", MaxWidth: 256, MaxHeight: 256, Format: "png"},
+	}
+
+	img := &ImageType{
+		Ib:      1,
+		Ext:     ".png",
+		mime:    "image/png",
+		MD5:     "test",
+		Spoiler: true,
+	}
+
+	var b bytes.Buffer
+	assert.NoError(t, png.Encode(&b, testHalves(256, 128)), "An error was not expected")
+	testUpload(t, img, b.Bytes())
+
+	img.OrigWidth = 256
+	img.OrigHeight = 128
+
+	assert.NoError(t, os.MkdirAll(local.Settings.Directories.ThumbnailDir, 0755), "Failed to ensure thumbnail directory exists")
+	assert.NoError(t, img.saveFile(), "An error was not expected")
+
+	t.Cleanup(img.cleanupFiles)
+
+	err := img.createProfileThumbnails()
+	if !assert.NoError(t, err, "An error was not expected") || !assert.Len(t, img.Thumbnails, 4, "Every profile should get a blurred copy") {
+		return
+	}
+
+	base := img.Filename[:len(img.Filename)-len(img.Ext)]
+
+	assert.Equal(t, "catalog", img.Thumbnails[0].Profile, "Profile should match")
+	assert.Equal(t, "catalog-spoiler", img.Thumbnails[1].Profile, "Profile should match")
+	assert.Equal(t, base+"-catalog-spoiler.jpg", img.Thumbnails[1].Filename, "Filename should match")
+	assert.Equal(t, "preview", img.Thumbnails[2].Profile, "Profile should match")
+	assert.Equal(t, "preview-spoiler", img.Thumbnails[3].Profile, "Profile should match")
+	assert.Equal(t, base+"-preview-spoiler.png", img.Thumbnails[3].Filename, "Filename should match")
+
+	for n, thumb := range img.Thumbnails {
+		file, err := os.Open(thumb.Filepath)
+		if !assert.NoError(t, err, "Thumbnail should exist") {
+			continue
+		}
+
+		decoded, format, err := image.Decode(file)
+		file.Close()
+
+		if !assert.NoError(t, err, "An error was not expected") {
+			continue
+		}
+
+		assert.Equal(t, strings.TrimPrefix(filepath.Ext(thumb.Filename), "."), strings.Replace(format, "jpeg", "jpg", 1), "Format should match the extension")
+
+		bounds := decoded.Bounds()
+
+		if n%2 == 1 {
+			// the hard edge between the halves is washed out in the blurred copies
+			assert.Equal(t, img.Thumbnails[n-1].Width, thumb.Width, "Width should match the clear thumbnail")
+			r, _, b, _ := decoded.At(bounds.Dx()/2, bounds.Dy()/2).RGBA()
+			assert.Greater(t, r>>8, uint32(40), "Edge should mix in red")
+			assert.Greater(t, b>>8, uint32(40), "Edge should mix in blue")
+		} else {
+			// the clear sizes are kept so removing the spoiler shows them
+			r, _, b, _ := decoded.At(bounds.Dx()/4, bounds.Dy()/2).RGBA()
+			assert.Greater(t, r>>8, uint32(200), "Left half should stay red")
+			assert.Less(t, b>>8, uint32(40), "Left half should not mix in blue")
+		}
+	}
+}
+
+func TestCreateSpoilerThumbnailSkipped(t *testing.T) {
+	img := testProfileImage(t)
+
+	err := img.createThumbnail(200, 200)
+	assert.NoError(t, err, "An error was not expected")
+	assert.Empty(t, img.Thumbnails, "Only spoilered images get a blurred thumbnail")
+
+	avatar := ImageType{Spoiler: true, avatar: true}
+	assert.NoError(t, avatar.createSpoilerThumbnail(), "An error was not expected")
+	assert.Empty(t, avatar.Thumbnails, "Avatars are never spoilered")
+}
+
+func TestBlurThumbnail(t *testing.T) {
+	dir := local.Settings.Directories.ThumbnailDir
+	assert.NoError(t, os.MkdirAll(dir, 0755), "Failed to ensure thumbnail directory exists")
+
+	src, err := os.Create(filepath.Join(dir, "blurtest.png"))
+	if !assert.NoError(t, err, "An error was not expected") {
+		return
+	}
+	assert.NoError(t, png.Encode(src, testHalves(128, 64)), "An error was not expected")
+	src.Close()
+
+	defer os.Remove(src.Name())
+	defer os.Remove(filepath.Join(dir, "blurtest-spoiler.jpg"))
+
+	assert.NoError(t, blurThumbnail(dir, "blurtest.png", "blurtest-spoiler.jpg"), "An error was not expected")
+
+	file, err := os.Open(filepath.Join(dir, "blurtest-spoiler.jpg"))
+	if !assert.NoError(t, err, "Spoiler should exist") {
+		return
+	}
+	defer file.Close()
+
+	blurred, err := jpeg.Decode(file)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, 128, blurred.Bounds().Dx(), "Width should match")
+		assert.Equal(t, 64, blurred.Bounds().Dy(), "Height should match")
+
+		// the hard edge between the halves is washed out
+		r, _, b, _ := blurred.At(64, 32).RGBA()
+		assert.Greater(t, r>>8, uint32(40), "Edge should mix in red")
+		assert.Greater(t, b>>8, uint32(40), "Edge should mix in blue")
+	}
+
+	assert.Error(t, blurThumbnail(dir, "missing.png", "missing-spoiler.jpg"), "An error was expected")
+}
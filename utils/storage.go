@@ -0,0 +1,303 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/eirka/eirka-libs/config"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// Storage backend names
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+// Storage areas for the different kinds of files
+const (
+	AreaImages     = "src"
+	AreaThumbnails = "thumb"
+	AreaAvatars    = "avatars"
+)
+
+// Storage defines a backend for uploaded files
+type Storage interface {
+	// Put writes the contents of r to name
+	Put(name, contentType string, r io.Reader, size int64) (err error)
+	// Get opens name for reading
+	Get(name string) (io.ReadCloser, error)
+	// Delete removes name
+	Delete(name string) (err error)
+	// Stat returns info about name, fs.ErrNotExist if it is missing
+	Stat(name string) (StorageInfo, error)
+}
+
+// StorageInfo describes a stored file
+type StorageInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// NewStorage returns the configured storage backend for an area
+func NewStorage(area string) (Storage, error) {
+	switch local.Settings.Storage.Backend {
+	case "", StorageLocal:
+		dir, err := localDir(area)
+		if err != nil {
+			return nil, err
+		}
+		return &LocalStorage{Dir: dir}, nil
+	case StorageS3:
+		if !config.Settings.Amazon.Configured {
+			return nil, errors.New("s3 storage is not configured")
+		}
+		return &S3Storage{
+			Endpoint:  local.Settings.Storage.Endpoint,
+			Region:    config.Settings.Amazon.Region,
+			Bucket:    config.Settings.Amazon.Bucket,
+			AccessKey: config.Settings.Amazon.ID,
+			SecretKey: config.Settings.Amazon.Key,
+			Prefix:    area + "/",
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown storage backend: %s", local.Settings.Storage.Backend)
+}
+
+// localDir maps a storage area to its local directory
+func localDir(area string) (string, error) {
+	switch area {
+	case AreaImages:
+		return local.Settings.Directories.ImageDir, nil
+	case AreaThumbnails:
+		return local.Settings.Directories.ThumbnailDir, nil
+	case AreaAvatars:
+		return local.Settings.Directories.AvatarDir, nil
+	}
+
+	return "", fmt.Errorf("unknown storage area: %s", area)
+}
+
+// isLocalStorage is true when the local directories are the final location
+func isLocalStorage() bool {
+	backend := local.Settings.Storage.Backend
+	return backend == "" || backend == StorageLocal
+}
+
+// LocalStorage keeps files in a directory on this machine
+type LocalStorage struct {
+	Dir string
+}
+
+var _ = Storage(&LocalStorage{})
+
+// Put writes the contents of r to name
+func (s *LocalStorage) Put(name, contentType string, r io.Reader, size int64) (err error) {
+	// Open the dir with traversal-resistant root
+	root, err := os.OpenRoot(s.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer root.Close()
+
+	file, err := root.Create(name)
+	if err != nil {
+		return fmt.Errorf("problem creating file: %v", err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r)
+	if err != nil {
+		return fmt.Errorf("problem writing to file: %v", err)
+	}
+
+	return
+}
+
+// Get opens name for reading
+func (s *LocalStorage) Get(name string) (io.ReadCloser, error) {
+	return os.OpenInRoot(s.Dir, name)
+}
+
+// Delete removes name, it will not remove directories
+func (s *LocalStorage) Delete(name string) (err error) {
+	root, err := os.OpenRoot(s.Dir)
+	if err != nil {
+		return
+	}
+	defer root.Close()
+
+	// Extra validation to ensure we're only dealing with files
+	fileInfo, err := root.Stat(name)
+	if err != nil {
+		return
+	}
+
+	if fileInfo.IsDir() {
+		return errors.New("will not delete a directory")
+	}
+
+	return root.Remove(name)
+}
+
+// Stat returns info about name
+func (s *LocalStorage) Stat(name string) (info StorageInfo, err error) {
+	root, err := os.OpenRoot(s.Dir)
+	if err != nil {
+		return
+	}
+	defer root.Close()
+
+	fileInfo, err := root.Stat(name)
+	if err != nil {
+		return
+	}
+
+	if fileInfo.IsDir() {
+		return info, fs.ErrNotExist
+	}
+
+	return StorageInfo{
+		Name:    name,
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime(),
+	}, nil
+}
+
+// publishFiles copies the processed files from the local working directories
+// to the configured storage and removes the local copies
+func (i *ImageType) publishFiles() (err error) {
+
+	// the working directories are the final location
+	if isLocalStorage() {
+		return
+	}
+
+	var published []storedFile
+
+	for _, upload := range i.storedFiles() {
+		err = upload.publish()
+		if err != nil {
+			// remove anything that already made it to storage
+			for _, file := range published {
+				file.unpublish()
+			}
+			return
+		}
+		published = append(published, upload)
+	}
+
+	// the local copies are no longer needed
+	i.cleanupFiles()
+
+	return
+}
+
+// DeleteFiles removes a saved upload from disk and storage
+// used when a post fails after its files were already saved
+func (i *ImageType) DeleteFiles() {
+
+	i.cleanupFiles()
+
+	if isLocalStorage() {
+		return
+	}
+
+	for _, file := range i.storedFiles() {
+		file.unpublish()
+	}
+}
+
+// DeletePostedImage removes the files of an image that was on a post
+func DeletePostedImage(filename, thumbnail string, thumbnails []string) {
+
+	i := &ImageType{
+		Filename:  filename,
+		Filepath:  filepath.Join(local.Settings.Directories.ImageDir, filename),
+		Thumbnail: thumbnail,
+		Thumbpath: filepath.Join(local.Settings.Directories.ThumbnailDir, thumbnail),
+	}
+
+	for _, thumb := range thumbnails {
+		i.Thumbnails = append(i.Thumbnails, ThumbnailFile{
+			Filename: thumb,
+			Filepath: filepath.Join(local.Settings.Directories.ThumbnailDir, thumb),
+		})
+	}
+
+	i.DeleteFiles()
+}
+
+// storedFiles lists every file the upload puts in storage
+func (i *ImageType) storedFiles() []storedFile {
+
+	// avatars only keep the thumbnail
+	if i.avatar {
+		return []storedFile{
+			{area: AreaAvatars, dir: local.Settings.Directories.AvatarDir, name: i.Thumbnail},
+		}
+	}
+
+	files := []storedFile{
+		{area: AreaImages, dir: local.Settings.Directories.ImageDir, name: i.Filename},
+		{area: AreaThumbnails, dir: local.Settings.Directories.ThumbnailDir, name: i.Thumbnail},
+	}
+
+	for _, thumb := range i.Thumbnails {
+		files = append(files, storedFile{area: AreaThumbnails, dir: local.Settings.Directories.ThumbnailDir, name: thumb.Filename})
+	}
+
+	return files
+}
+
+// storedFile is a local file on its way to storage
+type storedFile struct {
+	area string
+	dir  string
+	name string
+}
+
+// publish copies the local file to storage
+func (f storedFile) publish() (err error) {
+	store, err := NewStorage(f.area)
+	if err != nil {
+		return
+	}
+
+	file, err := os.OpenInRoot(f.dir, f.name)
+	if err != nil {
+		return fmt.Errorf("problem opening file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("problem reading file: %v", err)
+	}
+
+	err = store.Put(f.name, mime.TypeByExtension(filepath.Ext(f.name)), file, info.Size())
+	if err != nil {
+		return fmt.Errorf("problem storing file: %v", err)
+	}
+
+	return
+}
+
+// unpublish removes the file from storage
+func (f storedFile) unpublish() {
+	store, err := NewStorage(f.area)
+	if err != nil {
+		return
+	}
+
+	_ = store.Delete(f.name)
+}
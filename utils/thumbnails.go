@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// ThumbnailFile is an extra thumbnail made from a profile
+type ThumbnailFile struct {
+	Profile  string
+	Filename string
+	Filepath string
+	Width    int
+	Height   int
+}
+
+// profile names end up in filenames
+var validProfileName = regexp.MustCompile(`^[a-z0-9]{1,12}$`)
+
+// thumbnail formats and their extensions, the processors pick the encoder from the extension
+var thumbnailFormats = map[string]string{
+	"":     ".jpg",
+	"jpg":  ".jpg",
+	"jpeg": ".jpg",
+	"png":  ".png",
+}
+
+// createProfileThumbnails makes a thumbnail for every configured profile
+func (i *ImageType) createProfileThumbnails() (err error) {
+
+	// avatars only have the one size
+	if i.avatar {
+		return
+	}
+
+	base := strings.TrimSuffix(i.Filename, i.Ext)
+
+	for _, profile := range local.Settings.Uploads.Thumbnails {
+		ext, ok := thumbnailFormats[strings.ToLower(profile.Format)]
+		if !ok || !validProfileName.MatchString(profile.Name) || profile.Name == AnimatedProfile || profile.Name == SpoilerProfile || profile.MaxWidth <= 0 || profile.MaxHeight <= 0 {
+			return fmt.Errorf("invalid thumbnail profile %q", profile.Name)
+		}
+
+		quality := profile.Quality
+		if quality <= 0 || quality > 100 {
+			quality = 90
+		}
+
+		filename := fmt.Sprintf("%s-%s%s", base, profile.Name, ext)
+
+		// record it first so a partial file is cleaned up
+		i.Thumbnails = append(i.Thumbnails, ThumbnailFile{
+			Profile:  profile.Name,
+			Filename: filename,
+			Filepath: filepath.Join(local.Settings.Directories.ThumbnailDir, filename),
+		})
+
+		thumb := &i.Thumbnails[len(i.Thumbnails)-1]
+
+		thumb.Width, thumb.Height, err = i.makeThumbnail(thumb.Filepath, local.Settings.Directories.ThumbnailDir, thumb.Filename, ThumbnailOptions{
+			MaxWidth:   profile.MaxWidth,
+			MaxHeight:  profile.MaxHeight,
+			OrigWidth:  i.OrigWidth,
+			OrigHeight: i.OrigHeight,
+			Quality:    quality,
+		})
+		if err != nil {
+			return
+		}
+
+		// spoilered images get a blurred copy of every size, the clear one is kept for when the spoiler is removed
+		if i.Spoiler {
+			err = i.createSpoilerProfile(*thumb, base, ext)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
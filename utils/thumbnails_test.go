@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"image"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// testProfileImage saves a jpeg to the image directory
+func testProfileImage(t *testing.T) *ImageType {
+	t.Helper()
+
+	img := &ImageType{
+		Ib:   1,
+		Ext:  ".jpg",
+		mime: "image/jpeg",
+		MD5:  "test",
+	}
+
+	testUpload(t, img, testJpeg(400).Bytes())
+
+	img.OrigWidth = 400
+	img.OrigHeight = 400
+
+	assert.NoError(t, os.MkdirAll(local.Settings.Directories.ThumbnailDir, 0755), "Failed to ensure thumbnail directory exists")
+	assert.NoError(t, img.saveFile(), "An error was not expected")
+
+	t.Cleanup(img.cleanupFiles)
+
+	return img
+}
+
+func TestCreateProfileThumbnails(t *testing.T) {
+	original := local.Settings.Uploads.Thumbnails
+	defer func() { local.Settings.Uploads.Thumbnails = original }()
+
+	local.Settings.Uploads.Thumbnails = []local.ThumbnailProfile{
+		{Name: "catalog", MaxWidth: 100, MaxHeight: 100, Quality: 80},
+		{Name: "preview", MaxWidth: 300, MaxHeight: 200, Format: "png"},
+	}
+
+	img := testProfileImage(t)
+
+	err := img.createProfileThumbnails()
+	if assert.NoError(t, err, "An error was not expected") && assert.Len(t, img.Thumbnails, 2, "Both profiles should be made") {
+		base := img.Filename[:len(img.Filename)-len(img.Ext)]
+
+		catalog := img.Thumbnails[0]
+		assert.Equal(t, "catalog", catalog.Profile, "Profile should match")
+		assert.Equal(t, base+"-catalog.jpg", catalog.Filename, "Filename should match")
+		assert.Equal(t, 100, catalog.Width, "Width should match")
+		assert.Equal(t, 100, catalog.Height, "Height should match")
+
+		preview := img.Thumbnails[1]
+		assert.Equal(t, base+"-preview.png", preview.Filename, "Filename should match")
+		assert.Equal(t, 200, preview.Width, "Width should match")
+		assert.Equal(t, 200, preview.Height, "Height should match")
+
+		file, err := os.Open(preview.Filepath)
+		if assert.NoError(t, err, "Thumbnail should exist") {
+			_, format, err := image.DecodeConfig(file)
+			file.Close()
+			assert.NoError(t, err, "An error was not expected")
+			assert.Equal(t, "png", format, "Format should match")
+		}
+	}
+
+	// failed uploads remove every size
+	img.cleanupFiles()
+
+	for _, thumb := range img.Thumbnails {
+		_, err := os.Stat(thumb.Filepath)
+		assert.True(t, os.IsNotExist(err), "Thumbnail should be removed")
+	}
+}
+
+func TestCreateProfileThumbnailsInvalid(t *testing.T) {
+	original := local.Settings.Uploads.Thumbnails
+	defer func() { local.Settings.Uploads.Thumbnails = original }()
+
+	for _, profile := range []local.ThumbnailProfile{
+		{Name: "../bad", MaxWidth: 100, MaxHeight: 100},
+		{Name: "catalog", MaxWidth: 100, MaxHeight: 100, Format: "bmp"},
+		{Name: "catalog", MaxWidth: 0, MaxHeight: 100},
+		{Name: "", MaxWidth: 100, MaxHeight: 100},
+		{Name: SpoilerProfile, MaxWidth: 100, MaxHeight: 100},
+	} {
+		local.Settings.Uploads.Thumbnails = []local.ThumbnailProfile{profile}
+
+		img := testProfileImage(t)
+
+		err := img.createProfileThumbnails()
+		assert.Error(t, err, "An error was expected")
+		assert.Empty(t, img.Thumbnails, "Nothing should be recorded")
+	}
+}
+
+func TestCreateProfileThumbnailsAvatar(t *testing.T) {
+	original := local.Settings.Uploads.Thumbnails
+	defer func() { local.Settings.Uploads.Thumbnails = original }()
+
+	local.Settings.Uploads.Thumbnails = []local.ThumbnailProfile{
+		{Name: "catalog", MaxWidth: 100, MaxHeight: 100},
+	}
+
+	img := ImageType{avatar: true}
+
+	assert.NoError(t, img.createProfileThumbnails(), "An error was not expected")
+	assert.Empty(t, img.Thumbnails, "Avatars should not get extra sizes")
+}
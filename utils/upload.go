@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// headerSize is how much of an upload is kept in memory for type detection
+const headerSize = 512
+
+// temp uploads are hidden so nothing serves them before they are checked
+const tempPattern = ".upload-*"
+
+// headerWriter keeps the first bytes written to it and discards the rest
+type headerWriter struct {
+	buf []byte
+	max int
+}
+
+func (h *headerWriter) Write(p []byte) (int, error) {
+	if n := h.max - len(h.buf); n > 0 {
+		h.buf = append(h.buf, p[:min(n, len(p))]...)
+	}
+
+	return len(p), nil
+}
+
+// writeTemp streams r into a new temp file in the image dir
+// the hashes, size, and header bytes are recorded on the way
+func (i *ImageType) writeTemp(r io.Reader) (err error) {
+
+	// replace any earlier temp file
+	i.removeTemp()
+
+	file, err := os.CreateTemp(local.Settings.Directories.ImageDir, tempPattern)
+	if err != nil {
+		return errors.New("problem creating temp file")
+	}
+	defer file.Close()
+
+	i.tempName = filepath.Base(file.Name())
+
+	hasher := newFileHasher()
+	header := &headerWriter{max: headerSize}
+
+	i.size, err = io.Copy(io.MultiWriter(file, hasher.writer(), header), r)
+	if err != nil {
+		return errors.New("problem copying file")
+	}
+
+	i.header = header.buf
+	hasher.set(i)
+
+	return
+}
+
+// openTemp opens the temp file for reading
+func (i *ImageType) openTemp() (*os.File, error) {
+	if i.tempName == "" {
+		return nil, errors.New("no image data")
+	}
+
+	return os.OpenInRoot(local.Settings.Directories.ImageDir, i.tempName)
+}
+
+// readTemp reads the whole temp file, only used for images which are bounded by the size limit
+func (i *ImageType) readTemp() ([]byte, error) {
+	file, err := i.openTemp()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// removeTemp deletes the temp file if it has not been renamed into place
+func (i *ImageType) removeTemp() {
+	if i.tempName == "" {
+		return
+	}
+
+	store := LocalStorage{Dir: local.Settings.Directories.ImageDir}
+	_ = store.Delete(i.tempName)
+
+	i.tempName = ""
+}
+
+// renameTemp atomically moves the temp file to the final image path
+func (i *ImageType) renameTemp() (err error) {
+	if i.tempName == "" {
+		return errors.New("no image data to save")
+	}
+
+	// both names are generated and live in the image dir
+	err = os.Rename(filepath.Join(local.Settings.Directories.ImageDir, i.tempName), i.Filepath)
+	if err != nil {
+		return errors.New("problem saving file")
+	}
+
+	i.tempName = ""
+
+	// temp files are created private
+	return os.Chmod(i.Filepath, 0644)
+}
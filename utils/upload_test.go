@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/config"
+
+	local "github.com/eirka/eirka-post/config"
+)
+
+// testUpload writes data to a temp file the same way copyFile does
+func testUpload(t *testing.T, img *ImageType, data []byte) {
+	t.Helper()
+
+	assert.NoError(t, os.MkdirAll(local.Settings.Directories.ImageDir, 0755), "Failed to ensure image directory exists")
+	assert.NoError(t, img.writeTemp(bytes.NewReader(data)), "An error was not expected")
+
+	t.Cleanup(img.removeTemp)
+}
+
+// testTempBytes reads back the temp file
+func testTempBytes(t *testing.T, img *ImageType) []byte {
+	t.Helper()
+
+	data, err := img.readTemp()
+	assert.NoError(t, err, "An error was not expected")
+
+	return data
+}
+
+func TestHeaderWriter(t *testing.T) {
+	header := &headerWriter{max: 4}
+
+	n, err := header.Write([]byte("ab"))
+	assert.NoError(t, err, "An error was not expected")
+	assert.Equal(t, 2, n, "All bytes should be accepted")
+
+	n, _ = header.Write([]byte("cdef"))
+	assert.Equal(t, 4, n, "All bytes should be accepted")
+
+	header.Write([]byte("gh"))
+
+	assert.Equal(t, []byte("abcd"), header.buf, "Only the header should be kept")
+}
+
+func TestWriteTemp(t *testing.T) {
+	img := ImageType{}
+
+	data := testJpeg(100).Bytes()
+
+	testUpload(t, &img, data)
+
+	sum := md5.Sum(data)
+
+	assert.Equal(t, hex.EncodeToString(sum[:]), img.MD5, "Hash should match")
+	assert.NotEmpty(t, img.SHA, "SHA should be set")
+	assert.Equal(t, int64(len(data)), img.size, "Size should match")
+	assert.Equal(t, data[:headerSize], img.header, "Header should be the first bytes")
+	assert.True(t, strings.HasPrefix(img.tempName, ".upload-"), "Temp file should be hidden")
+	assert.Equal(t, data, testTempBytes(t, &img), "Contents should match")
+
+	// writing again replaces the old temp file
+	oldName := img.tempName
+	testUpload(t, &img, []byte("small"))
+
+	assert.Equal(t, []byte("small"), img.header, "Short files should be all header")
+
+	_, err := os.Stat(filepath.Join(local.Settings.Directories.ImageDir, oldName))
+	assert.True(t, os.IsNotExist(err), "Old temp file should be removed")
+
+	img.removeTemp()
+
+	_, err = img.readTemp()
+	assert.Error(t, err, "An error was expected")
+}
+
+func TestCopyFileLimit(t *testing.T) {
+	original := config.Settings.Limits.ImageMaxSize
+	defer func() { config.Settings.Limits.ImageMaxSize = original }()
+
+	assert.NoError(t, os.MkdirAll(local.Settings.Directories.ImageDir, 0755), "Failed to ensure image directory exists")
+
+	config.Settings.Limits.ImageMaxSize = 1024 * 1024
+
+	req := formJpegRequest(300, "test.jpeg")
+
+	file, header, err := req.FormFile("file")
+	assert.NoError(t, err, "An error was not expected")
+
+	img := ImageType{File: file, Header: header}
+	defer img.removeTemp()
+
+	assert.NoError(t, img.copyFile(), "An error was not expected")
+	assert.Equal(t, header.Size, img.size, "Size should match")
+
+	// the copy stops just past the limit
+	config.Settings.Limits.ImageMaxSize = 1024
+
+	file, header, err = req.FormFile("file")
+	assert.NoError(t, err, "An error was not expected")
+
+	large := ImageType{File: file, Header: header}
+	defer large.removeTemp()
+
+	err = large.copyFile()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Contains(t, err.Error(), "image filesize too large", "Error should match")
+	}
+	assert.Equal(t, int64(1025), large.size, "Copy should stop after the limit")
+
+	empty := ImageType{}
+	assert.Error(t, empty.copyFile(), "An error was expected")
+}
+
+func TestRenameTemp(t *testing.T) {
+	img := ImageType{}
+
+	testUpload(t, &img, []byte("image data"))
+
+	tempPath := filepath.Join(local.Settings.Directories.ImageDir, img.tempName)
+
+	img.Filename = "rename_test.jpg"
+	img.Filepath = filepath.Join(local.Settings.Directories.ImageDir, img.Filename)
+	defer os.Remove(img.Filepath)
+
+	assert.NoError(t, img.renameTemp(), "An error was not expected")
+	assert.Empty(t, img.tempName, "Temp name should be cleared")
+
+	_, err := os.Stat(tempPath)
+	assert.True(t, os.IsNotExist(err), "Temp file should be gone")
+
+	info, err := os.Stat(img.Filepath)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, os.FileMode(0644), info.Mode().Perm(), "File should be readable")
+	}
+
+	assert.Error(t, img.renameTemp(), "An error was expected")
+}
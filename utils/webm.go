@@ -1,13 +1,12 @@
 package utils
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -17,7 +16,7 @@ import (
 
 // Timeout constants for external video processing
 const (
-	ffmpegCheckTimeout = 10 * time.Second // Timeout for initial ffmpeg/ffprobe version checks
+	ffmpegCheckTimeout = 10 * time.Second // Timeout for ffmpeg/ffprobe version checks
 	ffmpegOpTimeout    = 60 * time.Second // Timeout for ffmpeg/ffprobe operations
 )
 
@@ -43,41 +42,69 @@ var allowedAudioCodecs = map[string]bool{
 	"opus":   true,
 }
 
-func init() {
-	var err error
+// allowed mp4 and mov codecs
+var allowedMP4Codecs = map[string]bool{
+	"h264": true,
+	"hevc": true,
+}
 
-	// Create context with timeout for testing ffprobe
-	ctx1, cancel1 := context.WithTimeout(context.Background(), ffmpegCheckTimeout)
-	defer cancel1()
+// allowed mp4 and mov audio codecs if audio stream is present
+var allowedMP4AudioCodecs = map[string]bool{
+	"aac": true,
+}
 
-	// Test for ffprobe with timeout
-	cmd1 := exec.CommandContext(ctx1, "ffprobe", "-version")
-	_, err = cmd1.Output()
-	if err != nil {
-		if ctx1.Err() == context.DeadlineExceeded {
-			panic(fmt.Sprintf("ffprobe check timed out after %v", ffmpegCheckTimeout))
-		}
-		panic("ffprobe not found")
-	}
+// videoContainer lists the types and codecs allowed for an ffprobe format
+type videoContainer struct {
+	mimes       []string
+	videoCodecs map[string]bool
+	mediaNames  string
+	audioCodecs map[string]bool
+	audioNames  string
+}
+
+// allowed containers by ffprobe format name
+var videoContainers = map[string]videoContainer{
+	"matroska,webm": {
+		mimes:       []string{"video/webm"},
+		videoCodecs: allowedCodecs,
+		mediaNames:  "VP8 or VP9",
+		audioCodecs: allowedAudioCodecs,
+		audioNames:  "Vorbis or Opus",
+	},
+	"mov,mp4,m4a,3gp,3g2,mj2": {
+		mimes:       []string{"video/mp4", "video/quicktime"},
+		videoCodecs: allowedMP4Codecs,
+		mediaNames:  "H.264 or H.265",
+		audioCodecs: allowedMP4AudioCodecs,
+		audioNames:  "AAC",
+	},
+}
 
-	// Create context with timeout for testing ffmpeg
-	ctx2, cancel2 := context.WithTimeout(context.Background(), ffmpegCheckTimeout)
-	defer cancel2()
+// checkFFprobe will make sure ffprobe is installed
+func checkFFprobe() (err error) {
+	return checkCommand("ffprobe", ffmpegCheckTimeout, "ffprobe", "-version")
+}
 
-	// Test for ffmpeg with timeout
-	cmd2 := exec.CommandContext(ctx2, "ffmpeg", "-version")
-	_, err = cmd2.Output()
-	if err != nil {
-		if ctx2.Err() == context.DeadlineExceeded {
-			panic(fmt.Sprintf("ffmpeg check timed out after %v", ffmpegCheckTimeout))
-		}
-		panic("ffmpeg not found")
-	}
+// checkFFmpeg will make sure ffmpeg is installed
+func checkFFmpeg() (err error) {
+	return checkCommand("ffmpeg", ffmpegCheckTimeout, "ffmpeg", "-version")
 }
 
 // check webm metadata to make sure its the correct type of video, size, etc
+// mp4 and mov files go through the same checks with their own codecs
 func (i *ImageType) checkWebM() (err error) {
 
+	ffprobe, err := i.probe()
+	if err != nil {
+		return
+	}
+
+	return i.validateVideo(ffprobe)
+}
+
+// probe runs ffprobe on the saved file
+func (i *ImageType) probe() (ffprobe ffprobe, err error) {
+
 	ffprobeArgs := []string{
 		"-v",
 		"quiet",
@@ -88,78 +115,91 @@ func (i *ImageType) checkWebM() (err error) {
 		i.Filepath,
 	}
 
-	// Create context with timeout for ffprobe operations
-	ctx, cancel := context.WithTimeout(context.Background(), ffmpegOpTimeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "ffprobe", ffprobeArgs...)
-	output, err := cmd.Output()
+	output, err := runMedia(ffmpegOpTimeout, "ffprobe", ffprobeArgs...)
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("ffprobe operation timed out after %v", ffmpegOpTimeout)
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			return
+		case errors.Is(err, errMediaTimeout):
+			return ffprobe, fmt.Errorf("ffprobe operation timed out after %v", ffmpegOpTimeout)
 		}
-		return errors.New("problem decoding webm")
+		return ffprobe, fmt.Errorf("problem decoding %s", mediaName(i.mime))
 	}
 
-	ffprobe := ffprobe{}
-
 	err = json.Unmarshal(output, &ffprobe)
 	if err != nil {
-		return errors.New("problem decoding webm")
+		return ffprobe, fmt.Errorf("problem decoding %s", mediaName(i.mime))
 	}
 
+	return
+}
+
+// validateVideo checks the ffprobe output against the container rules and limits
+func (i *ImageType) validateVideo(ffprobe ffprobe) (err error) {
+
+	name := mediaName(i.mime)
+
 	// 1. Check file format
-	if ffprobe.Format.FormatName != "matroska,webm" {
-		return errors.New("file is not a webm")
+	container, ok := videoContainers[ffprobe.Format.FormatName]
+	if !ok || !slices.Contains(container.mimes, i.mime) {
+		return fmt.Errorf("file is not a %s", name)
+	}
+
+	// 2. Validate stream count, data streams like timecodes are ignored
+	var streams []ffprobeStream
+
+	for _, stream := range ffprobe.Streams {
+		if stream.CodecType != "data" {
+			streams = append(streams, stream)
+		}
 	}
 
-	// 2. Validate stream count
-	if len(ffprobe.Streams) < minStreamCount {
-		return errors.New("webm contains no streams")
+	if len(streams) < minStreamCount {
+		return fmt.Errorf("%s contains no streams", name)
 	}
 
-	if len(ffprobe.Streams) > maxStreamCount {
-		return errors.New("webm contains too many streams")
+	if len(streams) > maxStreamCount {
+		return fmt.Errorf("%s contains too many streams", name)
 	}
 
 	// 3. Find video stream
 	var videoStream *ffprobeStream
 	var audioStream *ffprobeStream
 
-	for i, stream := range ffprobe.Streams {
+	for i, stream := range streams {
 		if stream.CodecType == "video" && videoStream == nil {
-			videoStream = &ffprobe.Streams[i]
+			videoStream = &streams[i]
 		} else if stream.CodecType == "audio" && audioStream == nil {
-			audioStream = &ffprobe.Streams[i]
+			audioStream = &streams[i]
 		}
 	}
 
 	// 4. Ensure we have a video stream
 	if videoStream == nil {
-		return errors.New("webm contains no video stream")
+		return fmt.Errorf("%s contains no video stream", name)
 	}
 
 	// 5. Validate video codec
 	codecName := strings.ToLower(videoStream.CodecName)
-	if !allowedCodecs[codecName] {
-		return fmt.Errorf("video codec '%s' is not allowed, must be VP8 or VP9", videoStream.CodecName)
+	if !container.videoCodecs[codecName] {
+		return fmt.Errorf("video codec '%s' is not allowed, must be %s", videoStream.CodecName, container.mediaNames)
 	}
 
 	// 6. Check audio stream if present
 	if audioStream != nil {
-		if !allowedAudioCodecs[strings.ToLower(audioStream.CodecName)] {
-			return fmt.Errorf("audio codec '%s' is not allowed, must be Vorbis or Opus", audioStream.CodecName)
+		if !container.audioCodecs[strings.ToLower(audioStream.CodecName)] {
+			return fmt.Errorf("audio codec '%s' is not allowed, must be %s", audioStream.CodecName, container.audioNames)
 		}
 	}
 
 	// 7. Parse and validate file duration
 	duration, err := strconv.ParseFloat(ffprobe.Format.Duration, 64)
 	if err != nil {
-		return errors.New("problem decoding webm duration")
+		return fmt.Errorf("problem decoding %s duration", name)
 	}
 
 	if duration <= 0 {
-		return errors.New("webm has invalid duration")
+		return fmt.Errorf("%s has invalid duration", name)
 	}
 
 	// set file duration
@@ -168,30 +208,35 @@ func (i *ImageType) checkWebM() (err error) {
 	// 8. Check file size
 	originalSize, err := strconv.ParseFloat(ffprobe.Format.Size, 64)
 	if err != nil {
-		return errors.New("problem decoding webm size")
+		return fmt.Errorf("problem decoding %s size", name)
 	}
 
 	if originalSize <= 0 {
-		return errors.New("webm has invalid size")
+		return fmt.Errorf("%s has invalid size", name)
 	}
 
 	// 9. Set and validate dimensions
 	i.OrigWidth = videoStream.Width
 	i.OrigHeight = videoStream.Height
 
+	// phones record sideways and set a rotation for the player
+	if videoStream.rotated() {
+		i.OrigWidth, i.OrigHeight = i.OrigHeight, i.OrigWidth
+	}
+
 	if i.OrigWidth <= 0 || i.OrigHeight <= 0 {
-		return errors.New("webm has invalid dimensions")
+		return fmt.Errorf("%s has invalid dimensions", name)
 	}
 
 	// 10. Parse and validate framerate
 	framerate, err := parseFramerate(videoStream.AvgFrameRate)
 	if err != nil {
-		return fmt.Errorf("webm has invalid framerate: %v", err)
+		return fmt.Errorf("%s has invalid framerate: %v", name, err)
 	}
 
 	if framerate < minVideoFramerate || framerate > maxVideoFramerate {
-		return fmt.Errorf("webm framerate %.2f fps is outside allowed range (%d-%d fps)",
-			framerate, minVideoFramerate, maxVideoFramerate)
+		return fmt.Errorf("%s framerate %.2f fps is outside allowed range (%d-%d fps)",
+			name, framerate, minVideoFramerate, maxVideoFramerate)
 	}
 
 	// 11. Check bitrate
@@ -199,48 +244,72 @@ func (i *ImageType) checkWebM() (err error) {
 		bitrate, err := strconv.ParseInt(ffprobe.Format.BitRate, 10, 64)
 		if err == nil && bitrate > 0 {
 			if bitrate < minVideoBitrate {
-				return fmt.Errorf("webm bitrate %d bps is too low (min: %d bps)",
-					bitrate, minVideoBitrate)
+				return fmt.Errorf("%s bitrate %d bps is too low (min: %d bps)",
+					name, bitrate, minVideoBitrate)
 			}
 			if bitrate > maxVideoBitrate {
-				return fmt.Errorf("webm bitrate %d bps is too high (max: %d bps)",
-					bitrate, maxVideoBitrate)
+				return fmt.Errorf("%s bitrate %d bps is too high (max: %d bps)",
+					name, bitrate, maxVideoBitrate)
 			}
 		}
 	}
 
 	// 12. Final size checks against config limits
 	switch {
-	case i.OrigWidth > config.Settings.Limits.ImageMaxWidth:
-		return fmt.Errorf("webm width %d px is too large (max: %d px)",
-			i.OrigWidth, config.Settings.Limits.ImageMaxWidth)
+	case i.OrigWidth > i.maxWidth():
+		return fmt.Errorf("%s width %d px is too large (max: %d px)",
+			name, i.OrigWidth, i.maxWidth())
 	case i.OrigWidth < config.Settings.Limits.ImageMinWidth:
-		return fmt.Errorf("webm width %d px is too small (min: %d px)",
-			i.OrigWidth, config.Settings.Limits.ImageMinWidth)
-	case i.OrigHeight > config.Settings.Limits.ImageMaxHeight:
-		return fmt.Errorf("webm height %d px is too large (max: %d px)",
-			i.OrigHeight, config.Settings.Limits.ImageMaxHeight)
+		return fmt.Errorf("%s width %d px is too small (min: %d px)",
+			name, i.OrigWidth, config.Settings.Limits.ImageMinWidth)
+	case i.OrigHeight > i.maxHeight():
+		return fmt.Errorf("%s height %d px is too large (max: %d px)",
+			name, i.OrigHeight, i.maxHeight())
 	case i.OrigHeight < config.Settings.Limits.ImageMinHeight:
-		return fmt.Errorf("webm height %d px is too small (min: %d px)",
-			i.OrigHeight, config.Settings.Limits.ImageMinHeight)
-	case int(originalSize) > config.Settings.Limits.ImageMaxSize:
-		return fmt.Errorf("webm file size %.2f MB is too large (max: %.2f MB)",
-			originalSize/(1024*1024), float64(config.Settings.Limits.ImageMaxSize)/(1024*1024))
-	case i.duration > config.Settings.Limits.WebmMaxLength:
-		return fmt.Errorf("webm duration %d sec is too long (max: %d sec)",
-			i.duration, config.Settings.Limits.WebmMaxLength)
+		return fmt.Errorf("%s height %d px is too small (min: %d px)",
+			name, i.OrigHeight, config.Settings.Limits.ImageMinHeight)
+	case int(originalSize) > i.maxSize():
+		return fmt.Errorf("%s file size %.2f MB is too large (max: %.2f MB)",
+			name, originalSize/(1024*1024), float64(i.maxSize())/(1024*1024))
+	case i.duration > i.maxVideoLength():
+		return fmt.Errorf("%s duration %d sec is too long (max: %d sec)",
+			name, i.duration, i.maxVideoLength())
 	}
 
 	return
 
 }
 
-// create a webm thumbnail from the first frames
+// mediaName is the name used in error messages for a video or audio type
+func mediaName(mime string) string {
+	switch mime {
+	case "video/mp4":
+		return "mp4"
+	case "video/quicktime":
+		return "mov"
+	case "audio/mpeg":
+		return "mp3"
+	case "audio/ogg":
+		return "ogg"
+	case "audio/flac":
+		return "flac"
+	}
+
+	return "webm"
+}
+
+// create a video thumbnail from the most representative frame
 func (i *ImageType) createWebMThumbnail() (err error) {
 
+	// try frames across the clip first
+	frame := i.pickVideoFrame()
+	if frame != nil {
+		return i.writeFrame(frame)
+	}
+
 	var timepoint string
 
-	// set the time when the screenshot is taken depending on video duration
+	// nothing usable was found so use a fixed point in the clip
 	if i.duration > 5 {
 		timepoint = "00:00:05"
 	} else {
@@ -272,15 +341,13 @@ func (i *ImageType) createWebMThumbnail() (err error) {
 		tempThumbPath,
 	}
 
-	// Create context with timeout for ffmpeg operations
-	ctx, cancel := context.WithTimeout(context.Background(), ffmpegOpTimeout)
-	defer cancel()
-
 	// Make an image of first frame with ffmpeg
-	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs...)
-	_, err = cmd.Output()
+	_, err = runMedia(ffmpegOpTimeout, "ffmpeg", ffmpegArgs...)
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			return
+		case errors.Is(err, errMediaTimeout):
 			return fmt.Errorf("ffmpeg operation timed out after %v", ffmpegOpTimeout)
 		}
 		return errors.New("problem creating thumbnail from webm")
@@ -297,6 +364,28 @@ func (i *ImageType) createWebMThumbnail() (err error) {
 
 }
 
+// writeFrame saves the chosen frame where the thumbnail will be made from
+func (i *ImageType) writeFrame(frame []byte) (err error) {
+	root, err := os.OpenRoot(filepath.Dir(i.Thumbpath))
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer root.Close()
+
+	file, err := root.Create(filepath.Base(i.Thumbpath))
+	if err != nil {
+		return errors.New("problem creating thumbnail file")
+	}
+	defer file.Close()
+
+	_, err = file.Write(frame)
+	if err != nil {
+		return errors.New("problem writing thumbnail file")
+	}
+
+	return
+}
+
 // parseFramerate parses the framerate string from ffprobe output (e.g. "24/1")
 func parseFramerate(fpsStr string) (float64, error) {
 	parts := strings.Split(fpsStr, "/")
@@ -355,6 +444,25 @@ type ffprobeStream struct {
 		CleanEffects    int `json:"clean_effects"`
 		AttachedPic     int `json:"attached_pic"`
 	} `json:"disposition"`
+	Tags struct {
+		Rotate string `json:"rotate"` // older ffprobe versions
+	} `json:"tags"`
+	SideDataList []struct {
+		Rotation int `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+// rotated is true if the player turns the video a quarter turn
+func (s ffprobeStream) rotated() bool {
+	rotation, _ := strconv.Atoi(s.Tags.Rotate)
+
+	for _, side := range s.SideDataList {
+		if side.Rotation != 0 {
+			rotation = side.Rotation
+		}
+	}
+
+	return rotation%180 != 0
 }
 
 // ffprobe json format
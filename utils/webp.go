@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// vp8x flags
+const (
+	webpAnimationBit = 1 << 1
+	webpAlphaBit     = 1 << 4
+)
+
+// webpChunk is a single chunk from a riff container
+type webpChunk struct {
+	id   string
+	data []byte
+	// raw is the whole chunk including the header and padding
+	raw []byte
+}
+
+// isWebP checks for the riff webp signature
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// webpChunks splits a riff container into its chunks
+func webpChunks(data []byte) (chunks []webpChunk, err error) {
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, errors.New("problem reading webp chunks")
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+
+		end := pos + 8 + size
+		if size < 0 || end > len(data) {
+			return nil, errors.New("problem reading webp chunks")
+		}
+
+		// chunks are padded to an even size
+		padded := end + size%2
+		if padded > len(data) {
+			padded = len(data)
+		}
+
+		chunks = append(chunks, webpChunk{
+			id:   string(data[pos : pos+4]),
+			data: data[pos+8 : end],
+			raw:  data[pos:padded],
+		})
+
+		pos = padded
+	}
+
+	return
+}
+
+// webpAnimated is true if the vp8x header has the animation flag
+func webpAnimated(data []byte) bool {
+	if !isWebP(data) {
+		return false
+	}
+
+	chunks, err := webpChunks(data[12:])
+	if err != nil || len(chunks) == 0 {
+		return false
+	}
+
+	return chunks[0].id == "VP8X" && len(chunks[0].data) >= 1 && chunks[0].data[0]&webpAnimationBit != 0
+}
+
+// webpFirstFrame rewrites an animated webp as a still image of its first frame
+// so it can be read by the decoder, other files are returned as is
+func webpFirstFrame(data []byte) ([]byte, error) {
+	if !webpAnimated(data) {
+		return data, nil
+	}
+
+	chunks, err := webpChunks(data[12:])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chunk := range chunks {
+		if chunk.id != "ANMF" {
+			continue
+		}
+
+		// offsets, size, duration, and flags come before the frame chunks
+		if len(chunk.data) < 16 {
+			return nil, errors.New("problem reading webp frame")
+		}
+
+		frame := chunk.data[16:]
+
+		subchunks, err := webpChunks(frame)
+		if err != nil {
+			return nil, err
+		}
+
+		var flags byte
+		for _, sub := range subchunks {
+			if sub.id == "ALPH" {
+				flags |= webpAlphaBit
+			}
+		}
+
+		// a still vp8x header with the frame dimensions
+		vp8x := []byte{'V', 'P', '8', 'X', 10, 0, 0, 0, flags, 0, 0, 0}
+		vp8x = append(vp8x, chunk.data[6:12]...)
+
+		out := new(bytes.Buffer)
+		out.WriteString("RIFF")
+		binary.Write(out, binary.LittleEndian, uint32(4+len(vp8x)+len(frame)))
+		out.WriteString("WEBP")
+		out.Write(vp8x)
+		out.Write(frame)
+
+		return out.Bytes(), nil
+	}
+
+	return nil, errors.New("webp has no frames")
+}
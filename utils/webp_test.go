@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eirka/eirka-libs/config"
+)
+
+// a 1x1 lossless webp
+const testWebPStill = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+// testChunk builds a riff chunk with padding
+func testChunk(id string, data []byte) []byte {
+	chunk := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// testWebPAnimated wraps the still image in a two frame animation
+func testWebPAnimated() []byte {
+	still, _ := base64.StdEncoding.DecodeString(testWebPStill)
+
+	// the vp8l chunk of the still
+	frame := still[12:]
+
+	// x, y, width-1, height-1, duration, flags
+	header := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0}
+	anmf := testChunk("ANMF", append(header, frame...))
+
+	body := []byte("WEBP")
+	body = append(body, testChunk("VP8X", []byte{webpAnimationBit, 0, 0, 0, 0, 0, 0, 0, 0, 0})...)
+	body = append(body, testChunk("ANIM", []byte{255, 255, 255, 255, 0, 0})...)
+	body = append(body, anmf...)
+	body = append(body, anmf...)
+
+	out := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(out, body...)
+}
+
+func TestIsWebP(t *testing.T) {
+	still, _ := base64.StdEncoding.DecodeString(testWebPStill)
+
+	assert.True(t, isWebP(still), "Still should be a webp")
+	assert.True(t, isWebP(testWebPAnimated()), "Animation should be a webp")
+	assert.False(t, isWebP(testJpeg(10).Bytes()), "Jpeg should not be a webp")
+	assert.False(t, isWebP([]byte("RIFF")), "Short file should not be a webp")
+
+	assert.Equal(t, "image/webp", detectContentType(still), "Mime should match")
+}
+
+func TestWebPAnimated(t *testing.T) {
+	still, _ := base64.StdEncoding.DecodeString(testWebPStill)
+
+	assert.False(t, webpAnimated(still), "Still should not be animated")
+	assert.True(t, webpAnimated(testWebPAnimated()), "Animation should be animated")
+}
+
+func TestWebPFirstFrame(t *testing.T) {
+	still, _ := base64.StdEncoding.DecodeString(testWebPStill)
+
+	frame, err := webpFirstFrame(still)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, still, frame, "Stills should not change")
+	}
+
+	animated := testWebPAnimated()
+
+	// the decoder cant read animations directly
+	_, _, err = image.Decode(bytes.NewReader(animated))
+	assert.Error(t, err, "An error was expected")
+
+	img, err := decodeFrame(animated)
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, image.Rect(0, 0, 1, 1), img.Bounds(), "Frame size should match")
+	}
+
+	// dimensions come from the canvas
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(animated))
+	if assert.NoError(t, err, "An error was not expected") {
+		assert.Equal(t, "webp", format, "Format should match")
+		assert.Equal(t, 1, cfg.Width, "Width should match")
+	}
+
+	// no frames at all
+	empty := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, 22)...)
+	empty = append(empty, "WEBP"...)
+	empty = append(empty, testChunk("VP8X", []byte{webpAnimationBit, 0, 0, 0, 0, 0, 0, 0, 0, 0})...)
+
+	_, err = webpFirstFrame(empty)
+	assert.Error(t, err, "An error was expected")
+}
+
+func TestGetStatsWebP(t *testing.T) {
+	config.Settings.Limits.ImageMaxWidth = 1000
+	config.Settings.Limits.ImageMinWidth = 100
+	config.Settings.Limits.ImageMaxHeight = 1000
+	config.Settings.Limits.ImageMinHeight = 100
+	config.Settings.Limits.ImageMaxSize = 300000
+
+	img := ImageType{
+		mime: "image/webp",
+	}
+
+	testUpload(t, &img, testWebPAnimated())
+
+	err := img.getStats()
+	if assert.Error(t, err, "An error was expected") {
+		assert.Contains(t, err.Error(), "image width too small", "Dimensions should be read")
+	}
+	assert.Equal(t, 1, img.OrigWidth, "Width should match")
+	assert.Equal(t, 1, img.OrigHeight, "Height should match")
+}